@@ -14,7 +14,8 @@ type logResponseWriter struct {
 	lh      *logHandler
 	Start   time.Time
 	Request *http.Request
-	Logged  bool // Whether this request has been logged.
+	Logged  bool     // Whether this request has been logged.
+	Build   *request // Parsed build/result request, if any. Logged if config.AccessLogBuildDetails is set.
 	http.ResponseWriter
 }
 
@@ -66,7 +67,21 @@ func (lw *logResponseWriter) LogStatus(statusCode int) {
 
 	now := time.Now()
 	ms := now.Sub(lw.Start).Milliseconds()
-	text := fmt.Sprintf("%s %d %d %s %s %s %q\n", now.Format(time.RFC3339), ms, statusCode, lw.Request.RemoteAddr, noctl(lw.Request.Method), noctl(lw.Request.RequestURI), noctl(lw.Request.UserAgent()))
+	ip := clientIP(lw.Request)
+	if ip == "" {
+		// Keep the fields of the line intact, e.g. for listeners without IP addresses.
+		ip = "-"
+	}
+	text := fmt.Sprintf("%s %d %d %s %s %s %q", now.Format(time.RFC3339), ms, statusCode, ip, noctl(lw.Request.Method), noctl(lw.Request.RequestURI), noctl(lw.Request.UserAgent()))
+	if config.AccessLogBuildDetails && lw.Build != nil {
+		b := lw.Build
+		sum := b.Sum
+		if sum == "" {
+			sum = "-"
+		}
+		text += fmt.Sprintf(" mod=%s version=%s target=%s/%s goversion=%s sum=%s", noctl(b.Mod), noctl(b.Version), b.Goos, b.Goarch, noctl(b.Goversion), noctl(sum))
+	}
+	text += "\n"
 	line := logLine{text: text}
 	line.date.year, line.date.month, line.date.day = now.Date()
 
@@ -95,7 +110,7 @@ type logHandler struct {
 }
 
 func (lh *logHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	lw := &logResponseWriter{lh, time.Now(), r, false, w}
+	lw := &logResponseWriter{lh, time.Now(), r, false, nil, w}
 	lh.h.ServeHTTP(lw, r)
 }
 
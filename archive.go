@@ -0,0 +1,254 @@
+package main
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/semver"
+)
+
+// Maximum number of main packages in a module for an archive. Each is a separate
+// build, so an archive of a module with many commands is expensive.
+const maxArchiveMains = 25
+
+// archiveLink returns the path for the zip file with the binaries of all main
+// packages of the module in bs, as served by serveArchive.
+func archiveLink(bs buildSpec) string {
+	return fmt.Sprintf("/archive/%s@%s/%s-%s-%s%s.zip", bs.Mod, bs.Version, bs.Goos, bs.Goarch, bs.Goversion, bs.variantSuffix())
+}
+
+// serveArchive serves a zip file with binaries of all main packages of a module,
+// at paths like /archive/<module>@<version>/<goos>-<goarch>-<goversion>.zip, with
+// the variant suffixes of build URLs. The version must be explicit. Each binary
+// is a regular build, with its record in the transparency log, and is built
+// first if needed. Binaries are named after their package directory and target,
+// e.g. cmd/x-linux-amd64. The zip file has a file "sums.txt" with the sum and
+// name of each binary.
+func serveArchive(w http.ResponseWriter, r *http.Request) {
+	defer observePage("archive", time.Now())
+
+	if r.Method != "GET" {
+		http.Error(w, "405 - Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s := strings.TrimPrefix(r.URL.Path, "/archive/")
+	s, ok := strings.CutSuffix(s, ".zip")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	bs, err := parseBuildSpec(s + "/")
+	if err != nil {
+		failf(w, "%w: bad module@version/goos-goarch-goversion: %v", errBadModule, err)
+		return
+	}
+	if !semver.IsValid(bs.Version) {
+		failf(w, "%w: archives require an explicit version, not %q", errBadVersion, bs.Version)
+		return
+	}
+	if !checkAllowedRespond(w, bs.Mod, bs.Version) {
+		return
+	}
+
+	goversion, err := parseGoVersion(bs.Goversion)
+	if err != nil {
+		failf(w, "%w: %v", errBadGoversion, err)
+		return
+	}
+	if _, err := ensureSDK(bs.Goversion); err != nil {
+		failf(w, "ensuring toolchain %q: %w", bs.Goversion, err)
+		return
+	}
+	gobin, err := ensureGobin(bs.Goversion)
+	if err != nil {
+		failf(w, "%w", err)
+		return
+	}
+	modDir, getOutput, err := ensureModule(bs.Goversion, gobin, bs.Mod, bs.Version)
+	if err != nil {
+		failf(w, "error fetching module from goproxy: %w\n\n# output from go get:\n%s", err, string(getOutput))
+		return
+	}
+	mainDirs, err := listMainPackages(goversion, gobin, modDir)
+	if err != nil {
+		failf(w, "listing main packages in module: %w", err)
+		return
+	} else if len(mainDirs) == 0 {
+		failf(w, "no main packages in module")
+		return
+	} else if len(mainDirs) > maxArchiveMains {
+		failf(w, "module has %d main packages, archives are limited to %d, download binaries separately", len(mainDirs), maxArchiveMains)
+		return
+	}
+
+	// Find existing results, and start builds for the others.
+	type archiveBuild struct {
+		bs     buildSpec
+		br     *buildResult
+		eventc chan buildUpdate // Non-nil while waiting for a build.
+	}
+	builds := make([]archiveBuild, len(mainDirs))
+	defer func() {
+		for _, b := range builds {
+			if b.eventc != nil {
+				unregisterBuild(b.bs, b.eventc)
+			}
+		}
+	}()
+	for i, md := range mainDirs {
+		pbs := bs
+		pbs.Dir = "/" + strings.TrimSuffix(filepath.ToSlash(md), "/")
+		builds[i].bs = pbs
+
+		_, br, binaryPresent, failed, err := serverOps{}.lookupResult(r.Context(), pbs)
+		if err != nil {
+			failf(w, "%w: lookup record: %v", errServer, err)
+			return
+		} else if failed {
+			failf(w, "build of %s failed, see %s", pbs.Dir, request{pbs, "", pageIndex}.link())
+			return
+		} else if br != nil && binaryPresent {
+			builds[i].br = br
+			continue
+		}
+
+		if config.ReadOnly {
+			failReadOnly(w)
+			return
+		}
+		if handleBadClient(w, r) {
+			return
+		}
+		if br == nil {
+			if err := checkVersionAge(r, pbs); err != nil {
+				failPrepare(w, pbs, err)
+				return
+			}
+		}
+		if err := prepareBuild(r.Context(), pbs, kindOf(br)); err != nil {
+			failf(w, "preparing build of %s: %w", pbs.Dir, err)
+			return
+		}
+		var expSum string
+		if br != nil {
+			expSum = br.Sum
+		}
+		builds[i].eventc = make(chan buildUpdate, 100)
+		registerBuild(pbs, expSum, false, builds[i].eventc)
+	}
+
+	// The coordinator drops updates for full channels, so we read from all channels
+	// while waiting, not one after the other.
+	ctx := r.Context()
+	type archiveUpdate struct {
+		index  int
+		update buildUpdate
+	}
+	donec := make(chan archiveUpdate)
+	var pending int
+	for i, b := range builds {
+		if b.eventc == nil {
+			continue
+		}
+		pending++
+		go func(i int, eventc chan buildUpdate) {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case update := <-eventc:
+					if !update.done {
+						continue
+					}
+					select {
+					case donec <- archiveUpdate{i, update}:
+					case <-ctx.Done():
+					}
+					return
+				}
+			}
+		}(i, b.eventc)
+	}
+	for ; pending > 0; pending-- {
+		select {
+		case <-ctx.Done():
+			return
+		case au := <-donec:
+			b := &builds[au.index]
+			unregisterBuild(b.bs, b.eventc)
+			b.eventc = nil
+			if au.update.err != nil {
+				failf(w, "build of %s failed: %w", b.bs.Dir, au.update.err)
+				return
+			}
+			br := *au.update.result
+			b.br = &br
+		}
+	}
+
+	// Open all binaries before writing the response, so we can still return an error.
+	files := make([]resultFile, len(builds))
+	defer func() {
+		for _, f := range files {
+			if f != nil {
+				f.Close()
+			}
+		}
+	}()
+	for i, b := range builds {
+		f, err := results.Open(b.bs.storeKey(), "binary.gz")
+		if err != nil {
+			failf(w, "%w: open binary of %s: %v", errServer, b.bs.Dir, err)
+			return
+		}
+		files[i] = f
+	}
+
+	name := fmt.Sprintf("%s-%s-%s-%s-%s%s.zip", filepath.Base(bs.Mod), bs.Version, bs.Goos, bs.Goarch, bs.Goversion, bs.variantSuffix())
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	zw := zip.NewWriter(w)
+	var sums strings.Builder
+	for i, b := range builds {
+		// Full package directory, base names of e.g. cmd/x and tools/x are the same.
+		filename := b.bs.targetFilename()
+		if b.bs.Dir != "/" {
+			filename = path.Join(path.Dir(b.bs.Dir[1:]), filename)
+		}
+		fmt.Fprintf(&sums, "%s %s\n", b.br.Sum, filename)
+		fi, err := files[i].Stat()
+		if err != nil {
+			logCheck(err, "stat binary for archive", "buildspec", b.bs)
+			return
+		}
+		gzr, err := gzip.NewReader(files[i])
+		if err != nil {
+			logCheck(err, "gzip reader for binary for archive", "buildspec", b.bs)
+			return
+		}
+		fh := &zip.FileHeader{Name: filename, Method: zip.Deflate, Modified: fi.ModTime()}
+		fh.SetMode(0755)
+		zf, err := zw.CreateHeader(fh)
+		if err != nil {
+			return // Nothing to do for errors, response has started.
+		}
+		if _, err := io.Copy(zf, gzr); err != nil {
+			return // Nothing to do for errors, typically the client went away.
+		}
+	}
+	if zf, err := zw.CreateHeader(&zip.FileHeader{Name: "sums.txt", Method: zip.Deflate, Modified: time.Now()}); err != nil {
+		return
+	} else if _, err := zf.Write([]byte(sums.String())); err != nil {
+		return
+	}
+	zw.Close() // Nothing to do for errors.
+}
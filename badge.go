@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// badgeResponse is returned by the /badge.json endpoint, in the format of
+// shields.io endpoint badges, https://shields.io/badges/endpoint-badge.
+type badgeResponse struct {
+	SchemaVersion int    `json:"schemaVersion"` // Always 1.
+	Label         string `json:"label"`
+	Message       string `json:"message"`
+	Color         string `json:"color"`
+	IsError       bool   `json:"isError,omitempty"`
+	CacheSeconds  int    `json:"cacheSeconds,omitempty"`
+}
+
+// serveBadge returns a shields.io endpoint badge with the status of the build of
+// the latest version of a module with the latest Go toolchain for the target,
+// including pinned Go versions. Query parameters: "module", "dir" (default "/")
+// and "target" (default linux/amd64). The message is the version and binary
+// size for a successful build. Builds are not started.
+func serveBadge(w http.ResponseWriter, r *http.Request) {
+	defer observePage("badge", time.Now())
+
+	if r.Method != "GET" {
+		http.Error(w, "405 - Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	mod := r.FormValue("module")
+	dir := r.FormValue("dir")
+	if dir == "" {
+		dir = "/"
+	}
+	target := r.FormValue("target")
+	if target == "" {
+		target = "linux/amd64"
+	}
+	if mod == "" || path.Clean(mod) != mod || !strings.HasPrefix(dir, "/") || path.Clean(dir) != dir {
+		http.Error(w, "400 - bad request - missing or invalid module or dir", http.StatusBadRequest)
+		return
+	}
+	goos, goarch, _ := strings.Cut(target, "/")
+	if !targets.valid(goos + "/" + goarch) {
+		http.Error(w, "400 - bad request - unknown target", http.StatusBadRequest)
+		return
+	}
+	if !checkAllowedRespond(w, mod, "latest") {
+		return
+	}
+
+	resp := badgeResponse{SchemaVersion: 1, Label: "gobuild " + goos + "/" + goarch, CacheSeconds: 300}
+	goversion := latestGoversion(goos, goarch)
+	if goversion == "" {
+		resp.Message = "no toolchain"
+		resp.Color = "lightgrey"
+		resp.IsError = true
+		writeBadge(w, resp)
+		return
+	}
+	info, err := resolveModuleVersion(r.Context(), mod, "latest")
+	if err != nil {
+		slog.Debug("resolving module version for badge", "err", err, "mod", mod)
+		resp.Message = "unknown module"
+		resp.Color = "lightgrey"
+		resp.IsError = true
+		writeBadge(w, resp)
+		return
+	}
+
+	bs := buildSpec{mod, info.Version, dir, goos, goarch, goversion, false, false, false, false, "", "", ""}
+	_, br, _, failed, err := serverOps{}.lookupResult(r.Context(), bs)
+	if err != nil {
+		failf(w, "%w: looking up result: %v", errServer, err)
+		return
+	}
+	if failed {
+		resp.Message = info.Version + ", build failed"
+		resp.Color = "red"
+	} else if br != nil {
+		resp.Message = fmt.Sprintf("%s, %.1f MB", info.Version, float64(br.Filesize)/(1024*1024))
+		resp.Color = "green"
+	} else {
+		resp.Message = info.Version + ", not built"
+		resp.Color = "lightgrey"
+	}
+	writeBadge(w, resp)
+}
+
+func writeBadge(w http.ResponseWriter, resp badgeResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", resp.CacheSeconds))
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.Debug("writing badge response", "err", err)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/semver"
+)
+
+// batchLink returns the path for the SSE stream of serveBatch, that builds the
+// module, package, target and variant of bs with all supported go versions.
+func batchLink(bs buildSpec) string {
+	return "/batch" + request{bs, "", pageIndex}.link() + "events"
+}
+
+// batchTarget is sent at the start of a batch stream, for each go version.
+type batchTarget struct {
+	Goversion string
+	Link      string // Build page.
+}
+
+// serveBatch builds a package with all supported go versions, for comparing sums
+// and sizes, at paths like /batch/<module>@<version>/<dir>/<goos>-<goarch>-<goversion>/events,
+// with the variant suffixes of build URLs. The goversion in the path is ignored.
+// The response is an SSE stream. It starts with a "targets" event with a JSON
+// list of batchTarget. It is followed by the same "update" events as the events
+// endpoint of builds, each with the go version as event id. Existing results are
+// sent immediately. The stream ends after all builds are done.
+func serveBatch(w http.ResponseWriter, r *http.Request) {
+	defer observePage("batch", time.Now())
+
+	if r.Method != "GET" {
+		http.Error(w, "405 - Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s := strings.TrimPrefix(r.URL.Path, "/batch/")
+	s, ok := strings.CutSuffix(s, "/events")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	bs, err := parseBuildSpec(s + "/")
+	if err != nil {
+		failf(w, "%w: bad module@version/dir/goos-goarch-goversion: %v", errBadModule, err)
+		return
+	}
+	if !semver.IsValid(bs.Version) {
+		failf(w, "%w: batch builds require an explicit version, not %q", errBadVersion, bs.Version)
+		return
+	}
+	if !checkAllowedRespond(w, bs.Mod, bs.Version) {
+		return
+	}
+	if !targets.valid(bs.Goos + "/" + bs.Goarch) {
+		failf(w, "%w: unknown target %s/%s", errNotExist, bs.Goos, bs.Goarch)
+		return
+	}
+	if config.ReadOnly {
+		failReadOnly(w)
+		return
+	}
+	if handleBadClient(w, r) {
+		return
+	}
+
+	_, supported, _ := listSDK()
+	// Versions the config does not allow would only fail.
+	supported = slices.DeleteFunc(slices.Clone(supported), func(goversion string) bool {
+		return !goversionAllowed(goversion)
+	})
+	if len(supported) == 0 {
+		failf(w, "no supported go toolchains available: %w", errServer)
+		return
+	}
+
+	release, ok := acquireEventStream(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		slog.Error("ResponseWriter not a http.Flusher")
+		failf(w, "%w: implementation limitation: cannot stream updates", errServer)
+		return
+	}
+
+	var batchTargets []batchTarget
+	for _, goversion := range supported {
+		gbs := bs
+		gbs.Goversion = goversion
+		batchTargets = append(batchTargets, batchTarget{goversion, request{gbs, "", pageIndex}.link()})
+	}
+	buf, err := json.Marshal(batchTargets)
+	if err != nil {
+		failf(w, "%w: marshal targets: %v", errServer, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	if _, err := fmt.Fprintf(w, "event: targets\ndata: %s\n\n", buf); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	ctx := r.Context()
+
+	// Updates from the coordinator, with the go version of the build.
+	type batchUpdate struct {
+		goversion string
+		update    buildUpdate
+	}
+	updatec := make(chan batchUpdate)
+
+	writeUpdate := func(goversion string, msg []byte) error {
+		_, err := fmt.Fprintf(w, "id: %s\n%s", goversion, msg)
+		flusher.Flush()
+		return err
+	}
+
+	type registration struct {
+		bs     buildSpec
+		eventc chan buildUpdate
+	}
+	var registered []registration
+	defer func() {
+		for _, reg := range registered {
+			unregisterBuild(reg.bs, reg.eventc)
+		}
+	}()
+
+	for _, goversion := range supported {
+		gbs := bs
+		gbs.Goversion = goversion
+
+		var msg []byte
+		_, br, binaryPresent, failed, err := serverOps{}.lookupResult(ctx, gbs)
+		if err != nil {
+			msg = buildUpdateMsg{Kind: kindTempFail, Error: fmt.Sprintf("lookup record: %v", err)}.json()
+		} else if failed {
+			f := failureInfos[failureOther]
+			msg = buildUpdateMsg{Kind: kindPermFail, Error: "build failed, see build page for details", Failure: &f}.json()
+		} else if br != nil && binaryPresent {
+			msg = buildUpdateMsg{Kind: kindSuccess, Result: br}.json()
+		} else {
+			if br == nil {
+				err = checkVersionAge(r, gbs)
+			}
+			if err == nil {
+				err = prepareBuild(ctx, gbs, kindOf(br))
+			}
+			if err != nil {
+				f := classifyFailure(err, "")
+				metricBuildFailures.WithLabelValues(string(f.Category)).Inc()
+				msg = buildUpdateMsg{Kind: kindPermFail, Error: err.Error(), Failure: &f}.json()
+			}
+		}
+		if msg != nil {
+			if err := writeUpdate(goversion, msg); err != nil {
+				return
+			}
+			continue
+		}
+
+		var expSum string
+		if br != nil {
+			expSum = br.Sum
+		}
+		eventc := make(chan buildUpdate, 100)
+		registerBuild(gbs, expSum, false, eventc)
+		registered = append(registered, registration{gbs, eventc})
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case update := <-eventc:
+					select {
+					case updatec <- batchUpdate{goversion, update}:
+					case <-ctx.Done():
+						return
+					}
+					if update.done {
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	for pending := len(registered); pending > 0; {
+		select {
+		case <-ctx.Done():
+			return
+		case bu := <-updatec:
+			if err := writeUpdate(bu.goversion, bu.update.msg); err != nil {
+				return
+			}
+			if bu.update.done {
+				pending--
+			}
+		}
+	}
+}
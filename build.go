@@ -1,16 +1,16 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
-	"os"
-	"path/filepath"
 )
 
 func handleBadClient(w http.ResponseWriter, r *http.Request) bool {
-	for _, cp := range config.BadClients {
+	for _, cp := range configBadClients() {
 		if hostname, ok := cp.Match(r); ok {
-			slog.Info("bad client", "user-agent", r.UserAgent(), "remoteaddr", r.RemoteAddr, "hostname", hostname)
+			slog.Info("bad client", "user-agent", r.UserAgent(), "remoteaddr", r.RemoteAddr, "clientip", clientIP(r), "hostname", hostname)
 			statusfailf(http.StatusForbidden, w, "Your request matched a list of clients/networks with known bad behaviour. Please respect the robots.txt (no crawling that triggers builds!) and be kind. Contact the admins to get access again.")
 			return true
 		}
@@ -18,15 +18,29 @@ func handleBadClient(w http.ResponseWriter, r *http.Request) bool {
 	return false
 }
 
+// failReadOnly responds that no new builds are started, for read-only instances.
+func failReadOnly(w http.ResponseWriter) {
+	statusfailf(http.StatusForbidden, w, "This gobuild instance is a read-only mirror, it only serves existing builds and does not start new builds.")
+}
+
+// linkQuery returns link with the query string of r appended, so parameters like
+// "allowold" are kept when redirecting.
+func linkQuery(r *http.Request, link string) string {
+	if r.URL.RawQuery != "" {
+		link += "?" + r.URL.RawQuery
+	}
+	return link
+}
+
 func serveBuild(w http.ResponseWriter, r *http.Request, req request) {
 	// Resolve "latest" goversion with a redirect.
 	if req.Goversion == "latest" {
-		if newestAllowed, _, _ := listSDK(); newestAllowed == "" {
+		if goversion := latestGoversion(req.Goos, req.Goarch); goversion == "" {
 			failf(w, "no supported go toolchains available: %w", errServer)
 		} else {
 			vreq := req
-			vreq.Goversion = newestAllowed
-			http.Redirect(w, r, vreq.link(), http.StatusTemporaryRedirect)
+			vreq.Goversion = goversion
+			http.Redirect(w, r, linkQuery(r, vreq.link()), http.StatusTemporaryRedirect)
 		}
 		return
 	}
@@ -38,7 +52,7 @@ func serveBuild(w http.ResponseWriter, r *http.Request, req request) {
 		} else {
 			mreq := req
 			mreq.Version = info.Version
-			http.Redirect(w, r, mreq.link(), http.StatusTemporaryRedirect)
+			http.Redirect(w, r, linkQuery(r, mreq.link()), http.StatusTemporaryRedirect)
 		}
 		return
 	}
@@ -56,28 +70,28 @@ func serveBuild(w http.ResponseWriter, r *http.Request, req request) {
 		// Show failed build to user, for the pages where that works.
 		switch req.Page {
 		case pageRetry:
-			// We'll move away the directory with the failed build, remove it, and redirect
-			// user to the index page so a new build is triggered.
-			dir := req.buildSpec.storeDir()
-			// Just a sanity check that we aren't removing successful build results.
-			if _, err := os.Stat(filepath.Join(dir, "recordnumber")); err == nil {
-				failf(w, "%w: directory with failed build contains recordnumber-file", errServer)
+			if config.ReadOnly {
+				failReadOnly(w)
 				return
 			}
 
-			tmpdir := dir + ".remove"
-			if err := os.Rename(dir, tmpdir); err != nil {
-				failf(w, "%w: moving away directory with log of failed build: %v", errServer, err)
+			// We'll remove the failed build, and redirect user to the index page so a new
+			// build is triggered.
+			key := req.buildSpec.storeKey()
+			// Just a sanity check that we aren't removing successful build results.
+			if resultFileExists(key, "recordnumber") {
+				failf(w, "%w: failed build contains recordnumber-file", errServer)
 				return
 			}
-			if err := os.RemoveAll(tmpdir); err != nil {
-				failf(w, "%w: removing path of failed build: %s", errServer, err)
+
+			if err := results.RemoveAll(key); err != nil {
+				failf(w, "%w: removing failed build: %v", errServer, err)
 				return
 			}
 			req.Page = pageIndex
 			http.Redirect(w, r, req.link(), http.StatusSeeOther)
 		case pageLog:
-			serveLog(w, r, filepath.Join(req.storeDir(), "log.gz"))
+			serveLog(w, r, req.storeKey())
 		case pageIndex:
 			serveIndex(w, r, req.buildSpec, nil)
 		default:
@@ -86,6 +100,11 @@ func serveBuild(w http.ResponseWriter, r *http.Request, req request) {
 		return
 	}
 
+	if config.ReadOnly {
+		failReadOnly(w)
+		return
+	}
+
 	if handleBadClient(w, r) {
 		return
 	}
@@ -98,8 +117,17 @@ func serveBuild(w http.ResponseWriter, r *http.Request, req request) {
 	// We always immediately attempt to get the files for a build build. This checks
 	// with the goproxy that the module and package exist, and seems like it has a
 	// chance to compile.
-	if err := prepareBuild(req.buildSpec); err != nil {
-		failf(w, "preparing build: %w", err)
+	if err := checkVersionAge(r, req.buildSpec); err != nil {
+		failPrepare(w, req.buildSpec, err)
+		return
+	}
+	// Other gobuild instances verifying their build request the record page.
+	kind := buildNew
+	if req.Page == pageRecord {
+		kind = buildVerify
+	}
+	if err := prepareBuild(r.Context(), req.buildSpec, kind); err != nil {
+		failPrepare(w, req.buildSpec, err)
 		return
 	}
 
@@ -111,8 +139,18 @@ func serveBuild(w http.ResponseWriter, r *http.Request, req request) {
 		return
 	}
 
+	if req.Page == pageEvents {
+		release, ok := acquireEventStream(w, r)
+		if !ok {
+			return
+		}
+		defer release()
+	}
+
+	// Other gobuild instances verifying their build request the record page, and
+	// are blocked until we're done. Give them priority over regular requests.
 	eventc := make(chan buildUpdate, 100)
-	registerBuild(req.buildSpec, "", eventc)
+	registerBuild(req.buildSpec, "", req.Page == pageRecord, eventc)
 
 	ctx := r.Context()
 
@@ -162,7 +200,7 @@ func serveBuild(w http.ResponseWriter, r *http.Request, req request) {
 				unregisterBuild(req.buildSpec, eventc)
 
 				if req.Page == pageLog {
-					serveLog(w, r, filepath.Join(req.storeDir(), "log.gz"))
+					serveLog(w, r, req.storeKey())
 					return
 				}
 
@@ -179,3 +217,49 @@ func serveBuild(w http.ResponseWriter, r *http.Request, req request) {
 		}
 	}
 }
+
+// failPrepare responds with an error for a failed prepareBuild. For packages that
+// need cgo, a page explaining the problem is served.
+func failPrepare(w http.ResponseWriter, bs buildSpec, err error) {
+	f := classifyFailure(err, "")
+	metricBuildFailures.WithLabelValues(string(f.Category)).Inc()
+
+	var cerr cgoError
+	if !errors.As(err, &cerr) {
+		if f.Category == failureOther || errors.Is(err, errServer) {
+			failf(w, "preparing build: %w", err)
+		} else {
+			failCategorized(w, f, fmt.Errorf("preparing build: %w", err))
+		}
+		return
+	}
+
+	type cgoPackage struct {
+		ImportPath  string
+		PkgGoDevURL string
+	}
+	var pkgs []cgoPackage
+	for _, p := range cerr.Packages {
+		pkgs = append(pkgs, cgoPackage{p, "https://pkg.go.dev/" + p})
+	}
+	args := struct {
+		Favicon         string
+		Req             request
+		IndexURL        string
+		Packages        []cgoPackage
+		GobuildVersion  string
+		GobuildPlatform string
+	}{
+		"/favicon-error.png",
+		request{bs, "", pageIndex},
+		request{bs, "", pageIndex}.link(),
+		pkgs,
+		gobuildVersion,
+		gobuildPlatform,
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusBadRequest)
+	if err := cgoTemplate.Execute(w, args); err != nil {
+		slog.Error("executing template for cgo error", "err", err)
+	}
+}
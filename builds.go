@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type buildsEntry struct {
+	Number int64
+	Link   string
+	Result *buildResult
+}
+
+type buildsArgs struct {
+	Favicon         string
+	Prefix          string
+	Builds          []buildsEntry
+	Scanned         int64  // Number of records scanned, may be more than len(Builds) with a prefix.
+	NewestURL       string // Link to first page, empty if we are on it.
+	OlderURL        string // Link to next page with older builds, empty if none.
+	GobuildVersion  string
+	GobuildPlatform string
+}
+
+// serveBuilds lists successful builds from the transparency log, newest first,
+// with pagination by record number through the "before" query parameter. An
+// optional "prefix" query parameter limits the list to modules with that prefix.
+func serveBuilds(w http.ResponseWriter, r *http.Request) {
+	defer observePage("builds", time.Now())
+
+	if r.Method != "GET" {
+		http.Error(w, "405 - Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Number of matching builds per page, and maximum number of records scanned per
+	// request, to bound the work for prefixes that rarely match.
+	const pageSize = 100
+	const maxScan = 10000
+
+	n, err := treeSize()
+	if err != nil {
+		failf(w, "%w: reading number of records: %v", errServer, err)
+		return
+	}
+	before := n
+	if s := r.FormValue("before"); s != "" {
+		v, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || v < 0 {
+			failf(w, "bad parameter before %q", s)
+			return
+		}
+		before = min(v, n)
+	}
+	prefix := r.FormValue("prefix")
+
+	pageURL := func(before int64) string {
+		q := url.Values{}
+		if before != n {
+			q.Set("before", fmt.Sprintf("%d", before))
+		}
+		if prefix != "" {
+			q.Set("prefix", prefix)
+		}
+		if len(q) == 0 {
+			return "/builds"
+		}
+		return "/builds?" + q.Encode()
+	}
+
+	var builds []buildsEntry
+	next := before
+	for next > 0 && len(builds) < pageSize && before-next < maxScan {
+		count := min(next, 1000, maxScan-(before-next))
+		records, err := serverOps{}.ReadRecords(r.Context(), next-count, count)
+		if err != nil {
+			failf(w, "%w: reading records: %v", errServer, err)
+			return
+		}
+		i := len(records) - 1
+		for ; i >= 0 && len(builds) < pageSize; i-- {
+			br, err := parseRecord(records[i])
+			if err != nil {
+				failf(w, "%w: parsing record %d: %v", errServer, next-count+int64(i), err)
+				return
+			}
+			if !strings.HasPrefix(br.Mod, prefix) {
+				continue
+			}
+			link := request{br.buildSpec, br.Sum, pageIndex}.link()
+			builds = append(builds, buildsEntry{next - count + int64(i), link, br})
+		}
+		next = next - count + int64(i) + 1
+	}
+
+	args := buildsArgs{
+		Favicon:         "/favicon.ico",
+		Prefix:          prefix,
+		Builds:          builds,
+		Scanned:         before - next,
+		GobuildVersion:  gobuildVersion,
+		GobuildPlatform: gobuildPlatform,
+	}
+	if before != n {
+		args.NewestURL = pageURL(n)
+	}
+	if next > 0 {
+		args.OlderURL = pageURL(next)
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	if err := buildsTemplate.Execute(w, args); err != nil {
+		failf(w, "%w: executing template: %v", errServer, err)
+	}
+}
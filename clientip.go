@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// clientIP returns the IP address of the client of r. For requests from a proxy in
+// config.TrustedProxies, the address is taken from the Forwarded or X-Forwarded-For
+// header, skipping trusted proxies from the right. Addresses added by untrusted
+// clients are never used. Returns an empty string if no address could be parsed.
+func clientIP(r *http.Request) string {
+	ip := parseHostIP(r.RemoteAddr)
+	if ip == nil {
+		return ""
+	}
+	if !trustedProxy(ip) {
+		return ip.String()
+	}
+
+	hops := forwardedFor(r.Header)
+	for i := len(hops) - 1; i >= 0; i-- {
+		hip := parseHostIP(hops[i])
+		if hip == nil {
+			// Unknown or obfuscated address, the last proxy is the best we know.
+			break
+		}
+		ip = hip
+		if !trustedProxy(ip) {
+			break
+		}
+	}
+	return ip.String()
+}
+
+func trustedProxy(ip net.IP) bool {
+	for _, ipnet := range config.trustedProxies {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor returns the addresses from the Forwarded header, or if absent from
+// the X-Forwarded-For header, in order of the header, i.e. the client first.
+func forwardedFor(h http.Header) []string {
+	var l []string
+	for _, v := range h.Values("Forwarded") {
+		for _, elem := range strings.Split(v, ",") {
+			for _, pair := range strings.Split(elem, ";") {
+				k, v, _ := strings.Cut(strings.TrimSpace(pair), "=")
+				if strings.EqualFold(k, "for") {
+					l = append(l, strings.Trim(v, `"`))
+				}
+			}
+		}
+	}
+	if len(l) > 0 {
+		return l
+	}
+	for _, v := range h.Values("X-Forwarded-For") {
+		for _, s := range strings.Split(v, ",") {
+			l = append(l, strings.TrimSpace(s))
+		}
+	}
+	return l
+}
+
+// parseHostIP parses an IP address with optional port, with IPv6 addresses
+// optionally in brackets and with a zone, e.g. "192.0.2.1", "192.0.2.1:1234",
+// "2001:db8::1", "[2001:db8::1]:1234" or "[fe80::1%eth0]:1234".
+func parseHostIP(s string) net.IP {
+	host := s
+	if h, _, err := net.SplitHostPort(s); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	host, _, _ = strings.Cut(host, "%")
+	return net.ParseIP(host)
+}
@@ -1,16 +1,49 @@
 package main
 
 import (
+	"context"
 	"log/slog"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strings"
 )
 
+// Tokens for running go commands other than builds, limiting the number of
+// concurrent commands to config.MaxCommands. Builds are limited by the
+// coordinator, through config.MaxBuilds, and don't take a token.
+var commandTokens chan struct{}
+
+func initCommandTokens() {
+	n := config.MaxCommands
+	if n <= 0 {
+		n = runtime.NumCPU()
+	}
+	commandTokens = make(chan struct{}, n)
+}
+
+// acquireCommand blocks until a non-build command can be run, and returns a
+// function that must be called when the command has finished.
+func acquireCommand() (release func()) {
+	if commandTokens == nil {
+		return func() {}
+	}
+	commandTokens <- struct{}{}
+	return func() {
+		<-commandTokens
+	}
+}
+
 // Prepare command, typically for running go get. We sometimes need CGO_ENABLED to
 // properly list the cgo files that would be used during a build. Only set
 // withGoproxy for downloading modules, not doing builds or listing packages.
 func makeCommand(goversion string, withGoproxy bool, dir string, cgoEnabled bool, extraEnv []string, argv ...string) *exec.Cmd {
+	return makeCommandContext(context.Background(), goversion, withGoproxy, dir, cgoEnabled, extraEnv, argv...)
+}
+
+// makeCommandContext is like makeCommand, but the command is killed when ctx is
+// done.
+func makeCommandContext(ctx context.Context, goversion string, withGoproxy bool, dir string, cgoEnabled bool, extraEnv []string, argv ...string) *exec.Cmd {
 	cgo := "CGO_ENABLED=0"
 	if cgoEnabled {
 		cgo = "CGO_ENABLED=1"
@@ -24,7 +57,7 @@ func makeCommand(goversion string, withGoproxy bool, dir string, cgoEnabled bool
 	var l []string
 	l = append(l, config.Run...)
 	l = append(l, argv...)
-	cmd := exec.Command(l[0], l[1:]...)
+	cmd := exec.CommandContext(ctx, l[0], l[1:]...)
 	cmd.Dir = dir
 	cmd.Env = []string{
 		goproxy,
@@ -45,6 +78,15 @@ func makeCommand(goversion string, withGoproxy bool, dir string, cgoEnabled bool
 	default:
 		cmd.Env = append(cmd.Env, "HOME="+homedir)
 	}
+	if config.SumDBDisabled {
+		cmd.Env = append(cmd.Env, "GOSUMDB=off")
+	}
+	if len(config.NoSumDBPatterns) > 0 {
+		cmd.Env = append(cmd.Env, "GONOSUMDB="+strings.Join(config.NoSumDBPatterns, ","))
+	}
+	if withGoproxy && config.GoProxyHTTPProxy != "" {
+		cmd.Env = append(cmd.Env, "HTTPS_PROXY="+config.GoProxyHTTPProxy, "HTTP_PROXY="+config.GoProxyHTTPProxy)
+	}
 	if len(config.Environment) > 0 {
 		cmd.Env = append(cmd.Env, config.Environment...)
 	}
@@ -5,7 +5,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"runtime"
+	"slices"
+	"sync"
+	"time"
 )
 
 type kind string
@@ -20,9 +24,16 @@ const (
 // buildUpdateMsg is sent to browsers through the SSE /events endpoint.
 type buildUpdateMsg struct {
 	Kind          kind
-	QueuePosition *int         `json:",omitempty"`
-	Error         string       `json:",omitempty"`
-	Result        *buildResult `json:",omitempty"`
+	QueuePosition *int `json:",omitempty"`
+
+	// Rough estimates for a queued build, based on recent compile durations. Absent
+	// if no builds have completed yet.
+	EstimatedWaitSeconds   *int `json:",omitempty"` // Until the build starts.
+	EstimatedFinishSeconds *int `json:",omitempty"` // Until the build is done.
+
+	Error   string       `json:",omitempty"`
+	Failure *failureInfo `json:",omitempty"` // Classification of the error, for a headline and hint.
+	Result  *buildResult `json:",omitempty"`
 }
 
 func (bum buildUpdateMsg) json() []byte {
@@ -45,25 +56,95 @@ type buildUpdate struct {
 }
 
 type buildRequest struct {
+	bs       buildSpec
+	expSum   string // If non-empty, build must result in this sum. Used for rebuilding a binary that was cleaned up.
+	priority bool   // Queued ahead of regular requests. For verification requests from other instances, which are waiting for us.
+	rebuild  bool   // Build even if a result is present. Requires expSum.
+	eventc   chan buildUpdate
+	queued   time.Time // When added to the queue, for metrics.
+}
+
+// buildFailure is a failure of a build found before building, e.g. while
+// preparing the build.
+type buildFailure struct {
 	bs     buildSpec
-	expSum string // If non-empty, build must result in this sum. Used for rebuilding a binary that was cleaned up.
-	eventc chan buildUpdate
+	err    error
+	output string // For the build log.
 }
 
 var coordinate = struct {
 	register   chan buildRequest
 	unregister chan buildRequest
+	fail       chan buildFailure
 }{
 	make(chan buildRequest, 1),
 	make(chan buildRequest, 1),
+	make(chan buildFailure, 1),
 }
 
-func registerBuild(bs buildSpec, expSum string, eventc chan buildUpdate) {
-	coordinate.register <- buildRequest{bs, expSum, eventc}
+func registerBuild(bs buildSpec, expSum string, priority bool, eventc chan buildUpdate) {
+	coordinate.register <- buildRequest{bs, expSum, priority, false, eventc, time.Time{}}
+}
+
+// registerRebuild is like registerBuild, but builds even if the binary is present,
+// to verify a new build still results in expSum.
+func registerRebuild(bs buildSpec, expSum string, eventc chan buildUpdate) {
+	coordinate.register <- buildRequest{bs, expSum, false, true, eventc, time.Time{}}
 }
 
 func unregisterBuild(bs buildSpec, eventc chan buildUpdate) {
-	coordinate.unregister <- buildRequest{bs, "", eventc}
+	coordinate.unregister <- buildRequest{bs, "", false, false, eventc, time.Time{}}
+}
+
+// registerFailure stores err as the result of a failed build of bs, with output
+// in the build log. It goes through the coordinator, so clients waiting for a
+// build of bs are notified. A build of bs that is running or has completed
+// takes precedence.
+func registerFailure(bs buildSpec, err error, output string) {
+	coordinate.fail <- buildFailure{bs, err, output}
+}
+
+// Moving averages of compile durations of successful builds, per target and
+// goversion, and over all builds. For estimating wait times.
+var compileDurations = struct {
+	sync.Mutex
+	all      time.Duration
+	byTarget map[string]time.Duration // Key: goos/goarch/goversion.
+}{byTarget: map[string]time.Duration{}}
+
+func compileDurationKey(bs buildSpec) string {
+	return bs.Goos + "/" + bs.Goarch + "/" + bs.Goversion
+}
+
+// addCompileDuration updates the moving averages with a new compile duration.
+func addCompileDuration(bs buildSpec, d time.Duration) {
+	compileDurations.Lock()
+	defer compileDurations.Unlock()
+
+	avg := func(prev time.Duration) time.Duration {
+		if prev == 0 {
+			return d
+		}
+		return (4*prev + d) / 5
+	}
+	compileDurations.all = avg(compileDurations.all)
+	k := compileDurationKey(bs)
+	compileDurations.byTarget[k] = avg(compileDurations.byTarget[k])
+}
+
+// estimateCompileDuration returns the expected compile duration for bs, falling
+// back to the average over all targets. If bs is nil, the average over all
+// targets is returned. Zero if there is no history.
+func estimateCompileDuration(bs *buildSpec) time.Duration {
+	compileDurations.Lock()
+	defer compileDurations.Unlock()
+	if bs == nil {
+		return compileDurations.all
+	}
+	if d, ok := compileDurations.byTarget[compileDurationKey(*bs)]; ok {
+		return d
+	}
+	return compileDurations.all
 }
 
 func coordinateBuilds() {
@@ -77,6 +158,14 @@ func coordinateBuilds() {
 		// Last update, with done set to true. We store it to know the command has
 		// finished, and give all listeners the concluding update.
 		final *buildUpdate
+
+		// For the running build, nil otherwise. Used to cancel the build when the last
+		// listener leaves, if config.CancelAbandonedBuilds is set.
+		cancel context.CancelFunc
+
+		// Request of the running build, for building again if new listeners registered
+		// after the build was canceled.
+		req buildRequest
 	}
 	builds := map[buildSpec]*wipBuild{}
 
@@ -85,11 +174,35 @@ func coordinateBuilds() {
 	if maxBuilds == 0 {
 		maxBuilds = runtime.NumCPU() + 1
 	}
+	metricBuildsMax.Set(float64(maxBuilds))
 
 	// Build requests always go through the queue. We'll pick up the next for which the
 	// output path is available, but only if we are below maxBuilds builds in progress.
+	// Priority requests are kept at the front of the queue, before regular requests.
 	queue := []buildRequest{}
 
+	// Add to queue, after existing priority requests if breq is a priority request.
+	enqueue := func(breq buildRequest) {
+		i := len(queue)
+		if breq.priority {
+			i = 0
+			for i < len(queue) && queue[i].priority {
+				i++
+			}
+		}
+		queue = slices.Insert(queue, i, breq)
+	}
+
+	// Position in queue, starting at 1. Zero if not queued.
+	queuePosition := func(bs buildSpec) int {
+		for i, breq := range queue {
+			if breq.bs == bs {
+				return i + 1
+			}
+		}
+		return 0
+	}
+
 	// Keep track of output paths that are "busy", i.e. paths that currently running
 	// builds will write the resulting binary to.
 	// Keys are the result of request.outputPath.
@@ -101,10 +214,36 @@ func coordinateBuilds() {
 		return &i
 	}
 
+	// Estimate when the build at position (starting at 1) in the queue starts and
+	// finishes. Builds ahead are assumed to run maxBuilds at a time, and active builds
+	// to be halfway done. Best-effort, nil without history.
+	estimate := func(position int) (wait, finish *int) {
+		if position <= 0 || position > len(queue) {
+			return nil, nil
+		}
+		own := estimateCompileDuration(&queue[position-1].bs)
+		if own == 0 {
+			return nil, nil
+		}
+		var ahead time.Duration
+		for _, breq := range queue[:position-1] {
+			ahead += estimateCompileDuration(&breq.bs)
+		}
+		ahead += time.Duration(active) * estimateCompileDuration(nil) / 2
+		w := ahead / time.Duration(maxBuilds)
+		return intptr(int(w.Seconds())), intptr(int((w + own).Seconds()))
+	}
+
+	// Message for a build at position in the queue, 0 meaning build in progress.
+	pendingMsg := func(position int) []byte {
+		wait, finish := estimate(position)
+		return buildUpdateMsg{Kind: kindQueuePosition, QueuePosition: intptr(position), EstimatedWaitSeconds: wait, EstimatedFinishSeconds: finish}.json()
+	}
+
 	sendPending := func(b *wipBuild, position int) {
 		update := buildUpdate{
 			queuePosition: position,
-			msg:           buildUpdateMsg{Kind: kindQueuePosition, QueuePosition: intptr(position)}.json(),
+			msg:           pendingMsg(position),
 		}
 		for _, c := range b.events {
 			select {
@@ -116,20 +255,32 @@ func coordinateBuilds() {
 
 	startBuild := func(breq buildRequest, b *wipBuild) {
 		active++
+		metricBuildsStarted.Inc()
+		metricBuildQueueWait.Observe(time.Since(breq.queued).Seconds())
 		pathBusy[breq.bs.outputPath()] = struct{}{}
+		ctx, cancel := context.WithCancel(context.Background())
+		b.cancel = cancel
+		b.req = breq
 		go func() {
-			recordNumber, result, errOutput, err := build(breq.bs, breq.expSum)
+			defer cancel()
+			recordNumber, result, errOutput, err := build(ctx, breq.bs, breq.expSum)
 			var errmsg string
+			var failure *failureInfo
 			if err != nil {
 				errmsg = err.Error() + "\n\n" + errOutput
+				f := classifyFailure(err, errOutput)
+				failure = &f
+				if !errors.Is(err, errBuildCanceled) {
+					metricBuildFailures.WithLabelValues(string(f.Category)).Inc()
+				}
 			}
 			var msg []byte
 			if err == nil {
 				msg = buildUpdateMsg{Kind: kindSuccess, Result: result}.json()
 			} else if errors.Is(err, errTempFailure) {
-				msg = buildUpdateMsg{Kind: kindTempFail, Error: errmsg}.json()
+				msg = buildUpdateMsg{Kind: kindTempFail, Error: errmsg, Failure: failure}.json()
 			} else {
-				msg = buildUpdateMsg{Kind: kindPermFail, Error: errmsg}.json()
+				msg = buildUpdateMsg{Kind: kindPermFail, Error: errmsg, Failure: failure}.json()
 			}
 			update := buildUpdate{bs: breq.bs, done: true, err: err, result: result, recordNumber: recordNumber, msg: msg}
 			updatec <- update
@@ -142,6 +293,11 @@ func coordinateBuilds() {
 	}
 
 	kick := func() {
+		defer func() {
+			metricBuildQueueLength.Set(float64(len(queue)))
+			metricBuildsActive.Set(float64(active))
+		}()
+
 		if active >= maxBuilds {
 			return
 		}
@@ -172,11 +328,14 @@ func coordinateBuilds() {
 		case reg := <-coordinate.register:
 			b, ok := builds[reg.bs]
 			if !ok {
-				b = &wipBuild{nil, nil}
+				b = &wipBuild{}
 				builds[reg.bs] = b
 
-				// We may have just finished a build. Before starting any new work, try reading a result.
-				if recordNumber, br, binaryPresent, failed, err := (serverOps{}.lookupResult(context.Background(), reg.bs)); err != nil || failed {
+				// We may have just finished a build. Before starting any new work, try reading a
+				// result. Unless we are explicitly asked to build again.
+				if reg.rebuild {
+					// Continue below, queueing a build.
+				} else if recordNumber, br, binaryPresent, failed, err := (serverOps{}.lookupResult(context.Background(), reg.bs)); err != nil || failed {
 					if err == nil {
 						err = fmt.Errorf("build failed")
 					}
@@ -195,12 +354,23 @@ func coordinateBuilds() {
 			}
 
 			if !ok {
-				queue = append(queue, reg)
+				reg.queued = time.Now()
+				enqueue(reg)
 				kick()
+			} else if pos := queuePosition(reg.bs); reg.priority && pos > 0 && !queue[pos-1].priority {
+				// Build was already queued as regular request, move it forward.
+				breq := queue[pos-1]
+				breq.priority = true
+				queue = slices.Delete(queue, pos-1, pos)
+				enqueue(breq)
+				for i, qbreq := range queue {
+					sendPending(builds[qbreq.bs], i+1)
+				}
 			}
+			position := queuePosition(reg.bs)
 			update := buildUpdate{
-				queuePosition: len(queue),
-				msg:           buildUpdateMsg{Kind: kindQueuePosition, QueuePosition: intptr(len(queue))}.json(),
+				queuePosition: position,
+				msg:           pendingMsg(position),
 			}
 			reg.eventc <- update
 
@@ -218,10 +388,67 @@ func coordinateBuilds() {
 			b.events = l
 			if len(b.events) == 0 && b.final != nil {
 				delete(builds, reg.bs)
+			} else if len(b.events) == 0 && b.cancel != nil && config.CancelAbandonedBuilds {
+				slog.Info("canceling build without remaining listeners", "buildspec", reg.bs)
+				metricBuildsCanceled.Inc()
+				b.cancel()
 			}
 
+		case bf := <-coordinate.fail:
+			b := builds[bf.bs]
+			if b != nil && (b.cancel != nil || b.final != nil) {
+				continue
+			}
+			if err := saveFailure(bf.bs, bf.err, bf.output); err != nil {
+				slog.Error("storing results of failure", "err", err, "buildspec", bf.bs)
+				continue
+			}
+			if b == nil {
+				continue
+			}
+			if pos := queuePosition(bf.bs); pos > 0 {
+				queue = slices.Delete(queue, pos-1, pos)
+				for i, qbreq := range queue[pos-1:] {
+					sendPending(builds[qbreq.bs], pos+i)
+				}
+			}
+			f := classifyFailure(bf.err, "")
+			msg := buildUpdateMsg{Kind: kindPermFail, Error: bf.err.Error(), Failure: &f}.json()
+			update := buildUpdate{bs: bf.bs, done: true, err: bf.err, msg: msg}
+			for _, c := range b.events {
+				select {
+				case c <- update:
+				default:
+				}
+			}
+			b.final = &update
+			if len(b.events) == 0 {
+				delete(builds, bf.bs)
+			}
+			kick()
+
 		case update := <-updatec:
 			b := builds[update.bs]
+			if update.done {
+				b.cancel = nil
+			}
+			if update.done && errors.Is(update.err, errBuildCanceled) {
+				delete(pathBusy, update.bs.outputPath())
+				active--
+				if len(b.events) == 0 {
+					delete(builds, update.bs)
+				} else {
+					// Listeners registered after we canceled the build, build again.
+					breq := b.req
+					breq.queued = time.Now()
+					enqueue(breq)
+					for i, qbreq := range queue {
+						sendPending(builds[qbreq.bs], i+1)
+					}
+				}
+				kick()
+				continue
+			}
 			for _, c := range b.events {
 				// We don't want to block. Slow clients/readers may not get all updates, better than blocking.
 				select {
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+)
+
+// handleCORS adds CORS headers to responses for requests from origins listed in
+// config.CORSAllowOrigins. It returns true if the request was a preflight request
+// and has been handled.
+func handleCORS(w http.ResponseWriter, r *http.Request) bool {
+	if len(config.CORSAllowOrigins) == 0 {
+		return false
+	}
+	// Responses differ with and without Origin, caches must keep them apart.
+	w.Header().Add("Vary", "Origin")
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	if slices.Contains(config.CORSAllowOrigins, "*") {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if slices.Contains(config.CORSAllowOrigins, origin) {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	} else {
+		return false
+	}
+	w.Header().Set("Access-Control-Expose-Headers", "ETag")
+
+	if r.Method != "OPTIONS" || r.Header.Get("Access-Control-Request-Method") == "" {
+		return false
+	}
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "If-None-Match")
+	w.Header().Set("Access-Control-Max-Age", "86400")
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// corsHandler wraps fn, an API endpoint, with CORS handling.
+func corsHandler(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if handleCORS(w, r) {
+			return
+		}
+		fn(w, r)
+	}
+}
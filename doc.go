@@ -47,6 +47,20 @@ Examples:
 	gobuild get github.com/mjl-/gobuild@latest
 	gobuild get -sum 0N7e6zxGtHCObqNBDA_mXKv7-A9M -target linux/amd64 -goversion go1.14.1 github.com/mjl-/gobuild@v0.0.8
 
+For testing against a local instance without a verifier key, the transparency
+log can be skipped. The downloaded binary is then only checked against -sum:
+
+	gobuild get -insecure-skip-tlog -url http://localhost:8000 -sum 0N7e6zxGtHCObqNBDA_mXKv7-A9M -target linux/amd64 -goversion go1.14.1 github.com/mjl-/gobuild@v0.0.8
+
+To list the targets for which a build is already available, without downloading:
+
+	gobuild get -list-targets -goversion go1.14.1 github.com/mjl-/gobuild@v0.0.8
+
+Gobuild's "verify" subcommand checks that the sum of a binary you already have is
+in the transparency log for a build:
+
+	gobuild verify -target linux/amd64 -goversion go1.14.1 github.com/mjl-/gobuild@v0.0.8 gobuild
+
 # Details
 
 Only "go build" is run, for pure Go code. None of "go test", "go generate",
@@ -71,7 +85,63 @@ To build, gobuild executes:
 		CGO_ENABLED=0 GOOS=$goos GOARCH=$goarch GOTOOLCHAIN=$goversion \
 		$goversion install -x -v -trimpath -ldflags=-buildid= -- $module/$package@$version
 
-For the stripped variant, -ldflags="-buildid= -s" is used.
+For the stripped variant, -ldflags="-buildid= -s" is used. If enabled with
+AllowRace in the config, a race variant built with -race can be requested for
+the host target, for debugging. The race detector requires cgo, so race builds
+are done with CGO_ENABLED=1, and need a C compiler on the host, found through
+PATH. Race builds are not verified by other instances.
+
+Build tags can be requested from an allow list in the config, BuildTags. The
+list is empty by default, instances opt in to tags, e.g. purego and timetzdata
+for pure Go variants of programs. The tags are passed with -tags, and are part
+of the build URL (e.g. linux-amd64-go1.22.1-tags.purego,timetzdata/), the
+result store and transparency log record, so builds with tags are just as
+reproducible.
+
+The netgo variant (e.g. linux-amd64-go1.22.1-netgo/) builds with -tags
+netgo,osusergo, for fully static binaries with the pure Go DNS resolver and user
+lookups. Since gobuild builds without cgo, the standard library mostly uses its
+pure Go implementations already. The variant only makes a difference for
+packages that honor these tags. Tags netgo and osusergo can only be requested
+through this variant, so each binary has a single build URL.
+
+Modules that include their vendored dependencies (vendor/modules.txt in the
+module zip) can be built with the vendor variant (e.g.
+linux-amd64-go1.22.1-vendor/). Gobuild runs "go build -mod=vendor" with the
+other flags in the package directory of the module, in the module cache. The
+module is the main module, so its replace directives apply, but the binary has
+no module version in its build info. The variant is in the transparency log
+record, verifiers build it the same way.
+
+If allowed with GOFIPS140 in the config, builds can be requested with GOFIPS140
+set (e.g. linux-amd64-go1.24.1-fips140.v1.0.0/), for binaries using the Go
+Cryptographic Module in FIPS 140-3 mode, with go1.24 and newer. GOFIPS140
+selects a snapshot of the module from the Go distribution, and is recorded in
+the build info of the binary. Such builds are just as reproducible.
+
+Similarly, GoExperiments in the config lists the GOEXPERIMENT values builds can
+be requested with, e.g. linux-amd64-go1.22.1-goexperiment.arenas/. Multiple
+experiments are comma-separated, sorted and unique. The experiments are
+recorded in the build info of the binary, and are part of the transparency log
+record.
+
+With SourceDateEpoch in the config, builds set SOURCE_DATE_EPOCH to the time of
+the module version as known by the goproxy, typically its commit time, for
+programs that embed a build time through code generation or build scripts. The
+Go toolchain itself does not use it. Since the time is the same for every build
+of the module version, builds remain reproducible. But binaries, and their sums,
+may differ from builds without SourceDateEpoch, so all verifiers need the same
+setting. Whether SOURCE_DATE_EPOCH was set is stored with each build result, and
+only those builds show it in their reproduce command.
+
+Module maintainers can show the build status of the latest version of their
+module in a README with a shields.io endpoint badge, for the latest Go toolchain
+(or the pinned Go version) for a target, default linux/amd64:
+
+	https://img.shields.io/endpoint?url=https%3A%2F%2Fbeta.gobuilds.org%2Fbadge.json%3Fmodule%3Dgithub.com%2Fmjl-%2Fgobuild%26target%3Dlinux%2Famd64
+
+The message has the version and binary size for a successful build. Builds are
+not started by badges.
 
 # Why gobuild
 
@@ -139,9 +209,45 @@ Test it with:
 
 	gobuild testconfig gobuild.conf
 
+Add flag -thorough to also check that key files are readable and valid, that
+the verifier key matches the signer key, that directories are writable and that
+verifiers are reachable.
+
+Send a SIGHUP to a running gobuild to reload the config file. Only the fields
+LogLevel, BadClients, ModulePrefixes, BlockedModulePrefixes and VerifierURLs are
+changed at runtime, other changes require a restart.
+
 By default, build results and sumdb files are stored in ./data, $HOME is set to
 ./home during builds and Go toolchains are installed in ./sdk.
 
+Toolchains are downloaded when first needed. To fetch a toolchain ahead of time,
+e.g. while creating a container image for another platform, run:
+
+	gobuild fetchsdk -target linux/arm64 -dir sdk go1.22.0
+
+At startup, only the last record of the transparency log is verified. To verify
+all records, and the binaries matching their sums, stop gobuild and run:
+
+	gobuild verifylog data
+
+For replicating the transparency log to another instance, set ReplicationToken
+in the config. The admin listener then serves records and their stored hashes at
+/tlog-replicate?start=<recordnumber> to requests with header "Authorization:
+Bearer <token>". The JSON response has fields Start, Records and Hashes (base64),
+TreeSize and TreeHash. A follower recalculates the stored hashes for each record
+in order, and only appends when they, and the resulting tree hash, match.
+
+To run a verified local mirror of another instance, e.g. gobuilds.org, set
+FollowerUpstream and FollowerVerifierKey, and ReadOnly. New records from the
+upstream transparency log are fetched every minute, authenticated against its
+signed tree heads, and added to the local log. With FollowerFetchBinaries, the
+binaries are fetched and verified too.
+
+To compare the build logs of two builds, e.g. when a build is not reproducible
+or starts failing with a new version or toolchain, request a unified diff from
+the admin listener at /logdiff?a=<build>&b=<build>. A build is a record number,
+or a path like <module>@<version>/<dir>/<goos>-<goarch>-<goversion>.
+
 You can configure your own signer key for your transparency log. Create new keys with:
 
 	gobuild genkey you.example.org
@@ -173,5 +279,8 @@ connections). The proxy should allow the following addresses:
 	acme-v02.api.letsencrypt.org:443
 
 	# Optional, any verifier URLs you have configured.
+
+To route traffic to the Go module proxy and to go.dev through different
+proxies, configure GoProxyHTTPProxy and GoReleasesHTTPProxy in the config file.
 */
 package main
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"sync"
+)
+
+// Active SSE connections for build updates, for limiting them.
+var eventStreams = struct {
+	sync.Mutex
+	total int
+	perIP map[string]int
+}{
+	perIP: map[string]int{},
+}
+
+// acquireEventStream registers an SSE connection for r. If the configured maximum
+// of concurrent SSE connections, in total or for the client IP, is reached, a 503
+// is written and ok is false. Otherwise, release must be called when the
+// connection is done.
+func acquireEventStream(w http.ResponseWriter, r *http.Request) (release func(), ok bool) {
+	ip := clientIP(r)
+
+	eventStreams.Lock()
+	defer eventStreams.Unlock()
+
+	var reason string
+	if config.MaxEventStreams > 0 && eventStreams.total >= config.MaxEventStreams {
+		reason = "total"
+	} else if config.MaxEventStreamsPerIP > 0 && ip != "" && eventStreams.perIP[ip] >= config.MaxEventStreamsPerIP {
+		reason = "ip"
+	}
+	if reason != "" {
+		metricEventStreamsRejected.WithLabelValues(reason).Inc()
+		slog.Debug("too many event streams", "reason", reason, "clientip", ip)
+		w.Header().Set("Retry-After", "60")
+		statusfailf(http.StatusServiceUnavailable, w, "Too many concurrent connections for build updates, try again later. To wait for a build without a browser, use \"gobuild get\", or request the download URL, which waits until the build has completed.")
+		return nil, false
+	}
+
+	eventStreams.total++
+	if ip != "" {
+		eventStreams.perIP[ip]++
+	}
+	metricEventStreamsActive.Inc()
+
+	release = func() {
+		eventStreams.Lock()
+		defer eventStreams.Unlock()
+		eventStreams.total--
+		if ip != "" {
+			eventStreams.perIP[ip]--
+			if eventStreams.perIP[ip] <= 0 {
+				delete(eventStreams.perIP, ip)
+			}
+		}
+		metricEventStreamsActive.Dec()
+	}
+	return release, true
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os/exec"
+	"strings"
+)
+
+// failureCategory classifies a failed build, for a headline and hint on the
+// build page, and as metric label.
+type failureCategory string
+
+const (
+	failureModuleNotFound  failureCategory = "module-not-found"
+	failureVersionNotFound failureCategory = "version-not-found"
+	failurePackageNotFound failureCategory = "package-not-found"
+	failureNotMain         failureCategory = "not-main"
+	failureCgoRequired     failureCategory = "cgo-required"
+	failureVetFailed       failureCategory = "vet-failed"
+	failureVersionTooOld   failureCategory = "version-too-old"
+	failureToolchainTooOld failureCategory = "toolchain-too-old"
+	failureCompileError    failureCategory = "compile-error"
+	failureOther           failureCategory = "other"
+)
+
+// failureInfo is shown above the error output of a failed build.
+type failureInfo struct {
+	Category failureCategory
+	Headline string
+	Hint     string // What the user can do about it.
+}
+
+var failureInfos = map[failureCategory]failureInfo{
+	failureModuleNotFound:  {failureModuleNotFound, "Module not found", "The goproxy does not know this module. Check the module path for typos, module paths are case-sensitive."},
+	failureVersionNotFound: {failureVersionNotFound, "Version not found", "The module exists, but not with this version. Check the version, or build the latest version of the module."},
+	failurePackageNotFound: {failurePackageNotFound, "Package not found", "The package directory does not exist in the module, or has no Go files. See the module page for the commands in the module."},
+	failureNotMain:         {failureNotMain, "Not a command", "The package is a library, building it does not result in a binary. See the module page for the commands in the module."},
+	failureCgoRequired:     {failureCgoRequired, "Requires cgo", "The package or one of its dependencies needs cgo, and gobuild only builds pure Go programs. The program may have a build tag or option to build without cgo."},
+	failureVetFailed:       {failureVetFailed, "Go vet failed", "This instance only builds packages for which go vet reports no problems. Fix the reported problems in a new version of the module."},
+	failureVersionTooOld:   {failureVersionTooOld, "Module version too old", "This instance does not automatically build old module versions. Build a recent version of the module, or add query parameter allowold=1 to the URL to build this version anyway."},
+	failureToolchainTooOld: {failureToolchainTooOld, "Go toolchain too old", "The module requires a newer Go version than requested. Select a newer Go toolchain version."},
+	failureCompileError:    {failureCompileError, "Compile error", "The go command could not build the package. The last lines of the build log typically indicate the problem."},
+	failureOther:           {failureOther, "Build failed", "See the error below for details."},
+}
+
+// Text in errors and go command output, for failures where we only have the
+// output, e.g. from a stored build log. Checked in order.
+var failurePatterns = []struct {
+	category failureCategory
+	substrs  []string
+}{
+	{failureCgoRequired, []string{"due to cgo dependencies"}},
+	{failureVetFailed, []string{"go vet reported problems"}},
+	{failureToolchainTooOld, []string{"newer than the requested go toolchain"}},
+	{failureNotMain, []string{"a library instead of a command"}},
+	{failurePackageNotFound, []string{"package directory "}},
+	{failureVersionNotFound, []string{"unknown revision", "invalid version", "no matching versions"}},
+	{failureModuleNotFound, []string{"404 Not Found", "410 Gone", "not found: module", "malformed module path"}},
+}
+
+// classifyFailure returns the category of a failed build, based on err and the
+// output of the build. Err is nil for failures read from a stored build log,
+// which are always compile failures unless the output indicates otherwise.
+func classifyFailure(err error, output string) failureInfo {
+	var cerr cgoError
+	var terr toolchainError
+	var verr vetError
+	if errors.As(err, &cerr) {
+		return failureInfos[failureCgoRequired]
+	} else if errors.As(err, &verr) {
+		return failureInfos[failureVetFailed]
+	} else if errors.As(err, &terr) {
+		return failureInfos[failureToolchainTooOld]
+	} else if errors.Is(err, errVersionTooOld) {
+		return failureInfos[failureVersionTooOld]
+	}
+
+	s := output
+	if err != nil {
+		s = err.Error() + "\n\n" + output
+	}
+	for _, p := range failurePatterns {
+		for _, substr := range p.substrs {
+			if strings.Contains(s, substr) {
+				return failureInfos[p.category]
+			}
+		}
+	}
+
+	var eerr *exec.ExitError
+	if err == nil || errors.As(err, &eerr) {
+		return failureInfos[failureCompileError]
+	}
+	if errors.Is(err, errBadVersion) {
+		return failureInfos[failureVersionNotFound]
+	}
+	return failureInfos[failureOther]
+}
+
+// failCategorized is like failf for a user error, but with a headline and hint
+// for the failure above the error message.
+func failCategorized(w http.ResponseWriter, f failureInfo, err error) {
+	status := http.StatusBadRequest
+	slog.Debug("http user error", "status", status, "err", err, "category", f.Category)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	args := map[string]string{
+		"Headline": f.Headline,
+		"Hint":     f.Hint,
+		"Message":  fmt.Sprintf("%d - %s - %s", status, http.StatusText(status), err),
+		"Notes":    readErrorNotes(),
+	}
+	if err := errorTemplate.Execute(w, args); err != nil {
+		slog.Error("executing template for error", "err", err)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Atom feed, see RFC 4287.
+type atomFeed struct {
+	XMLName   xml.Name      `xml:"http://www.w3.org/2005/Atom feed"`
+	Title     string        `xml:"title"`
+	ID        string        `xml:"id"`
+	Updated   string        `xml:"updated"`
+	Link      []atomLink    `xml:"link"`
+	Generator atomGenerator `xml:"generator"`
+	Entries   []atomEntry   `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomGenerator struct {
+	URI     string `xml:"uri,attr"`
+	Version string `xml:"version,attr"`
+	Name    string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	Title   string   `xml:"title"`
+	ID      string   `xml:"id"`
+	Updated string   `xml:"updated"`
+	Link    atomLink `xml:"link"`
+	Author  struct {
+		Name string `xml:"name"`
+	} `xml:"author"`
+	Summary string `xml:"summary"`
+}
+
+// serveRecentAtom serves an Atom feed with the most recent successful builds from
+// the transparency log.
+func serveRecentAtom(w http.ResponseWriter, r *http.Request) {
+	defer observePage("recentatom", time.Now())
+
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "405 - Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	n, err := treeSize()
+	if err != nil {
+		failf(w, "%w: reading number of records: %v", errServer, err)
+		return
+	}
+
+	// The feed only changes when a build is added to the transparency log.
+	etag := fmt.Sprintf(`"%d"`, n)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	baseURL := baseURL(r)
+
+	feed := atomFeed{
+		Title: "Recent builds on gobuild at " + r.Host,
+		ID:    baseURL + "/recent.atom",
+		Link: []atomLink{
+			{"self", baseURL + "/recent.atom"},
+			{"alternate", baseURL + "/builds"},
+		},
+		Generator: atomGenerator{"https://github.com/mjl-/gobuild", gobuildVersion, "gobuild"},
+	}
+
+	// Records have no timestamp, we use the modification time of the recordnumber file
+	// of a build.
+	var updated time.Time
+	count := min(n, 25)
+	if count > 0 {
+		records, err := serverOps{}.ReadRecords(r.Context(), n-count, count)
+		if err != nil {
+			failf(w, "%w: reading records: %v", errServer, err)
+			return
+		}
+		for i := len(records) - 1; i >= 0; i-- {
+			br, err := parseRecord(records[i])
+			if err != nil {
+				failf(w, "%w: parsing record: %v", errServer, err)
+				return
+			}
+			var mtime time.Time
+			if fi, err := results.Stat(br.storeKey(), "recordnumber"); err == nil {
+				mtime = fi.ModTime().UTC()
+			}
+			if mtime.After(updated) {
+				updated = mtime
+			}
+
+			var dir string
+			if br.Dir != "/" {
+				dir = br.Dir
+			}
+			link := baseURL + request{br.buildSpec, br.Sum, pageIndex}.link()
+			e := atomEntry{
+				Title:   fmt.Sprintf("%s@%s%s %s/%s %s", br.Mod, br.Version, dir, br.Goos, br.Goarch, br.Goversion),
+				ID:      link,
+				Updated: mtime.Format(time.RFC3339),
+				Link:    atomLink{"alternate", link},
+				Summary: fmt.Sprintf("Record %d, sum %s, %d bytes.", n-count+int64(i), br.Sum, br.Filesize),
+			}
+			if br.Stripped {
+				e.Title += " stripped"
+			}
+			if br.Race {
+				e.Title += " race"
+			}
+			if br.Netgo {
+				e.Title += " netgo"
+			}
+			if br.Vendor {
+				e.Title += " vendor"
+			}
+			if br.Tags != "" {
+				e.Title += " tags " + br.Tags
+			}
+			if br.FIPS140 != "" {
+				e.Title += " fips140 " + br.FIPS140
+			}
+			if br.Experiment != "" {
+				e.Title += " goexperiment " + br.Experiment
+			}
+			e.Author.Name = "gobuild"
+			feed.Entries = append(feed.Entries, e)
+		}
+	}
+	feed.Updated = updated.Format(time.RFC3339)
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	if !updated.IsZero() {
+		w.Header().Set("Last-Modified", updated.Format(http.TimeFormat))
+	}
+	if r.Method == "HEAD" {
+		return
+	}
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+	enc.Encode(feed) // nothing to do for errors
+}
@@ -3,10 +3,14 @@ package main
 import (
 	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
+	"golang.org/x/mod/modfile"
 	"golang.org/x/mod/module"
 )
 
@@ -16,20 +20,121 @@ var (
 	errBadVersion = errors.New("bad version")
 )
 
-// Fetches module@version for use in subsequent build.
+type ensuredModule struct {
+	modDir string
+	output []byte
+}
+
+var ensureFlights flights[ensuredModule]
+
+// modulePathError indicates the go.mod of a module declares a different module
+// path than the path the module was requested as.
+type modulePathError struct {
+	Declared string // From the "module" directive in go.mod.
+	Required string // As requested.
+}
+
+func (e modulePathError) Error() string {
+	if !e.FullPath() {
+		return fmt.Sprintf("module %s declares its path as %q in its go.mod file, which is not a full import path; gobuild can only build modules that declare their full import path, i.e. \"module %s\"", e.Required, e.Declared, e.Required)
+	}
+	return fmt.Sprintf("module %s declares its path as %s in its go.mod file; modules can only be built through the module path they declare, try /%s", e.Required, e.Declared, e.Declared)
+}
+
+// FullPath returns whether the declared module path looks like a full import
+// path, i.e. has a dot in the first path element, like a domain name.
+func (e modulePathError) FullPath() bool {
+	first, _, _ := strings.Cut(e.Declared, "/")
+	return strings.Contains(first, ".")
+}
+
+func (e modulePathError) Unwrap() error {
+	return errBadModule
+}
+
+// parseModulePathError looks for the error the go command prints when a go.mod
+// declares a different module path than requested, returning nil if absent.
+func parseModulePathError(output string) error {
+	const declaredPrefix = "module declares its path as:"
+	const requiredPrefix = "but was required as:"
+	_, rest, ok := strings.Cut(output, declaredPrefix)
+	if !ok {
+		return nil
+	}
+	declared, rest, _ := strings.Cut(rest, "\n")
+	_, rest, ok = strings.Cut(rest, requiredPrefix)
+	if !ok {
+		return nil
+	}
+	required, _, _ := strings.Cut(rest, "\n")
+	return modulePathError{strings.TrimSpace(declared), strings.TrimSpace(required)}
+}
+
+// toolchainError indicates the module, or one of its dependencies, requires a
+// newer Go toolchain than the build was requested with. We always build with
+// exactly the requested toolchain, through GOTOOLCHAIN.
+type toolchainError struct {
+	Required string // E.g. "1.23.0", as printed by the go command.
+	Suggest  string // Link to build with the oldest supported toolchain that satisfies Required. Set by prepareBuild, can be empty.
+}
+
+func (e toolchainError) Error() string {
+	s := fmt.Sprintf("module requires go >= %s, newer than the requested go toolchain", e.Required)
+	if e.Suggest != "" {
+		s += "; build with a newer go toolchain instead, e.g. " + e.Suggest
+	} else {
+		s += "; build with a newer go toolchain instead"
+	}
+	return s
+}
+
+func (e toolchainError) Unwrap() error {
+	return errBadGoversion
+}
+
+// parseToolchainError looks for the error the go command prints when a go.mod
+// requires a newer go toolchain than the one running.
+func parseToolchainError(output string) (toolchainError, bool) {
+	_, rest, ok := strings.Cut(output, "requires go >= ")
+	if !ok {
+		return toolchainError{}, false
+	}
+	required, _, _ := strings.Cut(rest, " ")
+	return toolchainError{Required: strings.TrimSpace(required)}, true
+}
+
+// Fetches module@version for use in subsequent build. Returns the directory of
+// the module, and output of the go command on errors. Concurrent calls for the
+// same module@version share a single fetch.
 func ensureModule(goversion, gobin, mod, version string) (string, []byte, error) {
+	em, err := ensureFlights.do(mod+"@"+version, func() (ensuredModule, error) {
+		modDir, output, err := ensureModuleOnce(goversion, gobin, mod, version)
+		return ensuredModule{modDir, output}, err
+	})
+	return em.modDir, em.output, err
+}
+
+// moduleDir returns the directory in the module cache for mod@version.
+func moduleDir(mod, version string) (string, error) {
 	modPath, err := module.EscapePath(mod)
 	if err != nil {
-		return "", nil, fmt.Errorf("%w: %v", errBadModule, err)
+		return "", fmt.Errorf("%w: %v", errBadModule, err)
 	}
 	modVersion, err := module.EscapeVersion(version)
 	if err != nil {
-		return "", nil, fmt.Errorf("%w: %v", errBadVersion, err)
+		return "", fmt.Errorf("%w: %v", errBadVersion, err)
+	}
+	return filepath.Join(homedir, "go", "pkg", "mod", filepath.Clean(modPath)+"@"+modVersion), nil
+}
+
+func ensureModuleOnce(goversion, gobin, mod, version string) (string, []byte, error) {
+	modDir, err := moduleDir(mod, version)
+	if err != nil {
+		return "", nil, err
 	}
-	modDir := filepath.Join(homedir, "go", "pkg", "mod", filepath.Clean(modPath)+"@"+modVersion)
 
 	if _, err := os.Stat(modDir); err == nil {
-		return modDir, nil, nil
+		return modDir, nil, checkModulePath(modDir, mod)
 	} else if !os.IsNotExist(err) {
 		return "", nil, fmt.Errorf("%w: checking if module is checked out locally: %v", errServer, err)
 	}
@@ -38,7 +143,106 @@ func ensureModule(goversion, gobin, mod, version string) (string, []byte, error)
 	if output, err := fetchModule(goversion, modDir, gobin, mod, version); err != nil {
 		return "", output, err
 	}
-	return modDir, nil, nil
+	return modDir, nil, checkModulePath(modDir, mod)
+}
+
+// Maximum number of module sizes to keep in moduleSizes.
+const moduleSizesMax = 10000
+
+// Sizes of modules in the module cache, keyed by module directory. Module
+// contents are immutable, so we only walk a module directory once while its size
+// is cached. Cleared when full.
+var moduleSizes = struct {
+	sync.Mutex
+	sizes map[string]int64
+}{
+	sizes: map[string]int64{},
+}
+
+// checkModuleSize returns an error wrapping errBadModule if the files in modDir
+// are larger than config.MaxModuleBytes.
+func checkModuleSize(modDir string) error {
+	if config.MaxModuleBytes <= 0 {
+		return nil
+	}
+
+	moduleSizes.Lock()
+	size, ok := moduleSizes.sizes[modDir]
+	moduleSizes.Unlock()
+	if !ok {
+		err := filepath.WalkDir(modDir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.Type().IsRegular() {
+				fi, err := d.Info()
+				if err != nil {
+					return err
+				}
+				size += fi.Size()
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("%w: determining size of module: %v", errServer, err)
+		}
+		moduleSizes.Lock()
+		if len(moduleSizes.sizes) >= moduleSizesMax {
+			moduleSizes.sizes = map[string]int64{}
+		}
+		moduleSizes.sizes[modDir] = size
+		moduleSizes.Unlock()
+	}
+	if size > config.MaxModuleBytes {
+		metricModuleTooLargeErrors.Inc()
+		return fmt.Errorf("%w: module is %d bytes, larger than the maximum of %d bytes allowed by this gobuild instance", errBadModule, size, config.MaxModuleBytes)
+	}
+	return nil
+}
+
+// checkModulePath returns a modulePathError if the go.mod in modDir declares a
+// module path other than mod. Builds of such modules fail.
+func checkModulePath(modDir, mod string) error {
+	buf, err := os.ReadFile(filepath.Join(modDir, "go.mod"))
+	if err != nil {
+		// Modules without go.mod get one synthesized with the right path.
+		return nil
+	}
+	if declared := modfile.ModulePath(buf); declared != "" && declared != mod {
+		return modulePathError{declared, mod}
+	}
+	return nil
+}
+
+// checkLocalReplaces returns an error listing the replace directives in the
+// go.mod in modDir that point to local directories. They are not in the module
+// zip file, and the go command ignores or refuses replace directives of modules
+// that are not the main module anyway.
+func checkLocalReplaces(modDir string) error {
+	buf, err := os.ReadFile(filepath.Join(modDir, "go.mod"))
+	if err != nil {
+		return nil
+	}
+	f, err := modfile.ParseLax("go.mod", buf, nil)
+	if err != nil {
+		// The go command will report a proper error.
+		return nil
+	}
+	var l []string
+	for _, r := range f.Replace {
+		if r.New.Version == "" && modfile.IsDirectoryPath(r.New.Path) {
+			old := r.Old.Path
+			if r.Old.Version != "" {
+				old += " " + r.Old.Version
+			}
+			l = append(l, fmt.Sprintf("replace %s => %s", old, r.New.Path))
+		}
+	}
+	if len(l) == 0 {
+		return nil
+	}
+	metricLocalReplaceErrors.Inc()
+	return fmt.Errorf("%w: go.mod has replace directives pointing to local directories, gobuild builds modules as dependency, through the goproxy, and cannot honor them; the module must be changed to build without local replaces:\n\n%s", errBadModule, strings.Join(l, "\n"))
 }
 
 func fetchModule(goversion, modDir, gobin, mod, version string) ([]byte, error) {
@@ -57,21 +261,47 @@ func fetchModule(goversion, modDir, gobin, mod, version string) ([]byte, error)
 		// <module>@<version>" downloads the module, we get the dependencies by running "go
 		// mod download" again in the checked out module path.
 		cmd := makeCommand(goversion, goproxy, emptyDir, cgo, nil, gobin, "mod", "download", "-x", "--", mod+"@"+version)
+		release := acquireCommand()
 		output, err := cmd.CombinedOutput()
+		release()
 		if err != nil {
 			metricGogetErrors.Inc()
+			if perr := parseModulePathError(string(output)); perr != nil {
+				return output, perr
+			}
+			if terr, ok := parseToolchainError(string(output)); ok {
+				return output, terr
+			}
 			return output, fmt.Errorf("go mod download module: %v", err)
 		}
 
 		cmd = makeCommand(goversion, goproxy, modDir, cgo, nil, gobin, "mod", "download", "-x")
-		if output2, err := cmd.CombinedOutput(); err != nil {
+		release = acquireCommand()
+		output2, err := cmd.CombinedOutput()
+		release()
+		if err != nil {
 			metricGogetErrors.Inc()
+			if perr := parseModulePathError(string(output2)); perr != nil {
+				return append(output, output2...), perr
+			}
+			if terr, ok := parseToolchainError(string(output2)); ok {
+				return append(output, output2...), terr
+			}
 			return append(output, output2...), fmt.Errorf("go mod download dependencies: %v", err)
 		}
 	} else {
 		cmd := makeCommand(goversion, goproxy, emptyDir, cgo, nil, gobin, "get", "-d", "-x", "-v", "--", mod+"@"+version)
-		if output, err := cmd.CombinedOutput(); err != nil {
+		release := acquireCommand()
+		output, err := cmd.CombinedOutput()
+		release()
+		if err != nil {
 			metricGogetErrors.Inc()
+			if perr := parseModulePathError(string(output)); perr != nil {
+				return output, perr
+			}
+			if terr, ok := parseToolchainError(string(output)); ok {
+				return output, terr
+			}
 			return output, fmt.Errorf("go get: %v", err)
 		}
 	}
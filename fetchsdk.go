@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// fetchsdk downloads a go toolchain into a directory with the same layout as
+// the SDKDir of the config, e.g. for pre-seeding toolchains in a container image
+// for another platform.
+func fetchsdk(args []string) {
+	flags := flag.NewFlagSet("fetchsdk", flag.ExitOnError)
+
+	var (
+		dir    = flags.String("dir", "sdk", "Directory to store the toolchain in, as subdirectory named after the go version. Typically the SDKDir from the config.")
+		target = flags.String("target", "", "Host platform the toolchain will run on, as goos/goarch. Default is current GOOS/GOARCH.")
+	)
+
+	flags.Usage = func() {
+		log.Println("usage: gobuild fetchsdk [flags] goversion")
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	flags.Parse(args)
+	args = flags.Args()
+	if len(args) != 1 {
+		flags.Usage()
+	}
+	goversion := args[0]
+	if _, err := parseGoVersion(goversion); err != nil {
+		log.Fatalf("bad goversion %q: %v", goversion, err)
+	}
+
+	goos, goarch := runtime.GOOS, runtime.GOARCH
+	if *target != "" {
+		t := strings.Split(*target, "/")
+		if len(t) != 2 || t[0] == "" || t[1] == "" {
+			log.Fatalf("bad target %q, must be of the form goos/goarch", *target)
+		}
+		goos, goarch = t[0], t[1]
+	}
+
+	dst := filepath.Join(*dir, goversion)
+	if _, err := os.Stat(dst); err == nil {
+		log.Fatalf("%s already exists", dst)
+	}
+
+	if err := os.MkdirAll(*dir, 0777); err != nil {
+		log.Fatalf("creating directory: %v", err)
+	}
+
+	rels, err := listReleases()
+	if err != nil {
+		log.Fatalf("listing go releases: %v", err)
+	}
+	for _, rel := range rels {
+		if rel.Version != goversion {
+			continue
+		}
+		tmpdir, signer, err := fetchSDK(rel, goos, goarch, *dir)
+		if err != nil {
+			err = fmt.Errorf("fetching toolchain: %v", err)
+		} else if xerr := os.Rename(filepath.Join(tmpdir, "go"), dst); xerr != nil {
+			err = fmt.Errorf("putting toolchain in place: %v", xerr)
+		}
+		if tmpdir != "" {
+			os.RemoveAll(tmpdir)
+		}
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		fmt.Printf("%s (%s/%s, signed by key %s)\n", dst, goos, goarch, signer)
+		return
+	}
+	log.Fatalf("unknown go version %s", goversion)
+}
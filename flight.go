@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// flights lets concurrent calls for the same key share the result of a single
+// call, e.g. to prevent running the same go command multiple times when many
+// requests for a module come in at the same time. Results are not kept after
+// the call completes.
+type flights[T any] struct {
+	sync.Mutex
+	calls map[string]*flight[T]
+}
+
+type flight[T any] struct {
+	done   chan struct{} // Closed when result and err are set.
+	result T
+	err    error
+}
+
+// do calls fn and returns its result, unless a call for key is already in
+// progress, in which case it waits for and returns the result of that call.
+func (f *flights[T]) do(key string, fn func() (T, error)) (T, error) {
+	f.Lock()
+	if c, ok := f.calls[key]; ok {
+		f.Unlock()
+		<-c.done
+		return c.result, c.err
+	}
+	if f.calls == nil {
+		f.calls = map[string]*flight[T]{}
+	}
+	c := &flight[T]{done: make(chan struct{})}
+	f.calls[key] = c
+	f.Unlock()
+
+	defer func() {
+		f.Lock()
+		delete(f.calls, key)
+		f.Unlock()
+		close(c.done)
+	}()
+	c.result, c.err = fn()
+	return c.result, c.err
+}
+
+// doContext is like do, but fn runs in its own goroutine, with a context that is
+// not canceled with ctx of the caller that started the call, and that expires
+// after timeout. Each caller, including the first, stops waiting when its own
+// ctx is done, the call continues for the others.
+func (f *flights[T]) doContext(ctx context.Context, key string, timeout time.Duration, fn func(ctx context.Context) (T, error)) (T, error) {
+	f.Lock()
+	c, ok := f.calls[key]
+	if !ok {
+		if f.calls == nil {
+			f.calls = map[string]*flight[T]{}
+		}
+		c = &flight[T]{done: make(chan struct{})}
+		f.calls[key] = c
+		fctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+		go func() {
+			defer cancel()
+			c.result, c.err = fn(fctx)
+			f.Lock()
+			delete(f.calls, key)
+			f.Unlock()
+			close(c.done)
+		}()
+	}
+	f.Unlock()
+
+	select {
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	case <-c.done:
+		return c.result, c.err
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mjl-/gobuild/internal/sumdb"
+
+	"golang.org/x/mod/sumdb/tlog"
+)
+
+// Interval between checks for new records in the upstream transparency log.
+const followInterval = time.Minute
+
+// Maximum number of records to fetch and add per batch.
+const followBatch = 256
+
+// follow periodically adds new records from the transparency log of
+// config.FollowerUpstream to the local log, through client. It never returns.
+//
+// Signed tree heads are verified by the client, which also checks that the
+// upstream log is append-only. A security error from the client, i.e. upstream
+// misbehaviour, is fatal.
+func follow(client *sumdb.Client) {
+	for {
+		if err := followOnce(client); err != nil {
+			metricFollowErrors.Inc()
+			slog.Error("follow: adding records from upstream", "err", err, "upstream", config.FollowerUpstream)
+		}
+		time.Sleep(followInterval)
+	}
+}
+
+func followOnce(client *sumdb.Client) error {
+	tree, err := client.Latest()
+	if err != nil {
+		return fmt.Errorf("fetching latest tree: %v", err)
+	}
+
+	n, err := treeSize()
+	if err != nil {
+		return fmt.Errorf("determining local tree size: %v", err)
+	}
+	if n > tree.N {
+		return fmt.Errorf("local log has %d records, more than the %d records of upstream", n, tree.N)
+	}
+
+	// Check the local log is a prefix of the upstream log before adding to it.
+	if err := followCheckTree(client, n); err != nil {
+		return err
+	}
+
+	for n < tree.N {
+		records, err := client.ReadRecords(n, min(followBatch, tree.N-n))
+		if err != nil {
+			return fmt.Errorf("reading records from upstream: %v", err)
+		}
+		for _, data := range records {
+			if err := followAdd(n, data); err != nil {
+				return fmt.Errorf("adding record %d: %v", n, err)
+			}
+			n++
+		}
+		if err := followCheckTree(client, n); err != nil {
+			return err
+		}
+		slog.Info("follow: added records from upstream", "treesize", n, "upstreamtreesize", tree.N)
+	}
+	return nil
+}
+
+// followCheckTree checks that the local tree of size n has the same hash as the
+// upstream tree of that size.
+func followCheckTree(client *sumdb.Client, n int64) error {
+	if n == 0 {
+		return nil
+	}
+	upstream, err := client.TreeHash(n)
+	if err != nil {
+		return fmt.Errorf("calculating hash of upstream tree of size %d: %v", n, err)
+	}
+	local, err := tlog.TreeHash(n, hashReader{})
+	if err != nil {
+		return fmt.Errorf("calculating hash of local tree of size %d: %v", n, err)
+	}
+	if local != upstream {
+		metricTlogConsistencyErrors.Inc()
+		return fmt.Errorf("local log has diverged from upstream, hash of tree of size %d is %v, upstream has %v", n, local, upstream)
+	}
+	return nil
+}
+
+// followAdd adds record num with data, as authenticated from the upstream log,
+// to the local log. With config.FollowerFetchBinaries, the binary is fetched
+// and stored too.
+func followAdd(num int64, data []byte) error {
+	br, err := parseRecord(data)
+	if err != nil {
+		return fmt.Errorf("parsing record: %v", err)
+	}
+
+	tmpdir, err := os.MkdirTemp(resultDir, "tmpfollow")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpdir) // Moved into place by addRecord on success.
+
+	if config.FollowerFetchBinaries {
+		if err := followFetchBinary(*br, filepath.Join(tmpdir, "binary.gz")); err != nil && errors.Is(err, os.ErrNotExist) {
+			// Upstream may have cleaned up the binary. It can still be rebuilt locally if needed.
+			slog.Info("follow: binary not available at upstream", "record", num, "buildspec", br.buildSpec)
+		} else if err != nil {
+			return fmt.Errorf("fetching binary: %v", err)
+		}
+	}
+
+	// Records are added as received, packing the parsed record may not result in
+	// the same bytes for older record formats.
+	rnum, err := addRecord(tmpdir, *br, data)
+	if err != nil {
+		return err
+	}
+	if rnum != num {
+		return fmt.Errorf("record added as number %d, expected %d", rnum, num)
+	}
+	return nil
+}
+
+// followFetchBinary fetches the gzipped binary for br from upstream, writes it to
+// path and verifies it matches the sum. Returns an error matching
+// os.ErrNotExist if upstream doesn't have the binary.
+func followFetchBinary(br buildResult, path string) error {
+	link := request{br.buildSpec, br.Sum, pageDownloadGz}.link()
+	resp, err := httpGet(strings.TrimRight(config.FollowerUpstream, "/") + link)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == 404 {
+		return fmt.Errorf("%w: http get: %v", os.ErrNotExist, resp.Status)
+	} else if resp.StatusCode != 200 {
+		return fmt.Errorf("http get: %v", resp.Status)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return fmt.Errorf("writing binary: %v", err)
+	} else if err := f.Close(); err != nil {
+		return fmt.Errorf("close binary: %v", err)
+	}
+
+	f, err = os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gzr, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("gzip reader: %v", err)
+	}
+	if sum, err := readerSum(gzr); err != nil {
+		return fmt.Errorf("decompressing binary: %v", err)
+	} else if sum != br.Sum {
+		return fmt.Errorf("binary has sum %s, record has %s", sum, br.Sum)
+	}
+	return nil
+}
@@ -4,14 +4,19 @@ import (
 	"compress/gzip"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
+
+	"github.com/mjl-/gobuild/internal/sumdb"
 )
 
 // Once gobuild is out of beta, this will be the verifier key for gobuilds.org.
@@ -28,12 +33,20 @@ func get(args []string) {
 		verbose     = flags.Bool("verbose", false, "Print actions.")
 		sum         = flags.String("sum", "", "Sum to verify.")
 		bindir      = flags.String("bindir", ".", "Directory to store binary in.")
-		target      = flags.String("target", "", "Target to retrieve binary for. Default is current GOOS/GOARCH.")
+		target      = flags.String("target", "", "Target to retrieve binary for. Default is current GOOS/GOARCH. Multiple comma-separated targets can be specified, the binaries are stored in bindir with goos and goarch in their filenames.")
 		goversion   = flags.String("goversion", "latest", `Go toolchain/SDK version. Default "latest" resolves through go.dev/dl/, caching results for 1 hour.`)
 		download    = flags.Bool("download", true, "Download binary.")
 		goproxy     = flags.String("goproxy", "https://proxy.golang.org", `Go proxy to use for resolving "latest" module versions.`)
 		stripped    = flags.Bool("stripped", false, "Retrieve binary without symbol table and debug information.")
+		netgo       = flags.Bool("netgo", false, "Retrieve binary built with build tags netgo and osusergo.")
+		vendor      = flags.Bool("vendor", false, "Retrieve binary built with -mod=vendor, with dependencies from the vendor directory of the module.")
+		tags        = flags.String("tags", "", "Comma-separated build tags the binary was built with, e.g. netgo,purego. Only available on gobuild instances that allow the tags.")
+		fips140     = flags.String("fips140", "", "GOFIPS140 the binary was built with, e.g. latest or v1.0.0. Only available on gobuild instances that allow the value.")
+		experiment  = flags.String("goexperiment", "", "GOEXPERIMENT the binary was built with, e.g. arenas. Only available on gobuild instances that allow the experiment.")
 		quiet       = flags.Bool("quiet", false, "Do not print path that is written.")
+		output      = flags.String("o", "", `Path to write binary to, instead of a file in bindir. If "-", the binary is written to stdout, after it has been verified.`)
+		listTargets = flags.Bool("list-targets", false, "List targets (goos/goarch) with a successful build on the server for the module, version, package, goversion and stripped flag, instead of downloading. No builds are started. The list is not verified through the transparency log.")
+		skipTlog    = flags.Bool("insecure-skip-tlog", false, "Do not look up the sum in the transparency log, only download the binary from the gobuild instance at -url and check it has the sum from -sum. Meant for testing against a local instance without verifier key. Requires -sum, -url, an explicit module version and -goversion, and a single target. Insecure: the sum is not checked against the append-only log.")
 	)
 
 	flags.Usage = func() {
@@ -57,84 +70,291 @@ func get(args []string) {
 		}
 	}
 
-	// Parse specifier from command-line.
-	bs, err := parseGetSpec(args[0])
-	if err != nil {
-		log.Fatalf("parsing module@version/package: %v", err)
+	if *listTargets {
+		if *target != "" || *sum != "" || *output != "" {
+			log.Fatal("flag -list-targets cannot be used with -target, -sum or -o")
+		}
+		_, clientOps, err := newClient(*verifierKey, *baseURL, "")
+		if err != nil {
+			log.Fatalf("new client: %v", err)
+		}
+		bs := getBuildSpec(args[0], "", *goversion, *stripped, *netgo, *vendor, *tags, *fips140, *experiment)
+		if err := getListTargets(strings.TrimSuffix(clientOps.baseURL, "/tlog"), bs, *quiet); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
-	bs.Goversion = *goversion
 
-	// Set goos & goarch based on -target or runtime.
-	if *target == "" {
-		bs.Goos = runtime.GOOS
-		bs.Goarch = runtime.GOARCH
+	targetList := strings.Split(*target, ",")
+	multiple := len(targetList) > 1
+	if multiple && (*sum != "" || *output != "") {
+		log.Fatal("flags -sum and -o cannot be used with multiple targets")
+	}
+	var specs []buildSpec
+	for _, t := range targetList {
+		specs = append(specs, getBuildSpec(args[0], t, *goversion, *stripped, *netgo, *vendor, *tags, *fips140, *experiment))
+	}
+
+	var client *sumdb.Client
+	var gobuildBaseURL string
+	if *skipTlog {
+		if *sum == "" || *baseURL == "" || multiple {
+			log.Fatal("flag -insecure-skip-tlog requires -sum and -url, and cannot be used with multiple targets")
+		}
+		if specs[0].Version == "latest" || specs[0].Goversion == "latest" {
+			log.Fatal("flag -insecure-skip-tlog requires an explicit module version and -goversion, they cannot be resolved without the transparency log")
+		}
+		gobuildBaseURL = strings.TrimSuffix(strings.TrimRight(*baseURL, "/"), "/tlog")
+		log.Printf("WARNING: -insecure-skip-tlog is set, the binary is NOT verified with the transparency log, only against -sum")
 	} else {
-		t := strings.Split(*target, "/")
-		if len(t) != 2 {
-			log.Fatal("bad target")
+		var ops *clientOps
+		var err error
+		client, ops, err = newClient(*verifierKey, *baseURL, "")
+		if err != nil {
+			log.Fatalf("new client: %v", err)
 		}
-		bs.Goos = t[0]
-		bs.Goarch = t[1]
-	}
-	if *stripped {
-		bs.Stripped = true
+		gobuildBaseURL = strings.TrimSuffix(ops.baseURL, "/tlog")
 	}
 
-	client, clientOps, err := newClient(*verifierKey, *baseURL)
-	if err != nil {
-		log.Fatalf("new client: %v", err)
+	// lookup returns the build result for bs from the transparency log, or as
+	// specified on the command-line if the transparency log is skipped.
+	lookup := func(bs buildSpec) (*buildResult, error) {
+		if *skipTlog {
+			return &buildResult{buildSpec: bs, Sum: *sum}, nil
+		}
+
+		key := bs.String()
+		getLog("looking up key %s", key)
+		_, data, err := client.Lookup(key)
+		if err != nil {
+			return nil, fmt.Errorf("lookup: %v", err)
+		}
+
+		br, err := parseRecord(data)
+		if err != nil {
+			return nil, fmt.Errorf("parsing record from remote: %v", err)
+		}
+
+		rkey := br.String()
+		if rkey != key && *sum != "" {
+			return nil, fmt.Errorf("lookup resolved to %s", rkey)
+		}
+
+		if *sum != "" {
+			if *sum != br.Sum {
+				return nil, fmt.Errorf("remote has different sum %s, expected %s", br.Sum, *sum)
+			}
+			getLog("sum matches")
+		}
+
+		if (rkey != key || *sum == "") && !*quiet {
+			log.Printf("resolved to %s, sum %s", rkey, br.Sum)
+		}
+		return br, nil
 	}
-	key := bs.String()
-	getLog("looking up key %s", key)
-	_, data, err := client.Lookup(key)
-	if err != nil {
-		log.Fatalf("lookup: %v", err)
+
+	// getTarget looks up and downloads a single target.
+	getTarget := func(bs buildSpec) error {
+		br, err := lookup(bs)
+		if err != nil {
+			return err
+		}
+
+		if !*download {
+			return nil
+		}
+
+		if *output == "-" {
+			// Download and verify into a temp file first, we must never write unverified
+			// data to stdout.
+			if f, err := os.CreateTemp("", br.filename()+".gobuildget"); err != nil {
+				return fmt.Errorf("creating temp file for downloading: %v", err)
+			} else if err := fetch(f, gobuildBaseURL, br, ""); err != nil {
+				if xerr := os.Remove(f.Name()); xerr != nil {
+					log.Printf("removing tempfile %s: %v", f.Name(), xerr)
+				}
+				return err
+			} else if err := copyFileOut(f.Name(), os.Stdout); err != nil {
+				return fmt.Errorf("writing binary to stdout: %v", err)
+			}
+			return nil
+		}
+
+		name := br.filename()
+		if multiple {
+			name = br.targetFilename()
+		}
+		dst := filepath.Join(*bindir, name)
+		if *output != "" {
+			dst = *output
+		}
+		if !*quiet && br.Filesize > 0 {
+			log.Printf("writing to %s, size %.1fmb", dst, float64(br.Filesize)/(1024*1024))
+		} else if !*quiet {
+			log.Printf("writing to %s", dst)
+		}
+		if _, err := os.Stat(dst); err == nil {
+			return fmt.Errorf("aborted: destination path %s already exists", dst)
+		}
+
+		// Retrieve file to destination directory with temp name, calculate checksum as we go.
+		if f, err := os.CreateTemp(filepath.Dir(dst), name+".gobuildget"); err != nil {
+			return fmt.Errorf("creating temp file for downloading: %v", err)
+		} else if err := fetch(f, gobuildBaseURL, br, dst); err != nil {
+			if xerr := os.Remove(f.Name()); xerr != nil {
+				log.Printf("removing tempfile %s: %v", f.Name(), xerr)
+			}
+			return err
+		}
+		return nil
 	}
 
-	br, err := parseRecord(data)
-	if err != nil {
-		log.Fatalf("parsing record from remote: %v", err)
+	if !multiple {
+		if err := getTarget(specs[0]); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	rkey := br.String()
-	if rkey != key && *sum != "" {
-		log.Fatalf("lookup resolved to %s", rkey)
+	// Fetch multiple targets with a few workers. Failures for one target don't stop
+	// the others, we print a summary at the end.
+	errs := make([]error, len(specs))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				errs[i] = getTarget(specs[i])
+			}
+		}()
+	}
+	for i := range specs {
+		work <- i
 	}
+	close(work)
+	wg.Wait()
 
-	if *sum != "" {
-		if *sum != br.Sum {
-			log.Fatalf("remote has different sum %s, expected %s", br.Sum, *sum)
+	var failed int
+	for i, bs := range specs {
+		if errs[i] != nil {
+			failed++
+			log.Printf("%s/%s: error: %v", bs.Goos, bs.Goarch, errs[i])
+		} else if !*quiet {
+			log.Printf("%s/%s: ok", bs.Goos, bs.Goarch)
 		}
-		getLog("sum matches")
 	}
-
-	if (rkey != key || *sum == "") && !*quiet {
-		log.Printf("resolved to %s, sum %s", rkey, br.Sum)
+	if failed > 0 {
+		log.Fatalf("%d of %d targets failed", failed, len(specs))
 	}
+}
 
-	if !*download {
-		return
+// copyFileOut writes the file at path to w and removes the file.
+func copyFileOut(path string, w io.Writer) error {
+	defer func() {
+		if err := os.Remove(path); err != nil {
+			log.Printf("removing tempfile %s: %v", path, err)
+		}
+	}()
+	f, err := os.Open(path)
+	if err != nil {
+		return err
 	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
 
-	dst := filepath.Join(*bindir, br.filename())
-	if !*quiet {
-		log.Printf("writing to %s, size %.1fmb", dst, float64(br.Filesize)/(1024*1024))
+// getListTargets prints the targets with successful builds for bs on the gobuild
+// instance at gobuildBaseURL, one per line.
+func getListTargets(gobuildBaseURL string, bs buildSpec, quiet bool) error {
+	q := url.Values{}
+	q.Set("module", bs.Mod)
+	q.Set("version", bs.Version)
+	q.Set("dir", bs.Dir)
+	q.Set("goversion", bs.Goversion)
+	if bs.Stripped {
+		q.Set("stripped", "true")
+	}
+	if bs.Netgo {
+		q.Set("netgo", "true")
+	}
+	if bs.Vendor {
+		q.Set("vendor", "true")
+	}
+	if bs.Tags != "" {
+		q.Set("tags", bs.Tags)
+	}
+	if bs.FIPS140 != "" {
+		q.Set("fips140", bs.FIPS140)
+	}
+	if bs.Experiment != "" {
+		q.Set("goexperiment", bs.Experiment)
+	}
+	link := gobuildBaseURL + "/targets.json?" + q.Encode()
+	getLog("listing targets at %s", link)
+	resp, err := httpGet(link)
+	if err != nil {
+		return fmt.Errorf("making request to list targets: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("remote http response for listing targets: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var tr targetsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		return fmt.Errorf("parsing targets from remote: %v", err)
 	}
-	if _, err := os.Stat(dst); err == nil {
-		log.Fatalf("aborted: destination path %s already exists", dst)
+	if !quiet {
+		log.Printf("resolved to %s@%s%s %s", tr.Module, tr.Version, strings.TrimSuffix(tr.Dir, "/"), tr.Goversion)
 	}
+	for _, t := range tr.Targets {
+		fmt.Println(t)
+	}
+	return nil
+}
 
-	gobuildBaseURL := strings.TrimSuffix(clientOps.baseURL, "/tlog")
+// getBuildSpec parses the module@version/package specifier and target from the
+// command-line, returning the buildspec to look up.
+func getBuildSpec(spec, target, goversion string, stripped, netgo, vendor bool, tags, fips140, experiment string) buildSpec {
+	bs, err := parseGetSpec(spec)
+	if err != nil {
+		log.Fatalf("parsing module@version/package: %v", err)
+	}
+	bs.Goversion = goversion
 
-	// Retrieve file to bindir with temp name, calculate checksum as we go.
-	if f, err := os.CreateTemp(*bindir, br.filename()+".gobuildget"); err != nil {
-		log.Fatalf("creating temp file for downloading: %v", err)
-	} else if err := fetch(f, gobuildBaseURL, br, dst); err != nil {
-		if xerr := os.Remove(f.Name()); xerr != nil {
-			log.Printf("removing tempfile %s: %v", f.Name(), xerr)
+	// Set goos & goarch based on -target or runtime.
+	if target == "" {
+		bs.Goos = runtime.GOOS
+		bs.Goarch = runtime.GOARCH
+	} else {
+		t := strings.Split(target, "/")
+		if len(t) != 2 {
+			log.Fatal("bad target")
 		}
-		log.Fatal(err)
+		bs.Goos = t[0]
+		bs.Goarch = t[1]
+	}
+	bs.Stripped = stripped
+	bs.Netgo = netgo
+	bs.Vendor = vendor
+	bs.Tags, err = canonicalTags(tags)
+	if err != nil {
+		log.Fatalf("parsing build tags: %v", err)
+	}
+	if fips140 != "" && !validFIPS140(fips140) {
+		log.Fatal("bad fips140")
 	}
+	bs.FIPS140 = fips140
+	if experiment != "" {
+		bs.Experiment, err = canonicalExperiment(experiment)
+		if err != nil {
+			log.Fatalf("parsing goexperiment: %v", err)
+		}
+	}
+	return bs
 }
 
 func fetch(f *os.File, gobuildBaseURL string, br *buildResult, dst string) error {
@@ -168,12 +388,15 @@ func fetch(f *os.File, gobuildBaseURL string, br *buildResult, dst string) error
 	if dlSum != br.Sum {
 		return fmt.Errorf("downloaded binary has sum %s, expected %s", dlSum, br.Sum)
 	}
+	if br.SHA256 != "" && fmt.Sprintf("%x", sha) != br.SHA256 {
+		return fmt.Errorf("downloaded binary has sha256 %x, expected %s", sha, br.SHA256)
+	}
 	getLog("sum of downloaded file matches")
 
 	// Attempt to make file executable.
 	info, err := f.Stat()
 	if err != nil {
-		log.Fatalf("stat temp file: %v", err)
+		return fmt.Errorf("stat temp file: %v", err)
 	}
 	// Set the "x" bit for the positions that have the "r" bit.
 	mode := info.Mode() | (0111 & (info.Mode() >> 2))
@@ -186,6 +409,11 @@ func fetch(f *os.File, gobuildBaseURL string, br *buildResult, dst string) error
 		return fmt.Errorf("close destination file: %v", err)
 	}
 
+	// Without destination, the caller uses the verified temp file.
+	if dst == "" {
+		return nil
+	}
+
 	// Rename binary to final name.
 	if err := os.Rename(tmpName, dst); err != nil {
 		return fmt.Errorf("rename to final destination: %v", err)
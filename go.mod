@@ -3,6 +3,7 @@ module github.com/mjl-/gobuild
 go 1.22.0
 
 require (
+	github.com/klauspost/compress v1.17.11
 	github.com/mjl-/goreleases v0.0.4
 	github.com/mjl-/sconf v0.0.8
 	github.com/prometheus/client_golang v1.14.0
@@ -3,23 +3,36 @@ package main
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"crypto/sha256"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+
+	"golang.org/x/mod/sumdb/note"
 )
 
 var errTempFailure = errors.New("temporary failure")
 
+// For builds canceled because no one is waiting for the result anymore, see
+// config.CancelAbandonedBuilds. Always wrapped with errTempFailure.
+var errBuildCanceled = errors.New("build canceled")
+var errRebuildMismatch = errors.New("rebuild mismatch")
+var errBadExperiment = errors.New("goexperiment not allowed")
+var errBadBuildTags = errors.New("build tags not allowed")
+var errBadFIPS140 = errors.New("fips140 not allowed")
+
 func ensureGobin(goversion string) (string, error) {
 	gobin := filepath.Join(config.SDKDir, goversion, "bin", "go"+goexe())
 	if !filepath.IsAbs(gobin) {
@@ -31,7 +44,73 @@ func ensureGobin(goversion string) (string, error) {
 	return gobin, nil
 }
 
-func prepareBuild(bs buildSpec) error {
+// What a build is for, determining which checks prepareBuild does. Checks for
+// policies of this instance, which can change with the config, only apply to new
+// builds.
+type buildKind int
+
+const (
+	buildNew     buildKind = iota // New build, all checks apply.
+	buildVerify                   // Build for a record lookup or for another instance verifying its build.
+	buildRestore                  // Build restoring the binary of a record in the transparency log.
+)
+
+// kindOf returns the kind of build for a lookup result br, which is nil if no
+// record exists.
+func kindOf(br *buildResult) buildKind {
+	if br != nil {
+		return buildRestore
+	}
+	return buildNew
+}
+
+func prepareBuild(ctx context.Context, bs buildSpec, kind buildKind) error {
+	t0 := time.Now()
+	// Goversion comes from the request, only use it as label once we have its
+	// toolchain, to keep the number of series bounded.
+	var goversionValid bool
+	defer func() {
+		if goversionValid {
+			metricPrepareDuration.WithLabelValues(bs.Goos, bs.Goarch, bs.Goversion).Observe(time.Since(t0).Seconds())
+		}
+	}()
+
+	// Binaries of logged builds for targets no longer in the config can still be
+	// restored.
+	if kind != buildRestore && !targets.valid(bs.Goos+"/"+bs.Goarch) {
+		return fmt.Errorf("%w: target %s/%s, this instance does not build for it", errNotExist, bs.Goos, bs.Goarch)
+	}
+
+	if bs.Race && !config.AllowRace {
+		return fmt.Errorf("%w: race detector builds are not enabled on this instance", errNotExist)
+	} else if bs.Race && (bs.Goos != runtime.GOOS || bs.Goarch != runtime.GOARCH) {
+		return fmt.Errorf("%w: race detector builds are only possible for the host target %s/%s", errNotExist, runtime.GOOS, runtime.GOARCH)
+	}
+
+	if bs.Experiment != "" && !slices.Contains(config.GoExperiments, bs.Experiment) {
+		return fmt.Errorf("%w: GOEXPERIMENT %q, this instance only builds with %s", errBadExperiment, bs.Experiment, strings.Join(append([]string{"no experiments"}, config.GoExperiments...), ", "))
+	}
+
+	for _, tag := range bs.tagList() {
+		if !slices.Contains(config.BuildTags, tag) {
+			return fmt.Errorf("%w: build tag %q, this instance only builds with %s", errBadBuildTags, tag, strings.Join(append([]string{"no tags"}, config.BuildTags...), ", "))
+		}
+	}
+
+	if bs.FIPS140 != "" {
+		if !slices.Contains(config.GOFIPS140, bs.FIPS140) {
+			return fmt.Errorf("%w: GOFIPS140 %q, this instance only builds with %s", errBadFIPS140, bs.FIPS140, strings.Join(append([]string{"off"}, config.GOFIPS140...), ", "))
+		}
+		// GOFIPS140 is ignored by older toolchains, which would build a regular binary.
+		if gv, err := parseGoVersion(bs.Goversion); err == nil && gv.major == 1 && gv.minor < 24 {
+			return fmt.Errorf("%w: GOFIPS140 requires go1.24 or newer", errBadFIPS140)
+		}
+	}
+
+	if err := checkRecordSize(bs); err != nil {
+		return err
+	}
+
 	if _, err := ensureSDK(bs.Goversion); err != nil {
 		return fmt.Errorf("ensuring toolchain %q: %w", bs.Goversion, err)
 	}
@@ -40,46 +119,226 @@ func prepareBuild(bs buildSpec) error {
 	if err != nil {
 		return err
 	}
+	goversionValid = true
 
 	modDir, getOutput, err := ensureModule(bs.Goversion, gobin, bs.Mod, bs.Version)
-	if err != nil {
+	var terr toolchainError
+	if errors.As(err, &terr) {
+		return toolchainFailure(bs, terr)
+	} else if err != nil {
 		return fmt.Errorf("error fetching module from goproxy: %w\n\n# output from go get:\n%s", err, string(getOutput))
 	}
 
+	// The maximum size is a policy of this instance, binaries of logged builds can
+	// always be restored.
+	if kind != buildRestore {
+		if err := checkModuleSize(modDir); err != nil {
+			return err
+		}
+	}
+
+	if bs.Vendor {
+		// Vendor builds are of the main module, with dependencies from the vendor
+		// directory, which also has the copies of local replaces.
+		if _, err := os.Stat(filepath.Join(modDir, "vendor", "modules.txt")); err != nil && os.IsNotExist(err) {
+			return fmt.Errorf("%w: module %s has no vendor/modules.txt, vendor builds are only possible for modules that include their vendored dependencies", errNotExist, bs.Mod)
+		} else if err != nil {
+			return fmt.Errorf("%w: looking for vendor/modules.txt: %v", errServer, err)
+		}
+	} else if kind != buildRestore {
+		// A logged build was possible despite local replaces, e.g. by an earlier
+		// version of gobuild, and its binary can still be restored.
+		if err := checkLocalReplaces(modDir); err != nil {
+			return err
+		}
+	}
+
 	pkgDir := filepath.Join(modDir, filepath.FromSlash(bs.Dir[1:]))
 
+	// Directories of nested modules are not in the module zip file. Help users who
+	// request a package from the parent module.
+	if _, err := os.Stat(pkgDir); err != nil && os.IsNotExist(err) {
+		return nestedModuleError(ctx, bs)
+	}
+
+	// Directories without Go files, e.g. "cmd", are common when exploring a repository.
+	// Give a better error than go list would.
+	if ok, err := hasGoFiles(pkgDir); err != nil {
+		return fmt.Errorf("%w: looking for go files in package directory: %v", errServer, err)
+	} else if !ok {
+		metricNoGoFilesErrors.Inc()
+		return fmt.Errorf("package directory %s in module %s exists but has no Go files (%w as package); it may contain packages in subdirectories, see %s for the commands in the module", bs.Dir, bs.Mod, errNotExist, moduleLink(bs))
+	}
+
 	// Check if package is a main package, resulting in an executable when built.
 	goproxy := true
 	cgo := true
-	moreEnv := []string{
-		"GOOS=" + bs.Goos,
-		"GOARCH=" + bs.Goarch,
-	}
-	cmd := makeCommand(bs.Goversion, goproxy, pkgDir, cgo, moreEnv, gobin, "list", "-f", "{{.Name}}")
+	moreEnv := bs.env()
+	modFlag := "-mod=mod"
+	listArgs := []string{gobin, "list"}
+	if bs.Vendor {
+		modFlag = "-mod=vendor"
+		listArgs = append(listArgs, modFlag)
+	}
+	// Build tags select the files of packages, so must match the build.
+	cmd := makeCommand(bs.Goversion, goproxy, pkgDir, cgo, moreEnv, append(append(listArgs, bs.tagsFlags()...), "-f", "{{.Name}}")...)
 	stderr := &strings.Builder{}
 	cmd.Stderr = stderr
-	if nameOutput, err := cmd.Output(); err != nil {
+	release := acquireCommand()
+	nameOutput, err := cmd.Output()
+	release()
+	if terr, ok := parseToolchainError(stderr.String()); err != nil && ok {
+		return toolchainFailure(bs, terr)
+	} else if err != nil {
 		metricListPackageErrors.Inc()
 		return fmt.Errorf("error finding package name; perhaps package does not exist: %v\n\n# stdout from go list:\n%s\n\nstderr:\n%s", err, nameOutput, stderr.String())
 	} else if string(nameOutput) != "main\n" {
 		metricNotMainErrors.Inc()
-		return fmt.Errorf("package main %w, building would not result in executable binary (package %s)", errNotExist, strings.TrimRight(string(nameOutput), "\n"))
+		return fmt.Errorf("package main %w at %s: it is package %s, a library instead of a command, building would not result in executable binary; see %s for the commands in the module", errNotExist, bs.Dir, strings.TrimRight(string(nameOutput), "\n"), moduleLink(bs))
 	}
 
 	// Check that package does not depend on any cgo.
-	cmd = makeCommand(bs.Goversion, goproxy, pkgDir, cgo, moreEnv, gobin, "list", "-mod=mod", "-deps", "-f", `{{ if and (not .Standard) .CgoFiles }}{{ .ImportPath }}{{ end }}`)
+	cmd = makeCommand(bs.Goversion, goproxy, pkgDir, cgo, moreEnv, append(append([]string{gobin, "list", modFlag}, bs.tagsFlags()...), "-deps", "-f", `{{ if and (not .Standard) .CgoFiles }}{{ .ImportPath }}{{ end }}`)...)
 	stderr = &strings.Builder{}
 	cmd.Stderr = stderr
-	if cgoOutput, err := cmd.Output(); err != nil {
+	release = acquireCommand()
+	cgoOutput, err := cmd.Output()
+	release()
+	if err != nil {
 		metricCheckCgoErrors.Inc()
 		return fmt.Errorf("error determining whether cgo is required: %v\n\n# output from go list:\n%s\n\nstderr:\n%s", err, cgoOutput, stderr.String())
 	} else if len(cgoOutput) != 0 {
 		metricNeedsCgoErrors.Inc()
-		return fmt.Errorf("build %w due to cgo dependencies:\n\n%s", errNotExist, cgoOutput)
+		cerr := cgoError{strings.Fields(string(cgoOutput))}
+		// Store as failed build, so the packages needing cgo end up in the build log.
+		// Not when restoring, a record exists and the result directory can't hold a
+		// failure.
+		if kind != buildRestore {
+			registerFailure(bs, cerr, string(cgoOutput))
+		}
+		return cerr
+	}
+
+	// Optionally refuse to build packages that don't pass go vet. Vet only reads the
+	// package, so it does not influence the build. Same environment as the build,
+	// without cgo. Whether a package is vetted depends on the config, so the
+	// result is not stored as failed build, and the check is only for new builds.
+	if config.RunVet && kind == buildNew {
+		cmd = makeCommand(bs.Goversion, goproxy, pkgDir, false, moreEnv, append(append([]string{gobin, "vet", modFlag}, bs.tagsFlags()...), ".")...)
+		release = acquireCommand()
+		vetOutput, err := cmd.CombinedOutput()
+		release()
+		var eerr *exec.ExitError
+		if err != nil && errors.As(err, &eerr) {
+			metricVetErrors.Inc()
+			return vetError{string(vetOutput)}
+		} else if err != nil {
+			return fmt.Errorf("%w: running go vet: %v (%w)", errServer, err, errTempFailure)
+		}
 	}
 	return nil
 }
 
+// vetError is returned by prepareBuild when go vet reports problems for a
+// package and config.RunVet is set.
+type vetError struct {
+	Output string
+}
+
+func (e vetError) Error() string {
+	return fmt.Sprintf("go vet reported problems, this instance only builds packages that pass go vet:\n\n%s", e.Output)
+}
+
+// cgoError is returned by prepareBuild when a package needs cgo, which gobuild
+// does not build.
+type cgoError struct {
+	Packages []string // Import paths of packages with cgo files.
+}
+
+func (e cgoError) Error() string {
+	return fmt.Sprintf("build %s due to cgo dependencies:\n\n%s\n", errNotExist, strings.Join(e.Packages, "\n"))
+}
+
+func (e cgoError) Unwrap() error {
+	return errNotExist
+}
+
+// Maximum number of leading path components of a package directory to look for
+// nested modules in. Each is a lookup at the goproxy.
+const maxNestedModuleDepth = 4
+
+// nestedModuleError returns an error for a package directory that does not exist
+// in the module. It looks for a nested module containing the directory, deepest
+// first, and suggests requesting that module instead.
+func nestedModuleError(ctx context.Context, bs buildSpec) error {
+	t := strings.Split(bs.Dir[1:], "/")
+	for i := min(len(t), maxNestedModuleDepth); i > 0 && ctx.Err() == nil; i-- {
+		nestedMod := bs.Mod + "/" + strings.Join(t[:i], "/")
+		info, err := resolveModuleVersion(ctx, nestedMod, "latest")
+		if err != nil {
+			continue
+		}
+		metricNestedModuleErrors.Inc()
+		nbs := bs
+		nbs.Mod = nestedMod
+		nbs.Version = info.Version
+		nbs.Dir = "/" + strings.Join(t[i:], "/")
+		return fmt.Errorf("package directory %s %w in module %s: it is part of nested module %s, which has its own go.mod; request a build of that module instead, e.g. %s", bs.Dir, errNotExist, bs.Mod, nestedMod, request{nbs, "", pageIndex}.link())
+	}
+	metricPackageDirMissingErrors.Inc()
+	return fmt.Errorf("package directory %s %w in module %s; check the path, or see %s for the commands in the module", bs.Dir, errNotExist, bs.Mod, moduleLink(bs))
+}
+
+// toolchainFailure returns terr with a suggestion for a build of bs with the
+// oldest supported toolchain that satisfies the module.
+func toolchainFailure(bs buildSpec, terr toolchainError) error {
+	metricToolchainTooOldErrors.Inc()
+
+	required, err := parseGoVersion("go" + terr.Required)
+	if err != nil {
+		return terr
+	}
+	_, supported, _ := listSDK()
+	var suggest *goVersion
+	for _, s := range supported {
+		v, err := parseGoVersion(s)
+		if err != nil || v.more != "" || v.num() < required.num() || !goversionAllowed(s) {
+			continue
+		}
+		if suggest == nil || v.num() < suggest.num() {
+			suggest = &v
+		}
+	}
+	if suggest != nil {
+		nbs := bs
+		nbs.Goversion = suggest.String()
+		terr.Suggest = request{nbs, "", pageIndex}.link()
+	}
+	return terr
+}
+
+// moduleLink returns the path to the page listing the commands in the latest
+// version of the module of bs.
+func moduleLink(bs buildSpec) string {
+	return "/" + bs.Mod
+}
+
+// hasGoFiles returns whether dir contains files that the go command could
+// consider for a package, ignoring tests and build constraints.
+func hasGoFiles(dir string) (bool, error) {
+	l, err := os.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+	for _, e := range l {
+		name := e.Name()
+		if !e.IsDir() && strings.HasSuffix(name, ".go") && !strings.HasSuffix(name, "_test.go") && !strings.HasPrefix(name, ".") && !strings.HasPrefix(name, "_") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 // Build does the actual build. It is called from coordinate, ensuring the same
 // buildSpec isn't built multiple times concurrently, and preventing a few other
 // clashes.
@@ -87,7 +346,10 @@ func prepareBuild(bs buildSpec) error {
 // If expSumOpt is non-empty, a build was done in the past but the binary removed.
 // This build will restore the binary. If expSumOpt is empty and the build is
 // successful, a record is added to the transparency log.
-func build(bs buildSpec, expSumOpt string) (int64, *buildResult, string, error) {
+//
+// If ctx is canceled, the compile is stopped and an error wrapping
+// errBuildCanceled is returned. No failure is stored in that case.
+func build(ctx context.Context, bs buildSpec, expSumOpt string) (int64, *buildResult, string, error) {
 	targets.increase(bs.Goos + "/" + bs.Goarch)
 
 	gobin, err := ensureGobin(bs.Goversion)
@@ -110,7 +372,15 @@ func build(bs buildSpec, expSumOpt string) (int64, *buildResult, string, error)
 		result    *buildResult
 	}
 
-	verifyResult := make(chan remoteBuild, len(config.VerifierURLs))
+	// The verifier URLs can change with a config reload, we use the same list for the
+	// entire build.
+	verifierURLs := configVerifierURLs()
+	if bs.Race {
+		// Race builds are only for the host target, and are for debugging, we don't ask
+		// other instances to reproduce them.
+		verifierURLs = nil
+	}
+	verifyResult := make(chan remoteBuild, len(verifierURLs))
 	verifyLink := request{bs, "", pageRecord}.link()
 
 	verify := func(verifierBaseURL string) (*buildResult, error) {
@@ -144,7 +414,7 @@ func build(bs buildSpec, expSumOpt string) (int64, *buildResult, string, error)
 		}
 	}
 
-	for _, verifierBaseURL := range config.VerifierURLs {
+	for _, verifierBaseURL := range verifierURLs {
 		go func(verifierBaseURL string) {
 			result, err := verify(verifierBaseURL)
 			if err != nil {
@@ -185,9 +455,23 @@ func build(bs buildSpec, expSumOpt string) (int64, *buildResult, string, error)
 		resultPath = filepath.Join(bs.Goos+"_"+bs.Goarch, resultPath)
 	}
 
-	moreEnv := []string{
-		"GOOS=" + bs.Goos,
-		"GOARCH=" + bs.Goarch,
+	moreEnv := bs.env()
+	// Restoring a binary must use the environment of the original build, which
+	// predates config.SourceDateEpoch for older results.
+	var sourceDateEpoch string
+	if expSumOpt != "" {
+		sourceDateEpoch, err = readSourceDateEpoch(bs.storeKey())
+		if err != nil {
+			return -1, nil, "", fmt.Errorf("reading source date epoch of previous build: %v", err)
+		}
+	} else if config.SourceDateEpoch {
+		sourceDateEpoch, err = sourceDateEpochEnv(ctx, bs.Mod, bs.Version)
+		if err != nil {
+			return -1, nil, "", fmt.Errorf("%v (%w)", err, errTempFailure)
+		}
+	}
+	if sourceDateEpoch != "" {
+		moreEnv = append(moreEnv, sourceDateEpoch)
 	}
 
 	var gobuildbindir string
@@ -226,36 +510,76 @@ func build(bs buildSpec, expSumOpt string) (int64, *buildResult, string, error)
 	// builds would affect the resulting binary.
 
 	goproxy := false
-	cgo := false
+	cgo := bs.cgoEnabled()
+	if cgo && !slices.ContainsFunc(config.Environment, func(s string) bool { return strings.HasPrefix(s, "PATH=") }) {
+		// The go command needs to find the C compiler, our environment for commands
+		// normally has no PATH.
+		moreEnv = append(moreEnv, "PATH="+os.Getenv("PATH"))
+	}
 	var cmd *exec.Cmd
 	gv, err := parseGoVersion(bs.Goversion)
 	if err != nil {
 		return -1, nil, "", fmt.Errorf("%w: %s", errBadGoversion, err)
 	}
-	ldflags := "-buildid="
-	if bs.Stripped {
-		ldflags += " -s"
-	}
-	// Since Go1.18 we need to use "go install" to compile external programs.
-	if gv.major == 1 && gv.minor >= 18 {
+	buildFlags := append([]string{"-x", "-v"}, bs.buildFlags()...)
+	if bs.Vendor {
+		// Vendor builds are of the package in the module cache as main module, with
+		// dependencies from its vendor directory. We need "go build" for its "-o" flag.
+		// The binary does not have the module version in its build info.
+		modDir, err := moduleDir(bs.Mod, bs.Version)
+		if err != nil {
+			return -1, nil, "", err
+		}
+		if err := os.MkdirAll(filepath.Dir(resultPath), 0777); err != nil {
+			return -1, nil, "", fmt.Errorf("%w: creating directory for binary: %v", errServer, err)
+		}
+		pkgDir := filepath.Join(modDir, filepath.FromSlash(bs.Dir[1:]))
+		cmd = makeCommandContext(ctx, bs.Goversion, goproxy, pkgDir, cgo, moreEnv, append(append([]string{gobin, "build"}, buildFlags...), "-o", resultPath, ".")...)
+	} else if gv.major == 1 && gv.minor >= 18 {
+		// Since Go1.18 we need to use "go install" to compile external programs.
 		// Go1.23 started checking for deprecations during "go install", requiring GOPROXY
 		// access. https://golang.org/cl/528775
 		if gv.major == 1 && gv.minor >= 23 {
 			goproxy = true
 		}
-		cmd = makeCommand(bs.Goversion, goproxy, emptyDir, cgo, moreEnv, gobin, "install", "-x", "-v", "-trimpath", "-ldflags="+ldflags, "--", name)
+		cmd = makeCommandContext(ctx, bs.Goversion, goproxy, emptyDir, cgo, moreEnv, append(append([]string{gobin, "install"}, buildFlags...), "--", name)...)
 	} else {
-		cmd = makeCommand(bs.Goversion, goproxy, emptyDir, cgo, moreEnv, gobin, "get", "-x", "-v", "-trimpath", "-ldflags="+ldflags, "--", name)
+		cmd = makeCommandContext(ctx, bs.Goversion, goproxy, emptyDir, cgo, moreEnv, append(append([]string{gobin, "get"}, buildFlags...), "--", name)...)
+	}
+	// Processes started by a wrapper in config.Run may keep the output open after the
+	// command is killed, don't wait for them indefinitely.
+	cmd.WaitDelay = 10 * time.Second
+	if err := ctx.Err(); err != nil {
+		return -1, nil, "", fmt.Errorf("%w: no clients waiting for result (%w)", errBuildCanceled, errTempFailure)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err != nil && ctx.Err() != nil {
+		return -1, nil, "", fmt.Errorf("%w: no clients waiting for result (%w)", errBuildCanceled, errTempFailure)
+	}
+	output := buildLog(stdout.Bytes(), stderr.Bytes())
+	compileDuration := time.Since(t0)
+	metricCompileDuration.WithLabelValues(bs.Goos, bs.Goarch, bs.Goversion).Observe(compileDuration.Seconds())
+	if err == nil {
+		addCompileDuration(bs, compileDuration)
 	}
-	output, err := cmd.CombinedOutput()
-	metricCompileDuration.WithLabelValues(bs.Goos, bs.Goarch, bs.Goversion).Observe(time.Since(t0).Seconds())
 	if err != nil {
 		metricCompileErrors.WithLabelValues(bs.Goos, bs.Goarch, bs.Goversion).Inc()
-		out := string(output)
-		if xerr := saveFailure(bs, err, out); xerr != nil {
+		if xerr := saveFailure(bs, err, string(output)); xerr != nil {
 			return -1, nil, "", fmt.Errorf("storing results of failure: %v (%w)", xerr, errTempFailure)
 		}
-		return -1, nil, out, err
+		return -1, nil, logStderr(string(output)), err
+	}
+
+	// Wrappers may post-process the binary and write it under another name.
+	if config.BuildGobin {
+		if p, err := findGobinBinary(gobuildbindir); err != nil {
+			return -1, nil, "", fmt.Errorf("finding binary written by build command: %v (%w)", err, errTempFailure)
+		} else {
+			resultPath = p
+		}
 	}
 
 	// Where we store the "recordnumber" file, binary.gz and log.gz.
@@ -299,32 +623,44 @@ func build(bs buildSpec, expSumOpt string) (int64, *buildResult, string, error)
 	if expSumOpt != "" {
 		if br.Sum != expSumOpt {
 			metricRecompileMismatch.WithLabelValues(bs.Goos, bs.Goarch, bs.Goversion).Inc()
-			return -1, nil, "", fmt.Errorf("sum of rebuilt binary %s does not match previous sum %s", br.Sum, expSumOpt)
+			qdir, err := quarantineMismatch(bs, expSumOpt, br.Sum, rf, output)
+			if err != nil {
+				slog.Error("quarantining binary of rebuild with different sum", "err", err, "buildspec", bs.String())
+			}
+			slog.Error("rebuild resulted in different sum, build is not reproducible", "buildspec", bs.String(), "mod", bs.Mod, "version", bs.Version, "dir", bs.Dir, "goos", bs.Goos, "goarch", bs.Goarch, "goversion", bs.Goversion, "stripped", bs.Stripped, "netgo", bs.Netgo, "vendor", bs.Vendor, "tags", bs.Tags, "fips140", bs.FIPS140, "experiment", bs.Experiment, "expsum", expSumOpt, "sum", br.Sum, "quarantine", qdir)
+			return -1, nil, "", fmt.Errorf("%w: sum of rebuilt binary %s does not match previous sum %s", errRebuildMismatch, br.Sum, expSumOpt)
 		}
-		storeDir := br.storeDir()
+		key := br.storeKey()
 		ptmp := filepath.Join(tmpdir, "binary.gz")
-		pdst := filepath.Join(storeDir, "binary.gz")
 		if err := writeGz(ptmp, rf); err != nil {
 			return -1, nil, "", err
 		}
-		if recBuf, err := os.ReadFile(filepath.Join(storeDir, "recordnumber")); err != nil {
+		if recBuf, err := readResultFile(key, "recordnumber"); err != nil {
 			return -1, nil, "", fmt.Errorf("reading previous recordnumber file: %w", err)
 		} else if v, err := strconv.ParseInt(strings.TrimSpace(string(recBuf)), 10, 64); err != nil {
 			return -1, nil, "", fmt.Errorf("parsing previous recordnumber %q: %v", recBuf, err)
-		} else if err := os.Rename(ptmp, pdst); err != nil {
+		} else if err := results.PutFile(key, "binary.gz", ptmp); err != nil {
 			return -1, nil, "", fmt.Errorf("moving binary.gz to destination: %w", err)
 		} else {
 			return v, &br, "", nil
 		}
 	}
 
-	// Verify the sums of the verifiers.
+	if config.RecordSHA256 {
+		br.SHA256 = fmt.Sprintf("%x", h.Sum(nil))
+	}
+
+	// Verify the sums of the verifiers. Errors from verifiers are tolerated if enough
+	// others agree, see config.VerifierQuorum. Mismatches are not.
 	matchesFrom := []string{}
 	mismatches := []string{}
-	for n := len(config.VerifierURLs); n > 0; n-- {
+	var verifyErrs []string
+	for n := len(verifierURLs); n > 0; n-- {
 		vr := <-verifyResult
 		if vr.err != nil {
-			return -1, nil, "", fmt.Errorf("build at verifier failed: %v (%w)", vr.err, errTempFailure)
+			slog.Info("build at verifier failed", "err", vr.err, "verifierurl", vr.verifyURL, "buildspec", bs)
+			verifyErrs = append(verifyErrs, vr.err.Error())
+			continue
 		}
 		if vr.result.Sum == br.Sum {
 			matchesFrom = append(matchesFrom, vr.verifyURL)
@@ -337,6 +673,13 @@ func build(bs buildSpec, expSumOpt string) (int64, *buildResult, string, error)
 	if len(mismatches) > 0 {
 		return -1, nil, "", fmt.Errorf("build mismatches, we and %d others got %s, but %s (%w)", len(matchesFrom), br.Sum, strings.Join(mismatches, ", "), errTempFailure)
 	}
+	quorum := len(verifierURLs)
+	if config.VerifierQuorum > 0 && config.VerifierQuorum < quorum {
+		quorum = config.VerifierQuorum
+	}
+	if len(matchesFrom) < quorum {
+		return -1, nil, "", fmt.Errorf("build at verifier failed, %d of %d verifiers confirmed, %d required: %s (%w)", len(matchesFrom), len(verifierURLs), quorum, strings.Join(verifyErrs, "; "), errTempFailure)
+	}
 
 	// Write binary and log.
 	if err := writeGz(filepath.Join(tmpdir, "binary.gz"), rf); err != nil {
@@ -345,6 +688,28 @@ func build(bs buildSpec, expSumOpt string) (int64, *buildResult, string, error)
 	if err := writeGz(filepath.Join(tmpdir, "log.gz"), bytes.NewReader(output)); err != nil {
 		return -1, nil, "", err
 	}
+	if binarySigner != nil {
+		sig, err := signBinary(br, h.Sum(nil))
+		if err != nil {
+			return -1, nil, "", fmt.Errorf("%w: signing binary: %v", errServer, err)
+		}
+		if err := os.WriteFile(filepath.Join(tmpdir, "binary.sig"), sig, 0666); err != nil {
+			return -1, nil, "", err
+		}
+	}
+	if err := os.WriteFile(filepath.Join(tmpdir, "duration"), []byte(compileDuration.Round(time.Millisecond).String()+"\n"), 0666); err != nil {
+		return -1, nil, "", err
+	}
+	if sourceDateEpoch != "" {
+		if err := os.WriteFile(filepath.Join(tmpdir, "sourcedateepoch"), []byte(sourceDateEpoch+"\n"), 0666); err != nil {
+			return -1, nil, "", err
+		}
+	}
+	if len(matchesFrom) > 0 {
+		if err := os.WriteFile(filepath.Join(tmpdir, "verifiers"), []byte(strings.Join(matchesFrom, "\n")+"\n"), 0666); err != nil {
+			return -1, nil, "", err
+		}
+	}
 
 	// Finally, add to the transparency log, creating the "recordnumber" file and
 	// renaming tmpdir to the final directory in resultDir.
@@ -354,18 +719,85 @@ func build(bs buildSpec, expSumOpt string) (int64, *buildResult, string, error)
 	}
 	tmpdir = ""
 
-	recentBuilds.Lock()
-	recentBuilds.links = append(recentBuilds.links, request{bs, br.Sum, pageIndex}.link())
-	if len(recentBuilds.links) > 10 {
-		recentBuilds.links = recentBuilds.links[len(recentBuilds.links)-10:]
-	}
-	recentBuilds.Unlock()
+	addRecentBuild(request{bs, br.Sum, pageIndex}.link())
+	addModuleIndex(bs.Mod)
 
 	return recordNumber, &br, "", nil
 }
 
+// signBinary returns a signed note for the binary of br with sha256 hash sha.
+// Signatures are ed25519, so deterministic: the same build with the same key
+// always yields the same signature.
+func signBinary(br buildResult, sha []byte) ([]byte, error) {
+	text := fmt.Sprintf("gobuild binary signature\nsha256 %x\n%s\n%s\n", sha, request{br.buildSpec, br.Sum, pageDownload}.downloadFilename(), br.buildSpec.String())
+	return note.Sign(&note.Note{Text: text}, binarySigner)
+}
+
+// Markers for the output streams of the go command in build logs of builds. Logs
+// of older builds, and of failures before the build, have no markers.
+const (
+	logMarkerStdout = "# stdout of go command\n"
+	logMarkerStderr = "# stderr of go command\n"
+)
+
+// buildLog returns the build log to store for a build: stdout and stderr of the
+// go command, each after a marker. With -x and -v, the go command writes its
+// progress to stderr too, so errors are at the end of the log.
+func buildLog(stdout, stderr []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString(logMarkerStdout)
+	b.Write(stdout)
+	if len(stdout) > 0 && stdout[len(stdout)-1] != '\n' {
+		b.WriteByte('\n')
+	}
+	b.WriteString(logMarkerStderr)
+	b.Write(stderr)
+	return b.Bytes()
+}
+
+// logStderr returns the build log without the stdout of the go command, for
+// classifying failures and showing errors. Logs without markers are returned
+// as is.
+func logStderr(log string) string {
+	i := strings.Index(log, logMarkerStdout)
+	j := strings.Index(log, logMarkerStderr)
+	if i < 0 || j < i {
+		return log
+	}
+	return log[:i] + log[j+len(logMarkerStderr):]
+}
+
+// quarantineMismatch stores the binary and build log of a rebuild that resulted
+// in a different sum than expSum, for investigating the cause. Returns the
+// directory with the files.
+func quarantineMismatch(bs buildSpec, expSum, sum string, binary io.ReadSeeker, output []byte) (string, error) {
+	qdir := filepath.Join(config.DataDir, "quarantine")
+	if err := os.MkdirAll(qdir, 0777); err != nil {
+		return "", err
+	}
+	dir, err := os.MkdirTemp(qdir, time.Now().UTC().Format("20060102T150405Z")+"-"+sum+"-")
+	if err != nil {
+		return "", err
+	}
+	info := fmt.Sprintf("buildspec %s\nexpected sum %s\nrebuilt sum %s\ngobuild %s %s\n", bs.String(), expSum, sum, gobuildVersion, gobuildPlatform)
+	if err := os.WriteFile(filepath.Join(dir, "mismatch.txt"), []byte(info), 0666); err != nil {
+		return dir, err
+	}
+	if err := writeGz(filepath.Join(dir, "log.gz"), bytes.NewReader(output)); err != nil {
+		return dir, err
+	}
+	if _, err := binary.Seek(0, 0); err != nil {
+		return dir, err
+	}
+	return dir, writeGz(filepath.Join(dir, "binary.gz"), binary)
+}
+
+// saveFailure stores the failed build in its store dir, and appends a line to
+// buildfailures.txt for triage by operators. Lines have tab-separated fields:
+// buildspec, time in RFC3339 and failure category, see classifyFailure.
 func saveFailure(bs buildSpec, buildErr error, output string) error {
-	slog.Error("build failure", "err", buildErr, "buildspec", bs, "output", output)
+	category := classifyFailure(buildErr, logStderr(output)).Category
+	slog.Error("build failure", "err", buildErr, "buildspec", bs, "category", category, "output", output)
 
 	tmpdir, err := os.MkdirTemp(resultDir, "tmpfail")
 	if err != nil {
@@ -377,11 +809,11 @@ func saveFailure(bs buildSpec, buildErr error, output string) error {
 		}
 	}()
 
-	output = err.Error() + "\n\n" + output
+	output = buildErr.Error() + "\n\n" + output
 	if err := writeGz(filepath.Join(tmpdir, "log.gz"), strings.NewReader(output)); err != nil {
 		return err
 	}
-	if err := os.WriteFile(filepath.Join(tmpdir, "builderror.txt"), []byte(fmt.Sprintf("%s\n%v\n", bs, err)), 0666); err != nil {
+	if err := os.WriteFile(filepath.Join(tmpdir, "builderror.txt"), []byte(fmt.Sprintf("%s\n%v\n", bs, buildErr)), 0666); err != nil {
 		return err
 	}
 
@@ -389,13 +821,13 @@ func saveFailure(bs buildSpec, buildErr error, output string) error {
 	if f, err := os.OpenFile(fp, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666); err != nil {
 		slog.Error("open buildfailures.txt", "err", err)
 	} else {
-		_, err := fmt.Fprintln(f, bs.String())
+		_, err := fmt.Fprintf(f, "%s\t%s\t%s\n", bs.String(), time.Now().UTC().Format(time.RFC3339), category)
 		logCheck(err, "writing buildspec to buildfailures.txt")
 		err = f.Close()
 		logCheck(err, "close buildfailures.txt")
 	}
 
-	if err := os.Rename(tmpdir, bs.storeDir()); err != nil {
+	if err := results.Put(bs.storeKey(), tmpdir); err != nil {
 		return err
 	}
 	tmpdir = ""
@@ -423,3 +855,50 @@ func writeGz(path string, src io.Reader) error {
 	lf = nil
 	return err
 }
+
+// findGobinBinary returns the path of the binary written to dir, the
+// GOBUILD_GOBIN directory, by a build command. If the build command wrote a file
+// "gobuild-binary-path" to dir, it contains the path of the binary, relative to
+// dir. Otherwise dir (including subdirectories) must contain a single regular
+// file, which is the binary.
+func findGobinBinary(dir string) (string, error) {
+	if buf, err := os.ReadFile(filepath.Join(dir, "gobuild-binary-path")); err == nil {
+		name := filepath.Clean(strings.TrimSpace(string(buf)))
+		if filepath.IsAbs(name) || name == "." || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return "", fmt.Errorf("path %q in gobuild-binary-path must be relative to GOBUILD_GOBIN", name)
+		}
+		p := filepath.Join(dir, name)
+		if fi, err := os.Stat(p); err != nil {
+			return "", fmt.Errorf("binary from gobuild-binary-path: %v", err)
+		} else if !fi.Mode().IsRegular() {
+			return "", fmt.Errorf("binary %q from gobuild-binary-path is not a regular file", name)
+		}
+		return p, nil
+	} else if !os.IsNotExist(err) {
+		return "", fmt.Errorf("reading gobuild-binary-path: %v", err)
+	}
+
+	var files []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			files = append(files, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("listing files in GOBUILD_GOBIN: %v", err)
+	}
+	switch len(files) {
+	case 0:
+		return "", fmt.Errorf("no file found in GOBUILD_GOBIN")
+	case 1:
+		return files[0], nil
+	}
+	for i, p := range files {
+		files[i], _ = filepath.Rel(dir, p)
+	}
+	return "", fmt.Errorf("multiple files found in GOBUILD_GOBIN, write the path of the binary to gobuild-binary-path: %s", strings.Join(files, ", "))
+}
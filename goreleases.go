@@ -0,0 +1,321 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mjl-/goreleases"
+	"golang.org/x/crypto/openpgp"
+)
+
+// URLs for listing Go toolchain releases, the same as used by the goreleases
+// package.
+const (
+	goreleasesSupportedURL = "https://go.dev/dl/?mode=json"
+	goreleasesAllURL       = "https://go.dev/dl/?mode=json&include=all"
+)
+
+// listGoReleases is like goreleases.ListSupported, or goreleases.ListAll if all
+// is set, but requests with goreleasesClient and stops when ctx is done. The
+// goreleases package only uses http.Get.
+func listGoReleases(ctx context.Context, all bool) ([]goreleases.Release, error) {
+	u := goreleasesSupportedURL
+	if all {
+		u = goreleasesAllURL
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("new request: %v", err)
+	}
+	resp, err := goreleasesClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching releases: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching releases returned http status %d: %s", resp.StatusCode, resp.Status)
+	}
+	var rels []goreleases.Release
+	if err := json.NewDecoder(resp.Body).Decode(&rels); err != nil {
+		return nil, fmt.Errorf("parsing releases JSON: %v", err)
+	}
+	return rels, nil
+}
+
+// Keys trusted for signatures on Go toolchain downloads: the embedded Google
+// release signing key, and keys from config.SDKSigningKeysFile. A signature is
+// verified with the key that made it, so toolchains signed with a rotated key
+// can be fetched before gobuild is updated.
+var releaseSigningKeys struct {
+	sync.Mutex
+	list openpgp.EntityList
+}
+
+func init() {
+	kr, err := openpgp.ReadArmoredKeyRing(strings.NewReader(goreleasesArmoredSigningKey))
+	if err != nil {
+		panic(fmt.Sprintf("parsing signing key for verifying go releases: %v", err))
+	}
+	releaseSigningKeys.list = kr
+}
+
+// readReleaseSigningKeys reads an armored keyring with keys to trust for
+// toolchain signatures, as configured in SDKSigningKeysFile.
+func readReleaseSigningKeys(path string) (openpgp.EntityList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	kr, err := openpgp.ReadArmoredKeyRing(f)
+	if err != nil {
+		return nil, fmt.Errorf("parsing keyring: %v", err)
+	} else if len(kr) == 0 {
+		return nil, fmt.Errorf("no keys in keyring")
+	}
+	return kr, nil
+}
+
+// addReleaseSigningKeys adds keys to the keys trusted for toolchain signatures,
+// returning their fingerprints.
+func addReleaseSigningKeys(kr openpgp.EntityList) []string {
+	var fingerprints []string
+	for _, e := range kr {
+		fingerprints = append(fingerprints, keyFingerprint(e))
+	}
+	releaseSigningKeys.Lock()
+	defer releaseSigningKeys.Unlock()
+	releaseSigningKeys.list = append(releaseSigningKeys.list, kr...)
+	return fingerprints
+}
+
+func trustedReleaseKeys() openpgp.EntityList {
+	releaseSigningKeys.Lock()
+	defer releaseSigningKeys.Unlock()
+	return append(openpgp.EntityList{}, releaseSigningKeys.list...)
+}
+
+func keyFingerprint(e *openpgp.Entity) string {
+	return fmt.Sprintf("%X", e.PrimaryKey.Fingerprint)
+}
+
+// fetchGoRelease is like goreleases.Fetch, but requests with goreleasesClient,
+// stops when ctx is done, and verifies the signature with the keys from
+// releaseSigningKeys. Signer is the fingerprint of the key that made the
+// signature. On success, dst contains directory "go" with the release. On
+// errors, dst may contain a partially extracted release.
+func fetchGoRelease(ctx context.Context, file goreleases.File, dst string) (signer string, rerr error) {
+	get := func(u string) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+		if err != nil {
+			return nil, fmt.Errorf("new request: %v", err)
+		}
+		resp, err := goreleasesClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("status %v, expected 200 OK", resp.Status)
+		}
+		return resp, nil
+	}
+
+	resp, err := get("https://go.dev/dl/" + file.Filename + ".asc")
+	if err != nil {
+		return "", fmt.Errorf("fetching .asc signature file: %v", err)
+	}
+	sigbuf, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+	if err != nil {
+		return "", fmt.Errorf("reading .asc signature file: %v", err)
+	}
+
+	// Temporary file to write release tgz/zip into.
+	f, err := os.CreateTemp("", "gobuild-goreleases")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		// Only removed when we're done, Windows doesn't remove files that are in use.
+		name := f.Name()
+		f.Close()
+		os.Remove(name)
+	}()
+
+	resp, err = get("https://go.dev/dl/" + file.Filename)
+	if err != nil {
+		return "", fmt.Errorf("fetching release file: %v", err)
+	}
+	defer resp.Body.Close()
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, h), resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("copying release file: %v", err)
+	}
+	if sum := fmt.Sprintf("%x", h.Sum(nil)); sum != file.Sha256 {
+		return "", fmt.Errorf("checksum mismatch, got %s, expected %s", sum, file.Sha256)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return "", fmt.Errorf("rewinding downloaded release file: %v", err)
+	}
+	entity, err := openpgp.CheckArmoredDetachedSignature(trustedReleaseKeys(), f, bytes.NewReader(sigbuf))
+	if err != nil {
+		return "", fmt.Errorf("verifying pgp signature on go release: %v", err)
+	}
+	signer = keyFingerprint(entity)
+
+	if _, err := os.Stat(filepath.Join(dst, "go")); err == nil {
+		return "", fmt.Errorf(`directory "go" already exists in %s`, dst)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	dst = filepath.Clean(dst)
+	if strings.HasSuffix(file.Filename, ".tar.gz") {
+		if _, err := f.Seek(0, 0); err != nil {
+			return "", fmt.Errorf("rewinding downloaded release file: %v", err)
+		}
+		err = extractTgz(f, dst)
+	} else if strings.HasSuffix(file.Filename, ".zip") {
+		err = extractZip(f, size, dst)
+	} else {
+		err = fmt.Errorf("file extension not supported, only .tar.gz and .zip supported")
+	}
+	if err != nil {
+		return "", err
+	}
+	return signer, nil
+}
+
+// releasePath returns the path in dst for name from a release archive, which
+// must be in directory "go".
+func releasePath(dst, name string) (string, error) {
+	if name != "go" && !strings.HasPrefix(name, "go/") {
+		return "", fmt.Errorf("path %q: does not start with \"go\"", name)
+	}
+	p := filepath.Join(dst, filepath.FromSlash(name))
+	if !strings.HasPrefix(p, dst+string(filepath.Separator)) {
+		return "", fmt.Errorf("bad path %q in archive, resulting in path %q outside dst %q", name, p, dst)
+	}
+	return p, nil
+}
+
+func extractTgz(r io.Reader, dst string) error {
+	gzr, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("gzip reader: %v", err)
+	}
+	defer gzr.Close()
+	tr := tar.NewReader(gzr)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("reading next header from tar file: %v", err)
+		}
+		name, err := releasePath(dst, h.Name)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+			return err
+		}
+		switch h.Typeflag {
+		case tar.TypeReg:
+			if err := writeReleaseFile(name, io.LimitReader(tr, h.Size), h.Size, os.FileMode(h.Mode)&0777, h.ModTime); err != nil {
+				return err
+			}
+		case tar.TypeLink, tar.TypeSymlink:
+			linkname, err := releasePath(dst, h.Linkname)
+			if err != nil {
+				return err
+			}
+			if h.Typeflag == tar.TypeLink {
+				err = os.Link(linkname, name)
+			} else {
+				err = os.Symlink(linkname, name)
+			}
+			if err != nil {
+				return err
+			}
+		case tar.TypeDir:
+			if err := os.MkdirAll(name, 0777); err != nil {
+				return fmt.Errorf("mkdir: %v", err)
+			}
+		case tar.TypeXGlobalHeader, tar.TypeGNUSparse:
+		default:
+			return fmt.Errorf("unsupported tar header typeflag %v", h.Typeflag)
+		}
+	}
+}
+
+func extractZip(r io.ReaderAt, size int64, dst string) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return fmt.Errorf("reading zip file: %v", err)
+	}
+	for _, zf := range zr.File {
+		name, err := releasePath(dst, strings.TrimSuffix(zf.Name, "/"))
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(zf.Name, "/") {
+			if err := os.MkdirAll(name, 0777); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+			return err
+		}
+		sf, err := zf.Open()
+		if err != nil {
+			return fmt.Errorf("opening file in zip: %v", err)
+		}
+		err = writeReleaseFile(name, sf, int64(zf.UncompressedSize64), zf.Mode()&0777, zf.Modified)
+		sf.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeReleaseFile writes a file of size bytes from r to name.
+func writeReleaseFile(name string, r io.Reader, size int64, mode os.FileMode, mtime time.Time) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, r)
+	if err == nil && n != size {
+		err = fmt.Errorf("extracted %d bytes, expected %d", n, size)
+	}
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("extracting %s: %v", name, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close: %v", err)
+	}
+	if err := os.Chtimes(name, mtime, mtime); err != nil {
+		return fmt.Errorf("chtimes: %v", err)
+	}
+	return nil
+}
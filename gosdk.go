@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"log/slog"
+	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -41,6 +43,16 @@ func (v goVersion) num() int {
 // If not nil, we don't allow using this or newer Go toolchains.
 var sdkVersionStop *goVersion
 
+// If not nil, we don't allow using Go toolchains older than this.
+var sdkVersionMin *goVersion
+
+// goversionAllowed returns whether goversion is valid and within the bounds of
+// config.SDKVersionMin and config.SDKVersionStop.
+func goversionAllowed(goversion string) bool {
+	gv, err := parseGoVersion(goversion)
+	return err == nil && (sdkVersionMin == nil || gv.num() >= sdkVersionMin.num()) && (sdkVersionStop == nil || gv.num() < sdkVersionStop.num())
+}
+
 type target struct {
 	Goos   string
 	Goarch string
@@ -51,7 +63,8 @@ func (t target) osarch() string {
 }
 
 // List of targets from "go tool dist list", bound to be out of date here; should probably generate on startup, or when we get the first sdk installed.
-// Android and darwin/arm* cannot build on my linux/amd64 machine.
+// Android and darwin/arm cannot build on my linux/amd64 machine. Darwin/arm64 can
+// be cross-compiled since go1.16.
 // Note: list will be sorted after startup by readRecentBuilds, most used first.
 type xtargets struct {
 	sync.Mutex
@@ -76,7 +89,7 @@ var targets = &xtargets{
 		{"darwin", "386"},
 		{"darwin", "amd64"},
 		//	{"darwin", "arm"},
-		//	{"darwin", "arm64"},
+		{"darwin", "arm64"},
 		{"dragonfly", "amd64"},
 		{"freebsd", "386"},
 		{"freebsd", "amd64"},
@@ -135,6 +148,31 @@ func (t *xtargets) valid(target string) bool {
 	return ok
 }
 
+// restrict limits the targets that are offered and built to allowed, from the
+// config. Targets remain available for parsing existing records. Must be called
+// at startup, before targets are used.
+func (t *xtargets) restrict(allowed []string) error {
+	t.Lock()
+	defer t.Unlock()
+
+	var list []target
+	use := map[string]int{}
+	for _, s := range allowed {
+		if _, ok := t.available[s]; !ok {
+			return fmt.Errorf("unknown target %q, must be goos/goarch of a supported target", s)
+		}
+		if _, ok := use[s]; ok {
+			continue
+		}
+		goos, goarch, _ := strings.Cut(s, "/")
+		list = append(list, target{goos, goarch})
+		use[s] = 0
+	}
+	t.list = list
+	t.use = use
+	return nil
+}
+
 // must be called with lock held.
 func (t *xtargets) sort() {
 	n := make([]target, len(t.list))
@@ -148,6 +186,10 @@ func (t *xtargets) sort() {
 func (t *xtargets) increase(target string) {
 	t.Lock()
 	defer t.Unlock()
+	// E.g. rebuilds of existing records for targets no longer allowed by the config.
+	if _, ok := t.use[target]; !ok {
+		return
+	}
 	t.use[target]++
 	t.totalUse++
 	if t.totalUse <= 32 || t.totalUse%32 == 0 {
@@ -168,6 +210,40 @@ var sdk struct {
 	}
 }
 
+// HTTP client for listing and fetching Go toolchains at go.dev. It uses
+// http.DefaultTransport, with the proxy from config.GoReleasesHTTPProxy.
+// Requests get a timeout through their context, see retryGoreleases.
+var goreleasesClient = &http.Client{}
+
+// Timeouts for a single attempt at listing or fetching Go toolchains. They keep
+// a stalled connection from blocking builds for a toolchain forever.
+const (
+	goreleasesListTimeout  = time.Minute
+	goreleasesFetchTimeout = 15 * time.Minute
+)
+
+// retryGoreleases calls fn up to 3 times until it succeeds, with a context with
+// timeout, and a backoff between attempts. For transient network errors while
+// listing or fetching toolchains.
+func retryGoreleases(what string, timeout time.Duration, fn func(ctx context.Context) error) error {
+	var err error
+	backoff := 2 * time.Second
+	for attempt := 1; attempt <= 3; attempt++ {
+		if attempt > 1 {
+			slog.Info("retrying after error", "what", what, "err", err, "attempt", attempt, "backoff", backoff)
+			time.Sleep(backoff)
+			backoff *= 4
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err = fn(ctx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
 func initSDK() {
 	sdk.installed = map[string]struct{}{}
 	l, err := os.ReadDir(config.SDKDir)
@@ -182,6 +258,12 @@ func initSDK() {
 	sdkUpdateInstalledList()
 
 	sdk.fetch.status = map[string]error{}
+
+	n := config.MaxSDKFetches
+	if n <= 0 {
+		n = 1
+	}
+	sdkFetchTokens = make(chan struct{}, n)
 }
 
 // Lock must be held by calling.
@@ -220,6 +302,19 @@ func ensureMostRecentSDK() (goVersion, error) {
 	}
 }
 
+// latestGoversion returns the goversion that "latest" resolves to for builds for
+// goos/goarch: the goversion pinned in the config for the target, or the newest
+// allowed goversion. Empty if no toolchains are known.
+func latestGoversion(goos, goarch string) string {
+	for _, p := range config.PinnedGoversions {
+		if p.Target == goos+"/"+goarch {
+			return p.Goversion
+		}
+	}
+	newestAllowed, _, _ := listSDK()
+	return newestAllowed
+}
+
 func listSDK() (newestAllowed string, supported []string, remainingAvailable []string) {
 	now := time.Now()
 	sdk.Lock()
@@ -229,8 +324,11 @@ func listSDK() (newestAllowed string, supported []string, remainingAvailable []s
 		// Don't hold lock while requesting. Don't let others make the same request.
 		sdk.lastSupported = now
 		sdk.Unlock()
-		// todo: set a (low) timeout on the request
-		rels, err := goreleases.ListSupported()
+		var rels []goreleases.Release
+		err := retryGoreleases("listing supported go releases", goreleasesListTimeout, func(ctx context.Context) (err error) {
+			rels, err = listGoReleases(ctx, false)
+			return err
+		})
 		sdk.Lock()
 
 		if err != nil {
@@ -312,6 +410,9 @@ func ensureSDK(goversion string) (goVersion, error) {
 	if sdkVersionStop != nil && gv.num() >= sdkVersionStop.num() {
 		return goVersion{}, fmt.Errorf("%w: version equal or newer than %s not allowed by config", errBadGoversion, sdkVersionStop.String())
 	}
+	if sdkVersionMin != nil && gv.num() < sdkVersionMin.num() {
+		return goVersion{}, fmt.Errorf("%w: version older than %s not allowed by config", errBadGoversion, sdkVersionMin.String())
+	}
 
 	// See if this is an SDK we know we have installed.
 	sdk.Lock()
@@ -321,48 +422,90 @@ func ensureSDK(goversion string) (goVersion, error) {
 	}
 	sdk.Unlock()
 
-	// Not installed yet. Let's see if we've fetched it before. If we tried and failed
-	// before, we won't try again (during the lifetime of this process). If another
-	// goroutine has installed it while we were waiting on the lock, we know this by
-	// the presence of an entry in status, without an error.
-	sdk.fetch.Lock()
-	defer sdk.fetch.Unlock()
-	if err, ok := sdk.fetch.status[goversion]; ok {
+	// Not installed yet. Concurrent requests for the same version share a single
+	// fetch.
+	_, err = sdkFetchFlights.do(goversion, func() (struct{}, error) {
+		return struct{}{}, installSDK(goversion)
+	})
+	if err != nil {
 		return goVersion{}, err
 	}
+	return gv, nil
+}
 
-	rels, err := goreleases.ListAll()
-	if err != nil {
-		err = fmt.Errorf("%w: listing known releases: %v", errRemote, err)
+var sdkFetchFlights flights[struct{}]
+
+// Limits concurrent downloads and extractions of toolchains, see
+// config.MaxSDKFetches. Nil if not limited, outside of serve.
+var sdkFetchTokens chan struct{}
+
+// installSDK fetches and installs toolchain goversion.
+func installSDK(goversion string) (rerr error) {
+	// Let's see if we've fetched it before. If we tried and failed before, we won't
+	// try again (during the lifetime of this process), except after network errors
+	// while listing or fetching, which may be transient. If another fetch has
+	// installed it after the caller checked, we know this by the presence of an entry
+	// in status, without an error.
+	sdk.fetch.Lock()
+	err, ok := sdk.fetch.status[goversion]
+	sdk.fetch.Unlock()
+	if ok {
+		return err
+	}
+	setStatus := func(err error) {
+		sdk.fetch.Lock()
 		sdk.fetch.status[goversion] = err
-		return goVersion{}, err
+		sdk.fetch.Unlock()
+	}
+
+	// Fetching and extracting is heavy on the network and disk, with a burst of
+	// requests for new versions we don't want to do them all at once.
+	if sdkFetchTokens != nil {
+		t0 := time.Now()
+		sdkFetchTokens <- struct{}{}
+		defer func() {
+			<-sdkFetchTokens
+		}()
+		if d := time.Since(t0); d > time.Second {
+			slog.Info("waited for other toolchain fetches", "goversion", goversion, "duration", d)
+		}
+	}
+
+	rels, err := listReleases()
+	if err != nil {
+		metricSDKFetches.WithLabelValues(goversion).Inc()
+		metricSDKFetchErrors.WithLabelValues(goversion).Inc()
+		return fmt.Errorf("%w: listing known releases: %v", errRemote, err)
 	}
 	for _, rel := range rels {
 		if rel.Version != goversion {
 			continue
 		}
 
-		f, err := goreleases.FindFile(rel, runtime.GOOS, runtime.GOARCH, "archive")
-		if err != nil {
-			err = fmt.Errorf("%w: finding release file: %v", errServer, err)
-			sdk.fetch.status[goversion] = err
-			return goVersion{}, err
-		}
-		tmpdir, err := os.MkdirTemp(config.SDKDir, "tmpsdk")
-		if err != nil {
-			err = fmt.Errorf("%w: making tempdir for sdk: %v", errServer, err)
-			sdk.fetch.status[goversion] = err
-			return goVersion{}, err
-		}
-		defer os.RemoveAll(tmpdir)
-
 		slog.Info("fetching sdk", "goversion", goversion)
-
-		if err := goreleases.Fetch(f, tmpdir, nil); err != nil {
-			err = fmt.Errorf("%w: installing sdk: %v", errServer, err)
-			sdk.fetch.status[goversion] = err
-			return goVersion{}, err
+		metricSDKFetches.WithLabelValues(goversion).Inc()
+		t0 := time.Now()
+		defer func() {
+			if rerr != nil {
+				metricSDKFetchErrors.WithLabelValues(goversion).Inc()
+			} else {
+				metricSDKFetchSuccess.WithLabelValues(goversion).Inc()
+				metricSDKFetchDuration.Observe(time.Since(t0).Seconds())
+			}
+		}()
+		tmpdir, signer, err := fetchSDK(rel, runtime.GOOS, runtime.GOARCH, config.SDKDir)
+		if tmpdir != "" {
+			defer os.RemoveAll(tmpdir)
 		}
+		if errors.Is(err, errNoSDKArchive) {
+			err = fmt.Errorf("%w: %v", errServer, err)
+			setStatus(err)
+			return err
+		} else if err != nil {
+			// Not marked as failed, a later build will try again.
+			return fmt.Errorf("%w: installing sdk: %v", errServer, err)
+		}
+		slog.Info("fetched sdk, signature verified", "goversion", goversion, "signingkey", signer)
 		gobin := filepath.Join(tmpdir, "go", "bin", "go"+goexe())
 		if !filepath.IsAbs(gobin) {
 			gobin = filepath.Join(workdir, gobin)
@@ -372,27 +515,68 @@ func ensureSDK(goversion string) (goVersion, error) {
 		// concurrently.
 		if err := ensurePrimedBuildCache(gobin, runtime.GOOS, runtime.GOARCH, goversion); err != nil {
 			err = fmt.Errorf("%w: priming build cache: %v", errServer, err)
-			sdk.fetch.status[goversion] = err
-			return goVersion{}, err
+			setStatus(err)
+			return err
 		} else if err := os.Rename(filepath.Join(tmpdir, "go"), filepath.Join(config.SDKDir, goversion)); err != nil {
 			err = fmt.Errorf("%w: putting sdk in place: %v", errServer, err)
-			sdk.fetch.status[goversion] = err
-			return goVersion{}, err
-		} else {
-			sdk.fetch.status[goversion] = nil
-
-			sdk.Lock()
-			defer sdk.Unlock()
-			sdk.installed[goversion] = struct{}{}
-			sdkUpdateInstalledList()
+			setStatus(err)
+			return err
 		}
-		return gv, nil
+		setStatus(nil)
+
+		sdk.Lock()
+		sdk.installed[goversion] = struct{}{}
+		sdkUpdateInstalledList()
+		sdk.Unlock()
+		return nil
 	}
 
 	// Release not found. It may be a future release. Don't mark it as
 	// tried-and-failed.
 	// We may want to ratelimit how often we ask...
-	return goVersion{}, fmt.Errorf("%w: no such version", errBadGoversion)
+	return fmt.Errorf("%w: no such version", errBadGoversion)
+}
+
+var errNoSDKArchive = errors.New("no archive for platform in release")
+
+// fetchSDK fetches the toolchain of release rel for host platform goos/goarch,
+// into a new temporary directory in dir, retrying on transient errors. The host
+// platform is normally that of the running gobuild, but can differ when
+// preparing toolchains for another machine. The toolchain ends up in directory
+// "go" in tmpdir, the caller must remove tmpdir, also on errors. Signer is the
+// fingerprint of the key that signed the release.
+func fetchSDK(rel goreleases.Release, goos, goarch, dir string) (tmpdir, signer string, rerr error) {
+	f, err := goreleases.FindFile(rel, goos, goarch, "archive")
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %s/%s: %v", errNoSDKArchive, goos, goarch, err)
+	}
+
+	// Each attempt gets a fresh tmpdir, without a partial download of an earlier
+	// attempt.
+	err = retryGoreleases("fetching sdk "+rel.Version, goreleasesFetchTimeout, func(ctx context.Context) error {
+		if tmpdir != "" {
+			os.RemoveAll(tmpdir)
+		}
+		var err error
+		tmpdir, err = os.MkdirTemp(dir, "tmpsdk")
+		if err != nil {
+			tmpdir = ""
+			return fmt.Errorf("making tempdir for sdk: %v", err)
+		}
+		signer, err = fetchGoRelease(ctx, f, tmpdir)
+		return err
+	})
+	return tmpdir, signer, err
+}
+
+// listReleases returns all known go releases, retrying on transient errors.
+func listReleases() ([]goreleases.Release, error) {
+	var rels []goreleases.Release
+	err := retryGoreleases("listing all go releases", goreleasesListTimeout, func(ctx context.Context) (err error) {
+		rels, err = listGoReleases(ctx, true)
+		return err
+	})
+	return rels, err
 }
 
 func goexe() string {
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Result of the most recent goproxy reachability check, used by readiness
+// checks. Only a single check is done at a time, others wait for its result.
+var goproxyHealth struct {
+	sync.Mutex
+	checked time.Time
+	err     error
+}
+
+// Liveness check, always succeeds when the process is up and serving http.
+func serveHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	fmt.Fprintln(w, "ok")
+}
+
+// Readiness check, succeeds when a go toolchain is installed and the goproxy is
+// reachable. The transparency log state is verified at startup, before serving.
+func serveReadyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	var problems []string
+	sdk.Lock()
+	nsdk := len(sdk.installed)
+	sdk.Unlock()
+	if nsdk == 0 {
+		problems = append(problems, "no go toolchains installed")
+	}
+	if err := checkGoproxyReachable(); err != nil {
+		problems = append(problems, fmt.Sprintf("goproxy not reachable: %v", err))
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if len(problems) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		for _, p := range problems {
+			fmt.Fprintln(w, p)
+		}
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// checkGoproxyReachable returns whether we recently received an http response
+// from the goproxy. Any response counts, the goproxy may not serve anything at
+// its root. The result is cached for a minute.
+func checkGoproxyReachable() error {
+	goproxyHealth.Lock()
+	defer goproxyHealth.Unlock()
+
+	if time.Since(goproxyHealth.checked) < time.Minute {
+		return goproxyHealth.err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	goproxyHealth.err = func() error {
+		req, err := http.NewRequestWithContext(ctx, "GET", config.GoProxy, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 16*1024))
+		resp.Body.Close()
+		return nil
+	}()
+	goproxyHealth.checked = time.Now()
+	return goproxyHealth.err
+}
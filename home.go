@@ -81,7 +81,7 @@ func serveHome(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if !checkAllowedRespond(w, r.URL.Path[1:]) {
+		if !checkAllowedRespond(w, r.URL.Path[1:], "") {
 			return
 		}
 		serveModules(w, r)
@@ -92,9 +92,10 @@ func serveHome(w http.ResponseWriter, r *http.Request) {
 	// have a slash, we'll assume a path like /github.com/mjl-/sherpa@v0.6.0 and
 	// redirect to a path with guessed goos/goarch and latest goversion.
 	if mod, version, _ := strings.Cut(r.URL.Path[1:], "@"); mod != "" && version != "" && !strings.Contains(version, "@") && !strings.Contains(version, "/") {
-		goversion, err := ensureMostRecentSDK()
-		if err != nil {
-			http.Error(w, "500 - Internal Server Error - "+err.Error(), http.StatusInternalServerError)
+		goos, goarch := autodetectTarget(r)
+		goversion := latestGoversion(goos, goarch)
+		if goversion == "" {
+			http.Error(w, "500 - Internal Server Error - no supported go toolchains available", http.StatusInternalServerError)
 			return
 		}
 
@@ -106,16 +107,28 @@ func serveHome(w http.ResponseWriter, r *http.Request) {
 		}
 		version = info.Version
 
-		goos, goarch := autodetectTarget(r)
-		bs := buildSpec{mod, version, "/", goos, goarch, goversion.String(), false}
+		bs := buildSpec{mod, version, "/", goos, goarch, goversion, false, false, false, false, "", "", ""}
 
 		req := request{bs, "", pageIndex}
-		http.Redirect(w, r, req.link(), http.StatusTemporaryRedirect)
+		http.Redirect(w, r, linkQuery(r, req.link()), http.StatusTemporaryRedirect)
 		return
 	}
 
-	req, hint, ok := parseRequest(r.URL.Path)
-	if !ok {
+	req, hint, failure := parseRequest(r.URL.Path)
+	if failure != requestOK {
+		// Redirect near-identical URLs to a single URL, so caches and crawlers don't
+		// fragment, and don't trigger duplicate builds.
+		if p := canonicalRequestPath(r.URL.Path); p != "" {
+			http.Redirect(w, r, linkQuery(r, p), http.StatusPermanentRedirect)
+			return
+		}
+	}
+	switch failure {
+	case requestOK:
+	case requestBadRequest:
+		http.Error(w, fmt.Sprintf("400 - Bad Request\n\n%s\n", hint), http.StatusBadRequest)
+		return
+	default:
 		if hint != "" {
 			http.Error(w, fmt.Sprintf("404 - File Not Found\n\n%s\n", hint), http.StatusNotFound)
 		} else {
@@ -123,11 +136,17 @@ func serveHome(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	if lw, ok := w.(*logResponseWriter); ok {
+		lw.Build = &req
+	}
+	if req.Page == pageVerifiers && handleCORS(w, r) {
+		return
+	}
 	if req.Page != pageRetry && r.Method != "GET" || req.Page == pageRetry && r.Method != "POST" {
 		http.Error(w, "405 - Method Not Allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	if !checkAllowedRespond(w, req.Mod) {
+	if !checkAllowedRespond(w, req.Mod, req.Version) {
 		return
 	}
 
@@ -139,7 +158,7 @@ func serveHome(w http.ResponseWriter, r *http.Request) {
 	}
 	if req.Version != info.Version {
 		req.Version = info.Version
-		http.Redirect(w, r, req.link(), http.StatusTemporaryRedirect)
+		http.Redirect(w, r, linkQuery(r, req.link()), http.StatusTemporaryRedirect)
 		req.link()
 		return
 	}
@@ -156,11 +175,26 @@ func serveHome(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func checkAllowedRespond(w http.ResponseWriter, module string) bool {
-	if len(config.ModulePrefixes) == 0 {
+// checkAllowedRespond checks module against the allowed and blocked modules,
+// responding with an error if not allowed. Version can be empty if unknown.
+func checkAllowedRespond(w http.ResponseWriter, module, version string) bool {
+	for _, b := range configBlockedModulePrefixes() {
+		if b.Version != "" && (module != b.Prefix || version != b.Version) || b.Version == "" && !strings.HasPrefix(module, b.Prefix) {
+			continue
+		}
+		msg := "403 - Module blocked"
+		if b.Reason != "" {
+			msg += " - " + b.Reason
+		}
+		http.Error(w, msg, http.StatusForbidden)
+		return false
+	}
+
+	modulePrefixes := configModulePrefixes()
+	if len(modulePrefixes) == 0 {
 		return true
 	}
-	for _, prefix := range config.ModulePrefixes {
+	for _, prefix := range modulePrefixes {
 		if strings.HasPrefix(module, prefix) {
 			return true
 		}
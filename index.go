@@ -2,25 +2,23 @@ package main
 
 import (
 	"compress/gzip"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
+	"runtime"
 	"sort"
-	"strings"
+	"sync"
 	"time"
 
-	"golang.org/x/mod/module"
 	"golang.org/x/mod/semver"
 )
 
-func fileExists(p string) bool {
-	_, err := os.Stat(p)
-	return err == nil
-}
-
 // serveIndex serves the HTML page for a build/result, that has either failed or is
 // pending or has succeeded.
 func serveIndex(w http.ResponseWriter, r *http.Request, bs buildSpec, br *buildResult) {
@@ -31,76 +29,49 @@ func serveIndex(w http.ResponseWriter, r *http.Request, bs buildSpec, br *buildR
 		Version string
 		URLPath string
 		Success bool
+		Size    string // Of the binary, for successful builds, for spotting size changes.
 		Active  bool
 	}
 	type response struct {
 		Err           error
-		LatestVersion string
+		LatestVersion string // As resolved by the goproxy for "latest", empty if unknown.
 		VersionLinks  []versionLink
 	}
 
 	// Do a lookup to the goproxy in the background, to list the module versions.
 	c := make(chan response, 1)
 	go func() {
-		t0 := time.Now()
-		defer func() {
-			metricGoproxyListDuration.Observe(time.Since(t0).Seconds())
-		}()
-
-		modPath, err := module.EscapePath(bs.Mod)
+		versions, latest, err := listModuleVersions(r.Context(), bs.Mod)
 		if err != nil {
-			c <- response{fmt.Errorf("bad module path: %v", err), "", nil}
-			return
-		}
-		u := fmt.Sprintf("%s%s/@v/list", config.GoProxy, modPath)
-		mreq, err := http.NewRequestWithContext(r.Context(), "GET", u, nil)
-		if err != nil {
-			c <- response{fmt.Errorf("%w: preparing new http request: %v", errServer, err), "", nil}
-			return
-		}
-		mreq.Header.Set("User-Agent", userAgent)
-		resp, err := http.DefaultClient.Do(mreq)
-		if err != nil {
-			c <- response{fmt.Errorf("%w: http request: %v", errServer, err), "", nil}
-			return
-		}
-		defer resp.Body.Close()
-		if resp.StatusCode != 200 {
-			metricGoproxyListErrors.WithLabelValues(fmt.Sprintf("%d", resp.StatusCode)).Inc()
-			c <- response{fmt.Errorf("%w: http response from goproxy: %v", errRemote, resp.Status), "", nil}
-			return
-		}
-		buf, err := io.ReadAll(resp.Body)
-		if err != nil {
-			c <- response{fmt.Errorf("%w: reading versions from goproxy: %v", errRemote, err), "", nil}
+			c <- response{err, "", nil}
 			return
 		}
 		l := []versionLink{}
-		for _, s := range strings.Split(string(buf), "\n") {
-			if s != "" {
-				vbs := bs
-				vbs.Version = s
-				success := fileExists(filepath.Join(vbs.storeDir(), "recordnumber"))
-				p := request{vbs, "", pageIndex}.link()
-				link := versionLink{s, p, success, p == xlink}
-				l = append(l, link)
+		for _, s := range versions {
+			vbs := bs
+			vbs.Version = s
+			var size string
+			success := resultFileExists(vbs.storeKey(), "recordnumber")
+			if success {
+				if n := resultFilesize(r.Context(), vbs); n >= 0 {
+					size = fmt.Sprintf("%.1f MB", float64(n)/(1024*1024))
+				}
 			}
+			p := request{vbs, "", pageIndex}.link()
+			link := versionLink{s, p, success, size, p == xlink}
+			l = append(l, link)
 		}
 		sort.Slice(l, func(i, j int) bool {
 			return semver.Compare(l[i].Version, l[j].Version) > 0
 		})
-		var latestVersion string
-		if len(l) > 0 {
-			latestVersion = l[0].Version
-		}
-		c <- response{nil, latestVersion, l}
+		c <- response{nil, latest, l}
 	}()
 
 	// Non-emptiness means we'll serve the error page instead of doing a SSE request for events.
 	var output string
 	if br == nil {
-		if buf, err := readGzipFile(filepath.Join(bs.storeDir(), "log.gz")); err != nil {
-			if !os.IsNotExist(err) {
+		if buf, err := readGzipFile(bs.storeKey(), "log.gz"); err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
 				failf(w, "%w: reading log.gz: %v", errServer, err)
 				return
 			}
@@ -119,20 +90,23 @@ func serveIndex(w http.ResponseWriter, r *http.Request, bs buildSpec, br *buildR
 		Active    bool
 	}
 	goversionLinks := []goversionLink{}
-	newestAllowed, supported, remaining := listSDK()
-	for _, goversion := range supported {
+	addGoversion := func(goversion string, supported bool) {
 		gvbs := bs
 		gvbs.Goversion = goversion
-		success := fileExists(filepath.Join(gvbs.storeDir(), "recordnumber"))
+		success := resultFileExists(gvbs.storeKey(), "recordnumber")
 		p := request{gvbs, "", pageIndex}.link()
-		goversionLinks = append(goversionLinks, goversionLink{goversion, p, success, true, p == xlink})
+		// Toolchains not allowed by the config, e.g. too old, are only shown for existing builds.
+		if !success && p != xlink && !goversionAllowed(goversion) {
+			return
+		}
+		goversionLinks = append(goversionLinks, goversionLink{goversion, p, success, supported, p == xlink})
+	}
+	newestAllowed, supported, remaining := listSDK()
+	for _, goversion := range supported {
+		addGoversion(goversion, true)
 	}
 	for _, goversion := range remaining {
-		gvbs := bs
-		gvbs.Goversion = goversion
-		success := fileExists(filepath.Join(gvbs.storeDir(), "recordnumber"))
-		p := request{gvbs, "", pageIndex}.link()
-		goversionLinks = append(goversionLinks, goversionLink{goversion, p, success, false, p == xlink})
+		addGoversion(goversion, false)
 	}
 
 	type targetLink struct {
@@ -147,40 +121,84 @@ func serveIndex(w http.ResponseWriter, r *http.Request, bs buildSpec, br *buildR
 		tbs := bs
 		tbs.Goos = target.Goos
 		tbs.Goarch = target.Goarch
-		success := fileExists(filepath.Join(tbs.storeDir(), "recordnumber"))
+		success := resultFileExists(tbs.storeKey(), "recordnumber")
 		p := request{tbs, "", pageIndex}.link()
 		targetLinks = append(targetLinks, targetLink{target.Goos, target.Goarch, p, success, p == xlink})
 	}
 
 	type variantLink struct {
-		Variant string // "default" or "stripped"
+		Variant string // "default", "stripped", "netgo", "vendor" or "race"
 		Title   string // Displayed on hover in UI.
 		URLPath string
 		Success bool
 		Active  bool
 	}
 	var variantLinks []variantLink
-	addVariant := func(v, title string, stripped bool) {
+	addVariant := func(v, title string, stripped, netgo, vendor, race bool) {
 		vbs := bs
 		vbs.Stripped = stripped
-		success := fileExists(filepath.Join(vbs.storeDir(), "recordnumber"))
+		vbs.Netgo = netgo
+		vbs.Vendor = vendor
+		vbs.Race = race
+		success := resultFileExists(vbs.storeKey(), "recordnumber")
 		p := request{vbs, "", pageIndex}.link()
 		variantLinks = append(variantLinks, variantLink{v, title, p, success, p == xlink})
 	}
-	addVariant("default", "", false)
-	addVariant("stripped", "Symbol table and debug information stripped, reducing binary size.", true)
+	addVariant("default", "", false, false, false, false)
+	addVariant("stripped", "Symbol table and debug information stripped, reducing binary size.", true, false, false, false)
+	addVariant("netgo", "Built with build tags netgo and osusergo, for a static binary with pure Go name resolution and user lookups. Only affects packages that honor these tags.", false, true, false, false)
+	// Only offered for modules that include their vendored dependencies.
+	if modDir, err := moduleDir(bs.Mod, bs.Version); err == nil {
+		if _, err := os.Stat(filepath.Join(modDir, "vendor", "modules.txt")); err == nil {
+			addVariant("vendor", "Built with -mod=vendor in the module directory, with the dependencies from the vendor directory of the module instead of through the goproxy. The binary has no module version in its build info.", false, false, true, false)
+		}
+	}
+	if config.AllowRace && bs.Goos == runtime.GOOS && bs.Goarch == runtime.GOARCH {
+		addVariant("race", "Built with the race detector, for debugging. Not verified by other instances, and not guaranteed to be reproducible.", false, false, false, true)
+	}
 
 	pkgGoDevURL := "https://pkg.go.dev/" + path.Join(bs.Mod+"@"+bs.Version, bs.Dir[1:]) + "?tab=doc"
 
 	resp := <-c
 
 	var filesizeGz string
+	var buildDuration string
+	var verifiers []string
+	var sha256 string
+	var sourceDateEpoch string
 	if br == nil {
 		br = &buildResult{buildSpec: bs}
 	} else {
-		if info, err := os.Stat(filepath.Join(bs.storeDir(), "binary.gz")); err == nil {
+		if info, err := results.Stat(bs.storeKey(), "binary.gz"); err == nil {
 			filesizeGz = fmt.Sprintf("%.1f MB", float64(info.Size())/(1024*1024))
 		}
+		var err error
+		verifiers, err = readVerifiers(bs.storeKey())
+		if err != nil {
+			failf(w, "%w: reading verifiers: %v", errServer, err)
+			return
+		}
+		if d, err := readBuildDuration(bs.storeKey()); err != nil {
+			failf(w, "%w: reading build duration: %v", errServer, err)
+			return
+		} else if d > 0 {
+			buildDuration = d.Round(100 * time.Millisecond).String()
+		}
+		// For the reproduce command. Only for builds that used it, the config may have
+		// changed since.
+		sourceDateEpoch, err = readSourceDateEpoch(bs.storeKey())
+		if err != nil {
+			failf(w, "%w: reading source date epoch: %v", errServer, err)
+			return
+		}
+		sha256 = br.SHA256
+		if sha256 == "" {
+			sha256, err = readBinarySHA256(bs.storeKey())
+			if err != nil {
+				failf(w, "%w: reading binary signature: %v", errServer, err)
+				return
+			}
+		}
 	}
 
 	prependDir := xreq.Dir
@@ -189,16 +207,19 @@ func serveIndex(w http.ResponseWriter, r *http.Request, bs buildSpec, br *buildR
 	}
 
 	var newerText, newerURL string
-	if xreq.Goversion != newestAllowed && newestAllowed != "" && xreq.Version != resp.LatestVersion && resp.LatestVersion != "" {
+	newerModule := resp.LatestVersion != "" && semver.Compare(resp.LatestVersion, xreq.Version) > 0
+	if xreq.Goversion != newestAllowed && newestAllowed != "" && newerModule {
 		newerText = "A newer version of both this module and the Go toolchain is available"
-	} else if xreq.Version != resp.LatestVersion && resp.LatestVersion != "" {
+	} else if newerModule {
 		newerText = "A newer version of this module is available"
 	} else if xreq.Goversion != newestAllowed && newestAllowed != "" {
 		newerText = "A newer Go toolchain version is available"
 	}
 	if newerText != "" {
 		nbs := bs
-		nbs.Version = resp.LatestVersion
+		if newerModule {
+			nbs.Version = resp.LatestVersion
+		}
 		nbs.Goversion = newestAllowed
 		newerURL = request{nbs, "", pageIndex}.link()
 	}
@@ -209,6 +230,10 @@ func serveIndex(w http.ResponseWriter, r *http.Request, bs buildSpec, br *buildR
 	} else if br.Sum == "" {
 		favicon = "/favicon-building.png"
 	}
+	var errorNotes string
+	if output != "" {
+		errorNotes = readErrorNotes()
+	}
 	args := map[string]interface{}{
 		"Favicon":                favicon,
 		"Success":                br.Sum != "",
@@ -219,6 +244,8 @@ func serveIndex(w http.ResponseWriter, r *http.Request, bs buildSpec, br *buildR
 		"GoversionLinks":         goversionLinks,
 		"TargetLinks":            targetLinks,
 		"VariantLinks":           variantLinks,
+		"GoTags":                 bs.goTags(),
+		"SourceDateEpoch":        sourceDateEpoch,
 		"Mod":                    resp,
 		"GoProxy":                config.GoProxy,
 		"DownloadFilename":       xreq.downloadFilename(),
@@ -229,16 +256,26 @@ func serveIndex(w http.ResponseWriter, r *http.Request, bs buildSpec, br *buildR
 		"GobuildsOrgVerifierKey": gobuildsOrgVerifierKey,
 		"NewerText":              newerText,
 		"NewerURL":               newerURL,
+		"BatchLink":              batchLink(xreq.buildSpec),
 
 		// Whether we will do SSE request for updates.
 		"InProgress": br.Sum == "" && output == "",
 
 		// Non-empty on failure.
-		"Output": output,
+		"Output":  logStderr(output),
+		"Failure": classifyFailure(nil, logStderr(output)),
+		"Notes":   errorNotes,
 
 		// Below only meaningful when "success".
-		"Filesize":   fmt.Sprintf("%.1f MB", float64(br.Filesize)/(1024*1024)),
-		"FilesizeGz": filesizeGz,
+		"Filesize":      fmt.Sprintf("%.1f MB", float64(br.Filesize)/(1024*1024)),
+		"FilesizeGz":    filesizeGz,
+		"BuildDuration": buildDuration, // Empty if unknown.
+		"Verifiers":     verifiers,     // Base URLs of other instances that confirmed the sum.
+		"Signature":     br.Sum != "" && resultFileExists(bs.storeKey(), "binary.sig"),
+		"SHA256":        sha256, // Full hash in hex, only known for signed builds and version 2 records.
+
+		// Permanent URL including the sum, for the curl/wget commands.
+		"DownloadURL": baseURL(r) + request{bs, br.Sum, pageDownload}.link(),
 	}
 
 	if br.Sum == "" {
@@ -250,8 +287,9 @@ func serveIndex(w http.ResponseWriter, r *http.Request, bs buildSpec, br *buildR
 	}
 }
 
-func readGzipFile(p string) ([]byte, error) {
-	f, err := os.Open(p)
+// readGzipFile returns the decompressed contents of a gzip file of a result.
+func readGzipFile(key, name string) ([]byte, error) {
+	f, err := results.Open(key, name)
 	if err != nil {
 		return nil, err
 	}
@@ -262,3 +300,35 @@ func readGzipFile(p string) ([]byte, error) {
 		return io.ReadAll(fgz)
 	}
 }
+
+// Sizes of binaries of successful builds, keyed by store key. Results are
+// immutable, so entries don't expire. Cleared when full.
+var resultFilesizes = struct {
+	sync.Mutex
+	m map[string]int64
+}{m: map[string]int64{}}
+
+// resultFilesize returns the size of the binary of the successful build bs, from
+// its record, or -1 if it cannot be read. Only called for builds with a result,
+// so only local files are read.
+func resultFilesize(ctx context.Context, bs buildSpec) int64 {
+	key := bs.storeKey()
+	resultFilesizes.Lock()
+	n, ok := resultFilesizes.m[key]
+	resultFilesizes.Unlock()
+	if ok {
+		return n
+	}
+
+	_, br, _, _, err := (serverOps{}).lookupResult(ctx, bs)
+	if err != nil || br == nil {
+		return -1
+	}
+	resultFilesizes.Lock()
+	defer resultFilesizes.Unlock()
+	if len(resultFilesizes.m) >= 10000 {
+		resultFilesizes.m = map[string]int64{}
+	}
+	resultFilesizes.m[key] = br.Filesize
+	return br.Filesize
+}
@@ -245,6 +245,99 @@ func (c *Client) Lookup(key string) (id int64, data []byte, err error) {
 	return result.id, result.text, nil
 }
 
+// Latest fetches the latest signed tree head from the server and merges it with
+// the Client's latest known tree head, checking that the server's log is
+// consistent with the tree heads seen before. It returns the latest known tree.
+func (c *Client) Latest() (tlog.Tree, error) {
+	if err := c.init(); err != nil {
+		return tlog.Tree{}, err
+	}
+	msg, err := c.ops.ReadRemote("/latest")
+	if err != nil {
+		return tlog.Tree{}, err
+	}
+	if err := c.mergeLatest(msg); err != nil {
+		return tlog.Tree{}, err
+	}
+	c.latestMu.Lock()
+	defer c.latestMu.Unlock()
+	return c.latest, nil
+}
+
+// TreeHash returns the hash of the tree of size n, authenticated against the
+// latest known tree head. For checking that a local copy of the log is a prefix
+// of the server's log.
+func (c *Client) TreeHash(n int64) (tlog.Hash, error) {
+	if err := c.init(); err != nil {
+		return tlog.Hash{}, err
+	}
+	c.latestMu.Lock()
+	latest := c.latest
+	c.latestMu.Unlock()
+
+	if n > latest.N {
+		return tlog.Hash{}, fmt.Errorf("cannot compute hash of tree of size %d with latest tree of size %d", n, latest.N)
+	}
+	return tlog.TreeHash(n, tlog.TileHashReader(latest, &c.tileReader))
+}
+
+// ReadRecords returns the data of records id through id+n-1, read from data tiles
+// on the server and authenticated against the latest known tree head. The
+// records must be in the latest known tree, see Latest.
+func (c *Client) ReadRecords(id, n int64) ([][]byte, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+	c.latestMu.Lock()
+	latest := c.latest
+	c.latestMu.Unlock()
+
+	if id < 0 || n <= 0 || id+n > latest.N {
+		return nil, fmt.Errorf("cannot read records %d through %d in tree of size %d", id, id+n-1, latest.N)
+	}
+
+	records := make([][]byte, 0, n)
+	for next := id; next < id+n; {
+		// Full tiles, and a partial tile for the last records of the tree.
+		tile := tlog.Tile{H: c.tileHeight, L: -1, N: next >> uint(c.tileHeight)}
+		start := tile.N << uint(c.tileHeight)
+		tile.W = int(min(int64(1)<<uint(c.tileHeight), latest.N-start))
+		data, err := c.ops.ReadRemote(c.tileRemotePath(tile))
+		if err != nil {
+			return nil, err
+		}
+		for i := start; i < start+int64(tile.W); i++ {
+			rid, text, rest, err := tlog.ParseRecord(data)
+			if err != nil {
+				return nil, fmt.Errorf("parsing record %d from data tile: %v", i, err)
+			}
+			if rid != i {
+				return nil, fmt.Errorf("data tile has record %d, expected %d", rid, i)
+			}
+			data = rest
+			if i >= next && i < id+n {
+				records = append(records, text)
+			}
+		}
+		next = start + int64(tile.W)
+	}
+
+	indexes := make([]int64, n)
+	for i := range indexes {
+		indexes[i] = tlog.StoredHashIndex(0, id+int64(i))
+	}
+	hashes, err := tlog.TileHashReader(latest, &c.tileReader).ReadHashes(indexes)
+	if err != nil {
+		return nil, err
+	}
+	for i, text := range records {
+		if hashes[i] != tlog.RecordHash(text) {
+			return nil, fmt.Errorf("cannot authenticate data of record %d in server response", id+int64(i))
+		}
+	}
+	return records, nil
+}
+
 // mergeLatest merges the tree head in msg
 // with the Client's current latest tree head,
 // ensuring the result is a consistent timeline.
@@ -90,6 +90,49 @@ func TestClientBadTiles(t *testing.T) {
 	tc.mustError(err, "rsc.io/sampler@v1.3.0: initializing sumdb.Client: checking tree#1: downloaded inconsistent tile")
 }
 
+func TestClientReadRecords(t *testing.T) {
+	tc := newTestClient(t)
+	tc.addRecord("rsc.io/pkg1@v1.5.2", `rsc.io/pkg1 v1.5.2 h1:hash!=
+`)
+
+	tree, err := tc.client.Latest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.N != 5 {
+		t.Fatalf("latest tree has size %d, want 5", tree.N)
+	}
+	tc.mustHaveLatest(5)
+
+	// Records spanning a full and a partial tile.
+	records, err := tc.client.ReadRecords(2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || !strings.HasPrefix(string(records[0]), "rsc.io/sampler ") || string(records[2]) != "rsc.io/pkg1 v1.5.2 h1:hash!=\n" {
+		t.Fatalf("unexpected records %q", records)
+	}
+
+	if _, err := tc.client.ReadRecords(4, 2); err == nil {
+		t.Fatalf("reading records beyond latest tree succeeded")
+	}
+
+	if h, err := tc.client.TreeHash(2); err != nil {
+		t.Fatal(err)
+	} else if exp, _ := tlog.TreeHash(2, tc); h != exp {
+		t.Fatalf("tree hash %v, want %v", h, exp)
+	}
+
+	// Tampered record data.
+	for p, data := range tc.remote {
+		if strings.HasPrefix(p, "/tile/2/data/001") {
+			tc.remote[p] = bytes.Replace(data, []byte("hash!="), []byte("hash?="), 1)
+		}
+	}
+	_, err = tc.client.ReadRecords(4, 1)
+	tc.mustError(err, "cannot authenticate data of record 4")
+}
+
 func TestClientFork(t *testing.T) {
 	tc := newTestClient(t)
 	tc2 := tc.fork()
@@ -162,6 +205,7 @@ type testClient struct {
 	getTileOK  bool       // should tc.GetURL of tiles succeed?
 	treeSize   int64
 	hashes     []tlog.Hash
+	records    [][]byte
 	remote     map[string][]byte
 	signer     note.Signer
 
@@ -275,6 +319,7 @@ func (tc *testClient) fork() *testClient {
 		tileHeight: tc.tileHeight,
 		treeSize:   tc.treeSize,
 		hashes:     append([]tlog.Hash{}, tc.hashes...),
+		records:    append([][]byte{}, tc.records...),
 		signer:     tc.signer,
 		config:     copyMap(tc.config),
 		cache:      copyMap(tc.cache),
@@ -321,6 +366,14 @@ func (tc *testClient) addRecord(key, data string) {
 
 	// Create lookup result.
 	tc.remote["/lookup/"+key] = append(rec, tc.signTree(tc.treeSize)...)
+	tc.remote["/latest"] = tc.signTree(tc.treeSize)
+
+	// Create data tile, full or partial.
+	tc.records = append(tc.records, rec)
+	dataTile := tlog.Tile{H: tc.tileHeight, L: -1, N: id >> uint(tc.tileHeight)}
+	start := dataTile.N << uint(tc.tileHeight)
+	dataTile.W = int(id - start + 1)
+	tc.remote["/"+dataTile.Path()] = bytes.Join(tc.records[start:], nil)
 
 	// Create new tiles.
 	tiles := tlog.NewTiles(tc.tileHeight, id, tc.treeSize)
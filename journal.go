@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/mod/sumdb/tlog"
+)
+
+// Path to the journal file in data/sum, set at startup. The journal describes an
+// append to the transparency log by addSum that is in progress. It only exists
+// while adding a record, or after a crash halfway an append.
+var sumJournalPath string
+
+// sumJournal is the intent written to the journal file before addSum makes any
+// change to the records and hashes files and the result dir.
+type sumJournal struct {
+	RecordNumber int64  // Number of the record being added.
+	RecordsSize  int64  // Size of records file before the append.
+	HashesSize   int64  // Size of hashes file before the append.
+	Tmpdir       string // Local directory with build results, stored as StoreKey as last step.
+	StoreKey     string // Key in the result store.
+}
+
+// writeSumJournal writes the intent for an append atomically, through a
+// temporary file that is renamed into place, and syncs it to disk.
+func writeSumJournal(j sumJournal) error {
+	buf, err := json.Marshal(j)
+	if err != nil {
+		return fmt.Errorf("marshal journal: %v", err)
+	}
+	p := sumJournalPath + ".tmp"
+	f, err := os.Create(p)
+	if err != nil {
+		return fmt.Errorf("create journal: %v", err)
+	}
+	defer func() {
+		if f != nil {
+			f.Close()
+			os.Remove(p)
+		}
+	}()
+	if _, err := f.Write(buf); err != nil {
+		return fmt.Errorf("write journal: %v", err)
+	} else if err := f.Sync(); err != nil {
+		return fmt.Errorf("sync journal: %v", err)
+	} else if err := f.Close(); err != nil {
+		return fmt.Errorf("close journal: %v", err)
+	}
+	f = nil
+	if err := os.Rename(p, sumJournalPath); err != nil {
+		os.Remove(p)
+		return fmt.Errorf("rename journal into place: %v", err)
+	}
+	return syncDir(filepath.Dir(sumJournalPath))
+}
+
+// clearSumJournal removes the journal after a completed append.
+func clearSumJournal() error {
+	if err := os.Remove(sumJournalPath); err != nil {
+		return fmt.Errorf("remove journal: %v", err)
+	}
+	return syncDir(filepath.Dir(sumJournalPath))
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("open dir for sync: %v", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("sync dir: %v", err)
+	}
+	return nil
+}
+
+// recoverSumJournal completes or undoes an append to the transparency log that
+// was interrupted, as recorded in the journal, and clears the journal. Called at
+// startup, and by addSum when an error occurs during the append.
+//
+// If the record has been written completely, the append is completed by storing
+// the result: a signed tree with the record may have been served already. If the
+// temporary directory with the build results is gone, a result with only the
+// recordnumber file is stored, like after a cleanup of the binary. Otherwise the
+// records and hashes files are truncated to their sizes before the append.
+//
+// Returns whether the append was completed. If there is no journal, nothing is
+// done.
+func recoverSumJournal() (completed bool, rerr error) {
+	buf, err := os.ReadFile(sumJournalPath)
+	if err != nil && errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("reading journal: %v", err)
+	}
+	var j sumJournal
+	if err := json.Unmarshal(buf, &j); err != nil {
+		return false, fmt.Errorf("parsing journal: %v", err)
+	}
+
+	rinfo, err := recordsFile.Stat()
+	if err != nil {
+		return false, fmt.Errorf("stat records file: %v", err)
+	}
+	hinfo, err := hashesFile.Stat()
+	if err != nil {
+		return false, fmt.Errorf("stat hashes file: %v", err)
+	}
+	recordsSize, hashesSize := rinfo.Size(), hinfo.Size()
+	newRecordsSize := j.RecordsSize + diskRecordSize
+	newHashesSize := tlog.StoredHashCount(j.RecordNumber+1) * tlog.HashSize
+
+	attrs := []any{"recordnumber", j.RecordNumber, "storekey", j.StoreKey, "recordssize", recordsSize, "hashessize", hashesSize}
+
+	if recordsSize == newRecordsSize && hashesSize == newHashesSize {
+		// Record is present, complete the append.
+		if exists, err := results.Exists(j.StoreKey); err != nil {
+			return false, fmt.Errorf("checking for build result in result store: %v", err)
+		} else if exists {
+			slog.Info("journal: append was complete except for clearing journal", attrs...)
+		} else {
+			if _, err := os.Stat(j.Tmpdir); err != nil && errors.Is(err, fs.ErrNotExist) {
+				if err := os.Mkdir(j.Tmpdir, 0777); err != nil {
+					return false, fmt.Errorf("recreating tmpdir: %v", err)
+				}
+				slog.Warn("journal: build results were lost, storing result without binary", attrs...)
+			} else if err != nil {
+				return false, fmt.Errorf("stat on tmpdir: %v", err)
+			}
+			if err := os.WriteFile(filepath.Join(j.Tmpdir, "recordnumber"), []byte(fmt.Sprintf("%d", j.RecordNumber)), 0666); err != nil {
+				return false, fmt.Errorf("writing recordnumber file: %v", err)
+			}
+			if err := results.Put(j.StoreKey, j.Tmpdir); err != nil {
+				return false, fmt.Errorf("storing build result: %v", err)
+			}
+			slog.Warn("journal: completed append by storing build result", attrs...)
+		}
+		completed = true
+	} else if recordsSize >= j.RecordsSize && recordsSize < newRecordsSize && hashesSize >= j.HashesSize && hashesSize <= newHashesSize {
+		// Record not (completely) written, undo the append.
+		if err := recordsFile.Truncate(j.RecordsSize); err != nil {
+			return false, fmt.Errorf("truncating records file: %v", err)
+		} else if err := recordsFile.Sync(); err != nil {
+			return false, fmt.Errorf("sync records file: %v", err)
+		}
+		if err := hashesFile.Truncate(j.HashesSize); err != nil {
+			return false, fmt.Errorf("truncating hashes file: %v", err)
+		} else if err := hashesFile.Sync(); err != nil {
+			return false, fmt.Errorf("sync hashes file: %v", err)
+		}
+		if err := os.RemoveAll(j.Tmpdir); err != nil {
+			slog.Error("journal: removing tmpdir after undoing append", "err", err, "tmpdir", j.Tmpdir)
+		}
+		slog.Warn("journal: undid incomplete append", attrs...)
+	} else {
+		return false, fmt.Errorf("records file of %d bytes and hashes file of %d bytes do not match journal for record %d, expected %d or %d bytes for records and %d to %d bytes for hashes", recordsSize, hashesSize, j.RecordNumber, j.RecordsSize, newRecordsSize, j.HashesSize, newHashesSize)
+	}
+
+	if err := clearSumJournal(); err != nil {
+		return completed, err
+	}
+	return completed, nil
+}
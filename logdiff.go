@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Maximum size of the table for finding the longest common subsequence of the
+// differing lines of two logs, to bound memory and cpu for large logs.
+const maxDiffCells = 4 * 1024 * 1024
+
+var errDiffTooLarge = errors.New("logs too large to diff")
+
+// serveLogDiff serves a unified diff of the build logs of two builds, for the
+// admin listener. For finding the cause of nondeterministic builds, or new
+// compile errors between versions or toolchains. Builds are passed in query
+// parameters "a" and "b", as record number or as build path like
+// <module>@<version>/<dir>/<goos>-<goarch>-<goversion>. If the logs are too large
+// to diff, both logs are returned.
+func serveLogDiff(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "405 - Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	aparam, bparam := r.FormValue("a"), r.FormValue("b")
+	if aparam == "" || bparam == "" {
+		http.Error(w, "400 - Bad Request - missing a or b", http.StatusBadRequest)
+		return
+	}
+
+	readLog := func(s string) (buildSpec, string, error) {
+		xbs, err := logDiffBuildSpec(r.Context(), s)
+		if err != nil {
+			return xbs, "", err
+		}
+		buf, err := readGzipFile(xbs.storeKey(), "log.gz")
+		if err != nil && errors.Is(err, fs.ErrNotExist) {
+			return xbs, "", fmt.Errorf("%w: no build log for %s", errNotExist, xbs)
+		} else if err != nil {
+			return xbs, "", fmt.Errorf("%w: reading log.gz: %v", errServer, err)
+		}
+		return xbs, string(buf), nil
+	}
+	abs, alog, err := readLog(aparam)
+	if err != nil {
+		failf(w, "build a: %w", err)
+		return
+	}
+	bbs, blog, err := readLog(bparam)
+	if err != nil {
+		failf(w, "build b: %w", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if alog == blog {
+		fmt.Fprintln(w, "build logs are identical")
+		return
+	}
+	l, err := diffLines(splitLines(alog), splitLines(blog))
+	if err != nil {
+		fmt.Fprintf(w, "%v, both logs follow\n\n# %s\n%s\n# %s\n%s", err, abs, alog, bbs, blog)
+		return
+	}
+	fmt.Fprint(w, unifiedDiff(abs.String(), bbs.String(), l))
+}
+
+// logDiffBuildSpec parses s as record number or build path.
+func logDiffBuildSpec(ctx context.Context, s string) (buildSpec, error) {
+	if num, err := strconv.ParseInt(s, 10, 64); err == nil {
+		records, err := serverOps{}.ReadRecords(ctx, num, 1)
+		if err != nil {
+			return buildSpec{}, fmt.Errorf("%w: reading record %d: %v", errNotExist, num, err)
+		}
+		br, err := parseRecord(records[0])
+		if err != nil {
+			return buildSpec{}, fmt.Errorf("%w: parsing record %d: %v", errServer, num, err)
+		}
+		return br.buildSpec, nil
+	}
+	s = strings.TrimPrefix(s, "/")
+	if !strings.HasSuffix(s, "/") {
+		s += "/"
+	}
+	bs, err := parseBuildSpec(s)
+	if err != nil {
+		return bs, fmt.Errorf("%w: parsing build path: %v", errBadModule, err)
+	}
+	return bs, nil
+}
+
+func splitLines(s string) []string {
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// diffLine is a line of a diff, with kind ' ' for a line in both a and b, '-' for
+// a line only in a, '+' for a line only in b.
+type diffLine struct {
+	kind byte
+	text string
+}
+
+// diffLines returns the lines of a and b, with the changes from a to b marked,
+// based on the longest common subsequence of lines.
+func diffLines(a, b []string) ([]diffLine, error) {
+	// Logs of similar builds mostly have the same start and end.
+	var p, s int
+	for p < len(a) && p < len(b) && a[p] == b[p] {
+		p++
+	}
+	for s < len(a)-p && s < len(b)-p && a[len(a)-1-s] == b[len(b)-1-s] {
+		s++
+	}
+	am, bm := a[p:len(a)-s], b[p:len(b)-s]
+	n, m := len(am), len(bm)
+	if (n+1)*(m+1) > maxDiffCells {
+		return nil, errDiffTooLarge
+	}
+
+	// Length of the longest common subsequence of am[i:] and bm[j:].
+	lcs := make([]int32, (n+1)*(m+1))
+	at := func(i, j int) int32 {
+		return lcs[i*(m+1)+j]
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if am[i] == bm[j] {
+				lcs[i*(m+1)+j] = at(i+1, j+1) + 1
+			} else {
+				lcs[i*(m+1)+j] = max(at(i+1, j), at(i, j+1))
+			}
+		}
+	}
+
+	l := make([]diffLine, 0, len(a)+m)
+	for _, t := range a[:p] {
+		l = append(l, diffLine{' ', t})
+	}
+	var i, j int
+	for i < n || j < m {
+		if i < n && j < m && am[i] == bm[j] {
+			l = append(l, diffLine{' ', am[i]})
+			i++
+			j++
+		} else if i < n && (j == m || at(i+1, j) >= at(i, j+1)) {
+			l = append(l, diffLine{'-', am[i]})
+			i++
+		} else {
+			l = append(l, diffLine{'+', bm[j]})
+			j++
+		}
+	}
+	for _, t := range a[len(a)-s:] {
+		l = append(l, diffLine{' ', t})
+	}
+	return l, nil
+}
+
+// unifiedDiff formats the diff lines as unified diff, with 3 lines of context.
+func unifiedDiff(aname, bname string, l []diffLine) string {
+	const context = 3
+
+	// Number of lines of a and b before each diff line.
+	na := make([]int, len(l)+1)
+	nb := make([]int, len(l)+1)
+	for k, dl := range l {
+		na[k+1], nb[k+1] = na[k], nb[k]
+		if dl.kind != '+' {
+			na[k+1]++
+		}
+		if dl.kind != '-' {
+			nb[k+1]++
+		}
+	}
+	// First line of a hunk, 1-based, or the line before an empty hunk.
+	hunkStart := func(n0, count int) int {
+		if count == 0 {
+			return n0
+		}
+		return n0 + 1
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", aname, bname)
+	for start := 0; ; {
+		c := start
+		for c < len(l) && l[c].kind == ' ' {
+			c++
+		}
+		if c == len(l) {
+			break
+		}
+		h0 := max(start, c-context)
+
+		// Merge changes separated by few unchanged lines into a single hunk.
+		end := c
+		for {
+			for end < len(l) && l[end].kind != ' ' {
+				end++
+			}
+			next := end
+			for next < len(l) && l[next].kind == ' ' {
+				next++
+			}
+			if next == len(l) || next-end > 2*context {
+				break
+			}
+			end = next
+		}
+		h1 := min(len(l), end+context)
+
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", hunkStart(na[h0], na[h1]-na[h0]), na[h1]-na[h0], hunkStart(nb[h0], nb[h1]-nb[h0]), nb[h1]-nb[h0])
+		for _, dl := range l[h0:h1] {
+			sb.WriteByte(dl.kind)
+			sb.WriteString(dl.text)
+			sb.WriteByte('\n')
+		}
+		start = h1
+	}
+	return sb.String()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLogDiff(t *testing.T) {
+	// Lines "1" to "n".
+	numbered := func(n int) []string {
+		var l []string
+		for i := 1; i <= n; i++ {
+			l = append(l, fmt.Sprint(i))
+		}
+		return l
+	}
+	// Lines "1" to "n", with the lines at the 1-based numbers in changed suffixed
+	// with "x".
+	changed := func(n int, changed ...int) []string {
+		l := numbered(n)
+		for _, i := range changed {
+			l[i-1] += "x"
+		}
+		return l
+	}
+	lines := func(s ...string) string {
+		return strings.Join(s, "\n") + "\n"
+	}
+
+	const header = "--- a\n+++ b\n"
+	tests := []struct {
+		name string
+		a, b []string
+		exp  string
+	}{
+		{"empty", nil, nil, header},
+		{"identical", numbered(5), numbered(5), header},
+		{"insert into empty", nil, []string{"x", "y"}, header + lines("@@ -0,0 +1,2 @@", "+x", "+y")},
+		{"delete all", []string{"x", "y"}, nil, header + lines("@@ -1,2 +0,0 @@", "-x", "-y")},
+		{
+			"insert with context",
+			numbered(10),
+			append(append(numbered(5), "new"), numbered(10)[5:]...),
+			header + lines("@@ -3,6 +3,7 @@", " 3", " 4", " 5", "+new", " 6", " 7", " 8"),
+		},
+		{
+			"delete with context",
+			numbered(10),
+			append(numbered(4), numbered(10)[5:]...),
+			header + lines("@@ -2,7 +2,6 @@", " 2", " 3", " 4", "-5", " 6", " 7", " 8"),
+		},
+		{
+			// Changes separated by 2*3 unchanged lines are in a single hunk.
+			"hunks merged",
+			numbered(20),
+			changed(20, 2, 9),
+			header + lines("@@ -1,12 +1,12 @@", " 1", "-2", "+2x", " 3", " 4", " 5", " 6", " 7", " 8", "-9", "+9x", " 10", " 11", " 12"),
+		},
+		{
+			// One more unchanged line, and the changes are in separate hunks.
+			"hunks separate",
+			numbered(20),
+			changed(20, 2, 10),
+			header + lines("@@ -1,5 +1,5 @@", " 1", "-2", "+2x", " 3", " 4", " 5") + lines("@@ -7,7 +7,7 @@", " 7", " 8", " 9", "-10", "+10x", " 11", " 12", " 13"),
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			l, err := diffLines(tc.a, tc.b)
+			if err != nil {
+				t.Fatalf("diff: %v", err)
+			}
+			if s := unifiedDiff("a", "b", l); s != tc.exp {
+				t.Fatalf("got diff:\n%s\nexpected:\n%s", s, tc.exp)
+			}
+		})
+	}
+
+	if _, err := diffLines(numbered(3000), changed(3000, 1, 3000)); !errors.Is(err, errDiffTooLarge) {
+		t.Fatalf("diff of large logs, got err %v, expected errDiffTooLarge", err)
+	}
+}
@@ -19,11 +19,14 @@ import (
 
 func usage() {
 	log.Println("usage: gobuild config")
-	log.Println("       gobuild testconfig gobuild.conf")
+	log.Println("       gobuild testconfig [flags] gobuild.conf")
 	log.Println("       gobuild serve [flags] [gobuild.conf]")
 	log.Println("       gobuild genkey name")
 	log.Println("       gobuild get [flags] module[@version/package]")
-	log.Println("       gobuild sum < file")
+	log.Println("       gobuild sum [file ...]")
+	log.Println("       gobuild verify [flags] module[@version/package] file")
+	log.Println("       gobuild fetchsdk [flags] goversion")
+	log.Println("       gobuild verifylog [data-dir]")
 	flag.PrintDefaults()
 	os.Exit(2)
 }
@@ -49,13 +52,7 @@ func main() {
 			log.Fatalf("describing config: %v", err)
 		}
 	case "testconfig":
-		if len(args) != 1 {
-			usage()
-		}
-		if err := parseConfig(args[0], &config); err != nil {
-			log.Fatalf("parsing config file: %v", err)
-		}
-		log.Printf("config OK")
+		testconfig(args)
 	case "serve":
 		serve(args)
 	case "genkey":
@@ -72,19 +69,60 @@ func main() {
 		}
 	case "get":
 		get(args)
+	case "fetchsdk":
+		fetchsdk(args)
+	case "verifylog":
+		verifylog(args)
 	case "sum":
-		if len(args) != 0 {
-			usage()
+		if len(args) == 0 {
+			if sum, err := readerSum(os.Stdin); err != nil {
+				log.Fatalf("read: %v", err)
+			} else if _, err := fmt.Println(sum); err != nil {
+				log.Fatalf("write: %v", err)
+			}
+			return
+		}
+		// Like sha256sum, print a line per file, continuing after errors.
+		var failed bool
+		for _, p := range args {
+			sum, err := fileSum(p)
+			if err != nil {
+				log.Printf("%s: %v", p, err)
+				failed = true
+				continue
+			}
+			if _, err := fmt.Printf("%s  %s\n", sum, p); err != nil {
+				log.Fatalf("write: %v", err)
+			}
 		}
-		sha := sha256.New()
-		if _, err := io.Copy(sha, os.Stdin); err != nil {
-			log.Fatalf("read: %v", err)
-		} else if _, err := fmt.Println("0" + base64.RawURLEncoding.EncodeToString(sha.Sum(nil)[:20])); err != nil {
-			log.Fatalf("write: %v", err)
+		if failed {
+			os.Exit(1)
 		}
+	case "verify":
+		verify(args)
 	}
 }
 
+// fileSum returns the sum as used in the transparency log for the file at path.
+func fileSum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return readerSum(f)
+}
+
+// readerSum returns the sum as used in the transparency log for the data read
+// from r: the versioned raw-base64-url-encoded 20-byte prefix of the sha256.
+func readerSum(r io.Reader) (string, error) {
+	sha := sha256.New()
+	if _, err := io.Copy(sha, r); err != nil {
+		return "", err
+	}
+	return "0" + base64.RawURLEncoding.EncodeToString(sha.Sum(nil)[:20]), nil
+}
+
 func parseConfig(p string, c *Config) error {
 	if err := sconf.ParseFile(p, c); err != nil {
 		return err
@@ -107,5 +145,13 @@ func parseConfig(p string, c *Config) error {
 		}
 		c.BadClients[i] = cp
 	}
+
+	for _, ipnetstr := range c.TrustedProxies {
+		if _, ipnet, err := net.ParseCIDR(ipnetstr); err != nil {
+			return fmt.Errorf("parsing trusted proxy network %q: %v", ipnetstr, err)
+		} else {
+			c.trustedProxies = append(c.trustedProxies, *ipnet)
+		}
+	}
 	return nil
 }
@@ -24,10 +24,22 @@ var (
 			Buckets: []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2, 4, 8, 16, 32, 64, 128},
 		},
 	)
+	metricGoproxyListCacheHit = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "gobuild_goproxy_list_cache_hit_total",
+			Help: "Number of module version list lookups served from cache, including those waiting for an in-progress request.",
+		},
+	)
+	metricGoproxyListCacheMiss = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "gobuild_goproxy_list_cache_miss_total",
+			Help: "Number of module version list lookups that resulted in a request to the goproxy.",
+		},
+	)
 	metricGoproxyListErrors = promauto.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "gobuild_goproxy_list_errors_total",
-			Help: "Number of error reponses from goproxy for listing module versions, per http response code.",
+			Help: "Number of failed requests to goproxy for listing module versions, per attempt, by http response code, or \"error\" for connection errors.",
 		},
 		[]string{"code"},
 	)
@@ -66,6 +78,101 @@ var (
 			Help: "Number of errors due to requested package not being main.",
 		},
 	)
+	metricPackageDirMissingErrors = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "gobuild_package_dir_missing_errors_total",
+			Help: "Number of errors due to requested package directory not existing in module, not counting nested modules.",
+		},
+	)
+	metricNoGoFilesErrors = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "gobuild_no_go_files_errors_total",
+			Help: "Number of errors due to requested package directory not having Go files.",
+		},
+	)
+	metricToolchainTooOldErrors = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "gobuild_toolchain_too_old_errors_total",
+			Help: "Number of errors due to the module requiring a newer go toolchain than requested.",
+		},
+	)
+	metricLocalReplaceErrors = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "gobuild_local_replace_errors_total",
+			Help: "Number of errors due to go.mod of module having replace directives to local directories.",
+		},
+	)
+	metricBuildsCanceled = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "gobuild_builds_canceled_total",
+			Help: "Number of builds canceled because no clients were waiting for the result anymore.",
+		},
+	)
+	metricBuildFailures = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gobuild_build_failures_total",
+			Help: "Number of failed builds and build preparations, by category, e.g. module-not-found, not-main, cgo-required or compile-error.",
+		},
+		[]string{"category"},
+	)
+	metricFollowErrors = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "gobuild_follow_errors_total",
+			Help: "Number of failed attempts to add new records from the upstream transparency log when following, see FollowerUpstream.",
+		},
+	)
+	metricVetErrors = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "gobuild_vet_errors_total",
+			Help: "Number of builds refused because go vet reported problems, with RunVet enabled.",
+		},
+	)
+	metricVersionTooOld = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "gobuild_version_too_old_total",
+			Help: "Number of builds refused because the module version is older than MaxModuleVersionAge.",
+		},
+	)
+	metricSDKFetches = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gobuild_sdk_fetch_total",
+			Help: "Number of attempted downloads of go toolchains, per goversion. Includes attempts that failed listing the releases at go.dev.",
+		},
+		[]string{"goversion"},
+	)
+	metricSDKFetchSuccess = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gobuild_sdk_fetch_success_total",
+			Help: "Number of go toolchains downloaded, verified and installed, per goversion.",
+		},
+		[]string{"goversion"},
+	)
+	metricSDKFetchErrors = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gobuild_sdk_fetch_errors_total",
+			Help: "Number of failed downloads of go toolchains, per goversion.",
+		},
+		[]string{"goversion"},
+	)
+	metricSDKFetchDuration = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "gobuild_sdk_fetch_duration_seconds",
+			Help:    "Duration of successful download, verification and extraction of a go toolchain in seconds.",
+			Buckets: []float64{0.5, 1, 2, 4, 8, 16, 32, 64, 128, 256, 512},
+		},
+	)
+	metricNestedModuleErrors = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "gobuild_nested_module_errors_total",
+			Help: "Number of errors due to requested package being in a nested module.",
+		},
+	)
+	metricModuleTooLargeErrors = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "gobuild_module_too_large_errors_total",
+			Help: "Number of builds refused due to the module exceeding the configured maximum size.",
+		},
+	)
 	metricCheckCgoErrors = promauto.NewCounter(
 		prometheus.CounterOpts{
 			Name: "gobuild_check_cgo_errors_total",
@@ -85,6 +192,60 @@ var (
 		},
 	)
 
+	metricEventStreamsActive = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "gobuild_event_streams_active",
+			Help: "Number of open SSE connections for build updates, of build pages and batch builds.",
+		},
+	)
+	metricEventStreamsRejected = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gobuild_event_streams_rejected_total",
+			Help: "Number of SSE connections for build updates refused due to config MaxEventStreams (reason total) or MaxEventStreamsPerIP (reason ip).",
+		},
+		[]string{"reason"},
+	)
+
+	metricBuildQueueLength = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "gobuild_build_queue_length",
+			Help: "Number of builds waiting in the queue for a build slot.",
+		},
+	)
+	metricBuildsActive = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "gobuild_builds_active",
+			Help: "Number of builds in progress.",
+		},
+	)
+	metricBuildsMax = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "gobuild_builds_max",
+			Help: "Maximum number of concurrent builds, from config MaxBuilds or the default based on number of CPUs.",
+		},
+	)
+	metricBuildsStarted = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "gobuild_builds_started_total",
+			Help: "Number of builds started.",
+		},
+	)
+	metricBuildQueueWait = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "gobuild_build_queue_wait_seconds",
+			Help:    "Time builds spent waiting in the queue before starting, in seconds.",
+			Buckets: []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2, 4, 8, 16, 32, 64, 128, 256},
+		},
+	)
+
+	metricPrepareDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "gobuild_prepare_duration_seconds",
+			Help:    "Duration of preparing a build in seconds: fetching module and dependencies, checking for main package and cgo. Compare with gobuild_compile_duration_seconds.",
+			Buckets: []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2, 4, 8, 16, 32, 64, 128, 256},
+		},
+		[]string{"goos", "goarch", "goversion"},
+	)
 	metricCompileDuration = promauto.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Name:    "gobuild_compile_duration_seconds",
@@ -109,6 +270,14 @@ var (
 		[]string{"goos", "goarch", "goversion"},
 	)
 
+	metricSelfVerify = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gobuild_selfverify_total",
+			Help: "Number of periodic rebuilds of past builds, by result: match, mismatch or error.",
+		},
+		[]string{"result"},
+	)
+
 	metricVerifyDuration = promauto.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Name:    "gobuild_verify_duration_seconds",
@@ -117,6 +286,21 @@ var (
 		},
 		[]string{"baseurl", "goos", "goarch", "goversion"},
 	)
+	metricVerifierUp = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gobuild_verifier_up",
+			Help: "Whether the last periodic probe of a verifier succeeded (1) or failed (0).",
+		},
+		[]string{"baseurl"},
+	)
+	metricVerifierProbeDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "gobuild_verifier_probe_duration_seconds",
+			Help:    "Duration of successful periodic probes of verifiers.",
+			Buckets: []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+		},
+		[]string{"baseurl"},
+	)
 	metricVerifyErrors = promauto.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "gobuild_verify_errors_total",
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/mod/module"
+)
+
+// clearModuleCache removes mod@version from the module cache in homedir: the
+// extracted module directory and the files for the version in the download
+// cache. The next build fetches the module again through the goproxy. For
+// recovering from a module cache in a bad state, e.g. after a partial download.
+// Returns the removed paths.
+func clearModuleCache(mod, version string) ([]string, error) {
+	modDir, err := moduleDir(mod, version)
+	if err != nil {
+		return nil, err
+	}
+	modPath, err := module.EscapePath(mod)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errBadModule, err)
+	}
+	modVersion, err := module.EscapeVersion(version)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errBadVersion, err)
+	}
+	downloadDir := filepath.Join(homedir, "go", "pkg", "mod", "cache", "download", filepath.Clean(modPath), "@v")
+
+	var removed []string
+	if _, err := os.Stat(modDir); err == nil {
+		// The go command makes the module directory read-only.
+		if err := makeWritable(modDir); err != nil {
+			return removed, fmt.Errorf("%w: making module directory writable: %v", errServer, err)
+		}
+		if err := os.RemoveAll(modDir); err != nil {
+			return removed, fmt.Errorf("%w: removing module directory: %v", errServer, err)
+		}
+		removed = append(removed, modDir)
+	} else if !os.IsNotExist(err) {
+		return removed, fmt.Errorf("%w: checking module directory: %v", errServer, err)
+	}
+
+	for _, ext := range []string{".info", ".mod", ".zip", ".ziphash", ".partial", ".lock"} {
+		p := filepath.Join(downloadDir, modVersion+ext)
+		if err := os.Remove(p); err == nil {
+			removed = append(removed, p)
+		} else if !os.IsNotExist(err) {
+			return removed, fmt.Errorf("%w: removing file from download cache: %v", errServer, err)
+		}
+	}
+
+	moduleSizes.Lock()
+	delete(moduleSizes.sizes, modDir)
+	moduleSizes.Unlock()
+
+	for _, p := range removed {
+		slog.Info("removed from module cache", "module", mod, "version", version, "path", p)
+	}
+	return removed, nil
+}
+
+// makeWritable makes the directories in dir writable, so their files can be
+// removed.
+func makeWritable(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.Chmod(path, 0755)
+		}
+		return nil
+	})
+}
+
+// serveClearModuleCache removes a module version from the module cache, for the
+// admin listener. The module and version are passed in the "module" and
+// "version" query parameters, the request must be a POST.
+func serveClearModuleCache(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "405 - Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	mod := r.FormValue("module")
+	version := r.FormValue("version")
+	if mod == "" || version == "" {
+		http.Error(w, "400 - Bad Request - missing module or version", http.StatusBadRequest)
+		return
+	}
+
+	removed, err := clearModuleCache(mod, version)
+	if err != nil {
+		slog.Error("clearing module from module cache", "err", err, "module", mod, "version", version, "removed", removed)
+		failf(w, "clearing module cache: %w", err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if len(removed) == 0 {
+		fmt.Fprintf(w, "%s@%s not in module cache\n", mod, version)
+		return
+	}
+	for _, p := range removed {
+		fmt.Fprintln(w, p)
+	}
+}
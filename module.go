@@ -1,10 +1,13 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -36,14 +39,18 @@ func serveModules(w http.ResponseWriter, r *http.Request) {
 	}
 
 	modDir, getOutput, err := ensureModule(goversion.String(), gobin, mod, info.Version)
-	if err != nil {
+	var perr modulePathError
+	if errors.As(err, &perr) {
+		serveModulePathError(w, mod, info.Version, perr)
+		return
+	} else if err != nil {
 		failf(w, "error fetching module from goproxy: %w\n\n# output from go get:\n%s", err, string(getOutput))
 		return
 	}
 
 	goos, goarch := autodetectTarget(r)
 
-	bs := buildSpec{mod, info.Version, "", goos, goarch, goversion.String(), false}
+	bs := buildSpec{mod, info.Version, "", goos, goarch, goversion.String(), false, false, false, false, "", "", ""}
 
 	mainDirs, err := listMainPackages(goversion, gobin, modDir)
 	if err != nil {
@@ -59,39 +66,83 @@ func serveModules(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	type mainPkg struct {
-		Link string
-		Name string
-	}
-	mainPkgs := []mainPkg{}
-	for _, md := range mainDirs {
-		bs.Dir = "/" + filepath.ToSlash(md)
-		link := request{bs, "", pageIndex}.link()
+	// Check for existing builds concurrently, modules can have many commands and
+	// each check touches the file system.
+	mainPkgs := make([]mainPkg, len(mainDirs))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, 8)
+	for i, md := range mainDirs {
+		pbs := bs
+		pbs.Dir = "/" + filepath.ToSlash(md)
+		link := request{pbs, "", pageIndex}.link()
 		if md == "" {
 			md = "/"
 		}
-		mainPkgs = append(mainPkgs, mainPkg{link, md})
-	}
-	args := struct {
-		Favicon         string
-		Module          string
-		Version         string
-		Mains           []mainPkg
-		GobuildVersion  string
-		GobuildPlatform string
-	}{
-		"/favicon.ico",
-		bs.Mod,
-		bs.Version,
-		mainPkgs,
-		gobuildVersion,
-		gobuildPlatform,
+		mainPkgs[i] = mainPkg{link, md, false}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			mainPkgs[i].Success = resultFileExists(pbs.storeKey(), "recordnumber")
+		}(i)
+	}
+	wg.Wait()
+	args := moduleArgs{
+		Favicon:         "/favicon.ico",
+		Module:          bs.Mod,
+		Version:         bs.Version,
+		Mains:           mainPkgs,
+		GobuildVersion:  gobuildVersion,
+		GobuildPlatform: gobuildPlatform,
+	}
+	if len(mainDirs) <= maxArchiveMains {
+		args.ArchiveLink = archiveLink(bs)
+		args.ArchiveTarget = bs.Goos + "/" + bs.Goarch
 	}
 	if err := moduleTemplate.Execute(w, args); err != nil {
 		failf(w, "%w: executing template: %v", errServer, err)
 	}
 }
 
+type mainPkg struct {
+	Link    string
+	Name    string
+	Success bool // Whether a build exists for the autodetected target and latest goversion.
+}
+
+type moduleArgs struct {
+	Favicon         string
+	Module          string
+	Version         string
+	Mains           []mainPkg
+	ArchiveLink     string           // Zip file with all main packages, empty if there are too many.
+	ArchiveTarget   string           // goos/goarch of ArchiveLink.
+	PathError       *modulePathError // If set, the module cannot be built, Mains is empty.
+	GobuildVersion  string
+	GobuildPlatform string
+}
+
+// serveModulePathError serves the module page explaining that the module
+// declares another module path in its go.mod than requested.
+func serveModulePathError(w http.ResponseWriter, mod, version string, perr modulePathError) {
+	args := moduleArgs{
+		Favicon:         "/favicon-error.png",
+		Module:          mod,
+		Version:         version,
+		PathError:       &perr,
+		GobuildVersion:  gobuildVersion,
+		GobuildPlatform: gobuildPlatform,
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusBadRequest)
+	if err := moduleTemplate.Execute(w, args); err != nil {
+		slog.Error("executing template for module path error", "err", err)
+	}
+}
+
 func listMainPackages(goversion goVersion, gobin string, modDir string) ([]string, error) {
 	goproxy := true
 	cgo := true
@@ -104,7 +155,9 @@ func listMainPackages(goversion goVersion, gobin string, modDir string) ([]strin
 	cmd := makeCommand(goversion.String(), goproxy, modDir, cgo, nil, argv...)
 	stderr := &strings.Builder{}
 	cmd.Stderr = stderr
+	release := acquireCommand()
 	output, err := cmd.Output()
+	release()
 	if err != nil {
 		metricListPackageErrors.Inc()
 		return nil, fmt.Errorf("%w\n\n# output from go list:\n%s\n\nstderr:\n%s", err, output, stderr.String())
@@ -160,14 +213,21 @@ func autodetectTarget(r *http.Request) (goos, goarch string) {
 		if m1 {
 			m += t.Goarch
 		}
-		if len(m) > len(match) {
+		// Browsers on Apple Silicon still claim "Intel Mac OS X", without an architecture
+		// we recognize. Most Macs in use are arm64 nowadays, so prefer it on a tie.
+		preferArm64 := !m1 && t.Goos == "darwin" && t.Goarch == "arm64"
+		if len(m) > len(match) || len(m) == len(match) && preferArm64 {
 			goos, goarch = t.Goos, t.Goarch
 			match = m
 		}
 	}
 	if goos == "" || goarch == "" {
-		t := targets.get()[0]
-		goos, goarch = t.Goos, t.Goarch
+		if config.DefaultTarget != "" {
+			goos, goarch, _ = strings.Cut(config.DefaultTarget, "/")
+		} else {
+			t := targets.get()[0]
+			goos, goarch = t.Goos, t.Goarch
+		}
 	}
 	return
 }
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Index of module paths that have been built successfully, for suggesting modules
+// on the home page. Only unique module paths are stored.
+var moduleIndex struct {
+	sync.Mutex
+	paths []string // Sorted.
+}
+
+// initModuleIndex reads all records from the transparency log and adds their
+// module paths to the index. Called at startup, in the background.
+func initModuleIndex() {
+	n, err := treeSize()
+	if err != nil {
+		slog.Error("reading number of records for module index", "err", err)
+		return
+	}
+	for first := int64(0); first < n; first += 1000 {
+		records, err := serverOps{}.ReadRecords(context.Background(), first, min(n-first, 1000))
+		if err != nil {
+			slog.Error("reading records for module index", "err", err)
+			return
+		}
+		for _, record := range records {
+			br, err := parseRecord(record)
+			if err != nil {
+				slog.Error("parsing record for module index", "err", err)
+				return
+			}
+			addModuleIndex(br.Mod)
+		}
+	}
+}
+
+// addModuleIndex adds mod to the index of modules, if not already present.
+func addModuleIndex(mod string) {
+	moduleIndex.Lock()
+	defer moduleIndex.Unlock()
+	if i, found := slices.BinarySearch(moduleIndex.paths, mod); !found {
+		moduleIndex.paths = slices.Insert(moduleIndex.paths, i, mod)
+	}
+}
+
+// searchModules returns up to max module paths containing q, case-insensitive.
+// Modules starting with q are returned first.
+func searchModules(q string, max int) []string {
+	q = strings.ToLower(q)
+
+	moduleIndex.Lock()
+	defer moduleIndex.Unlock()
+
+	var prefix, other []string
+	for _, mod := range moduleIndex.paths {
+		lmod := strings.ToLower(mod)
+		if strings.HasPrefix(lmod, q) {
+			prefix = append(prefix, mod)
+			if len(prefix) >= max {
+				break
+			}
+		} else if len(other) < max && strings.Contains(lmod, q) {
+			other = append(other, mod)
+		}
+	}
+	l := append(prefix, other...)
+	if len(l) > max {
+		l = l[:max]
+	}
+	return l
+}
+
+// serveModuleSearch returns a JSON array with module paths matching query
+// parameter "q", for autocompletion on the home page.
+func serveModuleSearch(w http.ResponseWriter, r *http.Request) {
+	defer observePage("modulesearch", time.Now())
+
+	if r.Method != "GET" {
+		http.Error(w, "405 - Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := strings.TrimPrefix(strings.TrimPrefix(r.FormValue("q"), "https://"), "http://")
+	l := []string{}
+	if len(q) >= 2 {
+		l = searchModules(q, 20)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	if err := json.NewEncoder(w).Encode(l); err != nil {
+		slog.Debug("writing module search response", "err", err)
+	}
+}
@@ -3,9 +3,14 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"strings"
 	"time"
+
+	"golang.org/x/mod/module"
 )
 
 type modVersion struct {
@@ -13,7 +18,27 @@ type modVersion struct {
 	Time    time.Time
 }
 
-func resolveModuleVersion(ctx context.Context, mod, version string) (mv *modVersion, rerr error) {
+var resolveFlights flights[*modVersion]
+
+// Maximum duration of a "go list" resolving a module version. Shared by all
+// callers waiting for it, so not tied to a single request.
+const resolveTimeout = time.Minute
+
+// resolveModuleVersion resolves version, e.g. "latest" or a commit hash, to a
+// module version through the goproxy. Concurrent calls for the same
+// module@version share a single "go list".
+func resolveModuleVersion(ctx context.Context, mod, version string) (*modVersion, error) {
+	// Hashes copied from repository web interfaces may be in upper case, the goproxy
+	// only knows lower case.
+	if isCommitHash(version) {
+		version = strings.ToLower(version)
+	}
+	return resolveFlights.doContext(ctx, mod+"@"+version, resolveTimeout, func(ctx context.Context) (*modVersion, error) {
+		return resolveModuleVersionOnce(ctx, mod, version)
+	})
+}
+
+func resolveModuleVersionOnce(ctx context.Context, mod, version string) (mv *modVersion, rerr error) {
 	t0 := time.Now()
 	defer func() {
 		metricGoproxyResolveVersionDuration.Observe(time.Since(t0).Seconds())
@@ -36,11 +61,19 @@ func resolveModuleVersion(ctx context.Context, mod, version string) (mv *modVers
 
 	const goproxy = true
 	const cgo = false
-	cmd := makeCommand(goversion.String(), goproxy, emptyDir, cgo, nil, gobin, "list", "-x", "-m", "-json", "--", mod+"@"+version)
+	cmd := makeCommandContext(ctx, goversion.String(), goproxy, emptyDir, cgo, nil, gobin, "list", "-x", "-m", "-json", "--", mod+"@"+version)
 	stderr := &strings.Builder{}
 	cmd.Stderr = stderr
+	release := acquireCommand()
 	output, err := cmd.Output()
+	release()
 	if err != nil {
+		if perr := parseModulePathError(stderr.String()); perr != nil {
+			return nil, perr
+		}
+		if s := stderr.String(); isCommitHash(version) && (strings.Contains(s, "unknown revision") || strings.Contains(s, "ambiguous")) {
+			return nil, fmt.Errorf("%w: commit %s not found in module %s, or the abbreviated commit hash is ambiguous; check the module path, or use the full commit hash (error output: %q)", errBadVersion, version, mod, s)
+		}
 		return nil, fmt.Errorf("resolving module version: %v (error output: %q)", err, stderr.String())
 	}
 
@@ -52,3 +85,96 @@ func resolveModuleVersion(ctx context.Context, mod, version string) (mv *modVers
 	}
 	return &info, nil
 }
+
+// sourceDateEpochEnv returns the SOURCE_DATE_EPOCH environment variable with the
+// time of mod@version, for builds with config.SourceDateEpoch. Returns an empty
+// string if the goproxy does not know the time.
+func sourceDateEpochEnv(ctx context.Context, mod, version string) (string, error) {
+	info, err := resolveModuleVersion(ctx, mod, version)
+	if err != nil {
+		return "", fmt.Errorf("resolving module version for time: %w", err)
+	}
+	if info.Time.IsZero() {
+		return "", nil
+	}
+	return fmt.Sprintf("SOURCE_DATE_EPOCH=%d", info.Time.Unix()), nil
+}
+
+// Maximum size of go.mod files fetched from the goproxy.
+const maxGoModSize = 4 * 1024 * 1024
+
+// fetchGoMod returns the go.mod file of mod@version from the goproxy.
+func fetchGoMod(ctx context.Context, mod, version string) ([]byte, error) {
+	modPath, err := module.EscapePath(mod)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errBadModule, err)
+	}
+	modVersion, err := module.EscapeVersion(version)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errBadVersion, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	u := fmt.Sprintf("%s%s/@v/%s.mod", config.GoProxy, modPath, modVersion)
+	mreq, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: preparing new http request: %v", errServer, err)
+	}
+	mreq.Header.Set("User-Agent", userAgent)
+	resp, err := http.DefaultClient.Do(mreq)
+	if err != nil {
+		return nil, fmt.Errorf("%w: http request: %v", errServer, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return nil, fmt.Errorf("go.mod for %s@%s %w at goproxy: %s", mod, version, errNotExist, resp.Status)
+	} else if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("%w: http response from goproxy: %v", errRemote, resp.Status)
+	}
+	buf, err := io.ReadAll(io.LimitReader(resp.Body, maxGoModSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading go.mod from goproxy: %v", errRemote, err)
+	} else if len(buf) > maxGoModSize {
+		return nil, fmt.Errorf("%w: go.mod from goproxy larger than %d bytes", errRemote, maxGoModSize)
+	}
+	return buf, nil
+}
+
+// isCommitHash returns whether version looks like a bare, possibly abbreviated,
+// git commit hash, e.g. from a "copy commit SHA" button, instead of a (pseudo)
+// version. The goproxy resolves commit hashes to pseudo-versions.
+func isCommitHash(version string) bool {
+	if len(version) < 7 || len(version) > 40 {
+		return false
+	}
+	for _, c := range version {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// errVersionTooOld is returned for builds of module versions published longer
+// than config.MaxModuleVersionAge ago, that were not explicitly requested.
+var errVersionTooOld = errors.New("module version too old")
+
+// checkVersionAge returns an error wrapping errVersionTooOld if
+// config.MaxModuleVersionAge is set and the module version of bs was published
+// before that, unless the request has query parameter "allowold".
+func checkVersionAge(r *http.Request, bs buildSpec) error {
+	if config.MaxModuleVersionAge <= 0 || r.URL.Query().Get("allowold") != "" {
+		return nil
+	}
+	info, err := resolveModuleVersion(r.Context(), bs.Mod, bs.Version)
+	if err != nil {
+		return err
+	}
+	// Time is not known for all versions, e.g. from older goproxies.
+	if info.Time.IsZero() || time.Since(info.Time) <= config.MaxModuleVersionAge {
+		return nil
+	}
+	metricVersionTooOld.Inc()
+	return fmt.Errorf("%w: %s@%s was published %s, this instance only starts builds for versions published in the last %s; to build this version anyway, add query parameter allowold=1 to the URL", errVersionTooOld, bs.Mod, info.Version, info.Time.UTC().Format("2006-01-02"), config.MaxModuleVersionAge)
+}
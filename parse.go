@@ -6,18 +6,25 @@ import (
 	"fmt"
 	"path"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 )
 
 type buildSpec struct {
-	Mod       string // E.g. github.com/mjl-/gobuild. Never starts or ends with slash, and is never empty.
-	Version   string
-	Dir       string // Always starts with slash. Never ends with slash unless "/".
-	Goos      string
-	Goarch    string
-	Goversion string
-	Stripped  bool
+	Mod        string // E.g. github.com/mjl-/gobuild. Never starts or ends with slash, and is never empty.
+	Version    string
+	Dir        string // Always starts with slash. Never ends with slash unless "/".
+	Goos       string
+	Goarch     string
+	Goversion  string
+	Stripped   bool
+	Race       bool   // Built with the race detector. Only for the host target, not verified by other instances.
+	Netgo      bool   // Built with build tags netgo and osusergo, for pure Go net and os/user.
+	Vendor     bool   // Built with -mod=vendor in the module directory, with dependencies from its vendor directory.
+	Tags       string // Build tags, comma-separated, sorted and unique, e.g. "netgo,purego". Empty for a regular build.
+	FIPS140    string // GOFIPS140 value, e.g. "latest" or "v1.0.0". Empty for a regular build.
+	Experiment string // GOEXPERIMENT value, e.g. "arenas". Empty for a regular build.
 }
 
 // filename to store the binary as. With .exe for windows.
@@ -34,6 +41,17 @@ func (bs buildSpec) filename() string {
 	return name
 }
 
+// filename with goos and goarch added, for storing binaries for multiple targets
+// in a single directory.
+func (bs buildSpec) targetFilename() string {
+	name := strings.TrimSuffix(bs.filename(), ".exe")
+	var ext string
+	if bs.Goos == "windows" {
+		ext = ".exe"
+	}
+	return fmt.Sprintf("%s-%s-%s%s", name, bs.Goos, bs.Goarch, ext)
+}
+
 // Variant of Dir that is either empty or otherwise has no leading but does have a
 // trailing slash. Makes it easier to make some clean path by simple concatenation.
 // Returns eg "" or "cmd/x/".
@@ -47,11 +65,222 @@ func (bs buildSpec) appendDir() string {
 // Used in transparency log lookups, and used to calculate directory where build results are stored.
 // Can be parsed with parseBuildSpec.
 func (bs buildSpec) String() string {
-	var variant string
+	return fmt.Sprintf("%s@%s/%s%s-%s-%s%s/", bs.Mod, bs.Version, bs.appendDir(), bs.Goos, bs.Goarch, bs.Goversion, bs.variantSuffix())
+}
+
+// Suffix for goos-goarch-goversion, e.g. "", "-stripped", "-race", "-netgo", "-vendor",
+// "-tags.purego,timetzdata", "-fips140.v1.0.0" or "-stripped-goexperiment.arenas".
+func (bs buildSpec) variantSuffix() string {
+	var s string
+	if bs.Stripped {
+		s += "-stripped"
+	}
+	if bs.Race {
+		s += "-race"
+	}
+	if bs.Netgo {
+		s += "-netgo"
+	}
+	if bs.Vendor {
+		s += "-vendor"
+	}
+	if bs.Tags != "" {
+		s += "-tags." + bs.Tags
+	}
+	if bs.FIPS140 != "" {
+		s += "-fips140." + bs.FIPS140
+	}
+	if bs.Experiment != "" {
+		s += "-goexperiment." + bs.Experiment
+	}
+	return s
+}
+
+// Variant field of the record in the transparency log: a comma-separated list
+// of "stripped", "race", "netgo", "vendor", "tag.<tag>" for each build tag and
+// "fips140.<value>", possibly empty.
+func (bs buildSpec) recordVariant() string {
+	var l []string
+	if bs.Stripped {
+		l = append(l, "stripped")
+	}
+	if bs.Race {
+		l = append(l, "race")
+	}
+	if bs.Netgo {
+		l = append(l, "netgo")
+	}
+	if bs.Vendor {
+		l = append(l, "vendor")
+	}
+	for _, tag := range bs.tagList() {
+		l = append(l, "tag."+tag)
+	}
+	if bs.FIPS140 != "" {
+		l = append(l, "fips140."+bs.FIPS140)
+	}
+	return strings.Join(l, ",")
+}
+
+// Flags for the go command for a build of bs, in addition to -x and -v.
+func (bs buildSpec) buildFlags() []string {
+	ldflags := "-buildid="
 	if bs.Stripped {
-		variant = "-stripped"
+		ldflags += " -s"
+	}
+	l := []string{"-trimpath", "-ldflags=" + ldflags}
+	if bs.Race {
+		l = append(l, "-race")
+	}
+	if bs.Vendor {
+		l = append(l, "-mod=vendor")
+	}
+	return append(l, bs.tagsFlags()...)
+}
+
+// Flags with the build tags for go commands, also for listing and vetting
+// packages, so they see the same files as the build.
+func (bs buildSpec) tagsFlags() []string {
+	tags := bs.goTags()
+	if tags == "" {
+		return nil
+	}
+	return []string{"-tags=" + tags}
+}
+
+// goTags returns the comma-separated build tags for the go command, including
+// those of the netgo variant. Empty if none.
+func (bs buildSpec) goTags() string {
+	l := bs.tagList()
+	if bs.Netgo {
+		l = append(l, netgoTags...)
+	}
+	slices.Sort(l)
+	return strings.Join(slices.Compact(l), ",")
+}
+
+// tagList returns the build tags of bs, nil if none.
+func (bs buildSpec) tagList() []string {
+	if bs.Tags == "" {
+		return nil
+	}
+	return strings.Split(bs.Tags, ",")
+}
+
+// cgoEnabled returns whether cgo must be enabled for a build of bs. The race
+// detector requires cgo on most platforms. Packages using cgo themselves are
+// still refused.
+func (bs buildSpec) cgoEnabled() bool {
+	return bs.Race
+}
+
+// Environment variables for the go command for a build of bs.
+func (bs buildSpec) env() []string {
+	l := []string{
+		"GOOS=" + bs.Goos,
+		"GOARCH=" + bs.Goarch,
+	}
+	if bs.Experiment != "" {
+		l = append(l, "GOEXPERIMENT="+bs.Experiment)
+	}
+	if bs.FIPS140 != "" {
+		l = append(l, "GOFIPS140="+bs.FIPS140)
+	}
+	return l
+}
+
+// validFIPS140 returns whether s looks like a GOFIPS140 value other than "off",
+// e.g. "latest" or "v1.0.0".
+func validFIPS140(s string) bool {
+	if s == "" || s == "off" {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// validExperiment returns whether s looks like a GOEXPERIMENT value: lower case
+// experiment names, optionally negated with "no" and separated by commas.
+func validExperiment(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, e := range strings.Split(s, ",") {
+		if e == "" {
+			return false
+		}
+		for _, c := range e {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// canonicalExperiment returns the comma-separated GOEXPERIMENT value s sorted and
+// without duplicates, so a set of experiments has a single build spec. An
+// experiment that is both enabled and negated is an error, its outcome would
+// depend on the order.
+func canonicalExperiment(s string) (string, error) {
+	if !validExperiment(s) {
+		return "", fmt.Errorf("bad goexperiment %q", s)
+	}
+	l := strings.Split(s, ",")
+	for _, e := range l {
+		if slices.Contains(l, "no"+e) {
+			return "", fmt.Errorf("goexperiment %q both enabled and disabled", e)
+		}
+	}
+	slices.Sort(l)
+	return strings.Join(slices.Compact(l), ","), nil
+}
+
+// validTag returns whether s is a build tag as gobuild allows them in URLs and
+// records: letters, digits, underscores and dots.
+func validTag(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// Build tags set by the netgo variant. They cannot be requested as build tags,
+// so a binary has only one build spec.
+var netgoTags = []string{"netgo", "osusergo"}
+
+// checkNetgoTags returns an error if the comma-separated tags contain a tag of
+// the netgo variant.
+func checkNetgoTags(tags string) error {
+	for _, tag := range strings.Split(tags, ",") {
+		if slices.Contains(netgoTags, tag) {
+			return fmt.Errorf("build tag %q is only set through the netgo variant", tag)
+		}
+	}
+	return nil
+}
+
+// canonicalTags returns the comma-separated build tags in s, sorted and without
+// duplicates, or an error for an invalid tag. Tags can be separated by commas
+// or spaces.
+func canonicalTags(s string) (string, error) {
+	l := strings.FieldsFunc(s, func(c rune) bool { return c == ',' || c == ' ' })
+	for _, tag := range l {
+		if !validTag(tag) {
+			return "", fmt.Errorf("bad build tag %q", tag)
+		}
 	}
-	return fmt.Sprintf("%s@%s/%s%s-%s-%s%s/", bs.Mod, bs.Version, bs.appendDir(), bs.Goos, bs.Goarch, bs.Goversion, variant)
+	slices.Sort(l)
+	return strings.Join(slices.Compact(l), ","), nil
 }
 
 // GOBIN-relative name of file created by "go get". Used as key to prevent
@@ -71,26 +300,30 @@ func (bs buildSpec) outputPath() string {
 	return fmt.Sprintf("%s-%s/%s", bs.Goos, bs.Goarch, name)
 }
 
-// Local directory where results are stored, both successful and failed.
-// Directories of failed builds can be removed, for a retry.
-func (bs buildSpec) storeDir() string {
+// Key in the result store where results are stored, both successful and failed.
+// Results of failed builds can be removed, for a retry.
+func (bs buildSpec) storeKey() string {
 	sha := sha256.Sum256([]byte(bs.String()))
 	sum := base64.RawURLEncoding.EncodeToString(sha[:20])
-	return filepath.Join(resultDir, sum[:1], sum)
+	return sum[:1] + "/" + sum
 }
 
 type buildResult struct {
 	buildSpec
 	Filesize int64
 	Sum      string
+
+	// Full sha256 of the binary in hex. Only present in records of format version 2,
+	// see packRecord.
+	SHA256 string `json:",omitempty"`
 }
 
-// Parse string of the form: module@version/dir/goos-goarch-goversion[-stripped]/.
+// Parse string of the form: module@version/dir/goos-goarch-goversion[-stripped][-race][-netgo][-vendor][-tags.<tags>][-fips140.<value>][-goexperiment.<experiment>]/.
 // String generates strings that parseBuildSpec parses.
 func parseBuildSpec(s string) (buildSpec, error) {
 	bs := buildSpec{}
 
-	// First peel off goos-goarch-goversion[-stripped]/ from end.
+	// First peel off goos-goarch-goversion[-stripped][-race][-netgo][-vendor][-tags.<tags>][-fips140.<value>][-goexperiment.<experiment>]/ from end.
 	if !strings.HasSuffix(s, "/") {
 		return bs, fmt.Errorf("missing trailing slash")
 	}
@@ -100,8 +333,8 @@ func parseBuildSpec(s string) (buildSpec, error) {
 	s = s[:len(s)-len(last)]
 
 	t = strings.Split(last, "-")
-	if len(t) != 3 && len(t) != 4 {
-		return bs, fmt.Errorf("bad goos-goarch-goversion[-stripped] %q", last)
+	if len(t) < 3 || len(t) > 10 {
+		return bs, fmt.Errorf("bad goos-goarch-goversion[-stripped][-race][-netgo][-vendor][-tags.<tags>][-fips140.<value>][-goexperiment.<experiment>] %q", last)
 	}
 	bs.Goos = t[0]
 	bs.Goarch = t[1]
@@ -109,11 +342,53 @@ func parseBuildSpec(s string) (buildSpec, error) {
 		return bs, fmt.Errorf("unsupported target %s/%s", bs.Goos, bs.Goarch)
 	}
 	bs.Goversion = t[2]
-	if len(t) == 4 {
-		if t[3] != "stripped" {
-			return bs, fmt.Errorf("unrecognized variant %s", t[3])
-		}
+	variants := t[3:]
+	if len(variants) > 0 && variants[0] == "stripped" {
 		bs.Stripped = true
+		variants = variants[1:]
+	}
+	if len(variants) > 0 && variants[0] == "race" {
+		bs.Race = true
+		variants = variants[1:]
+	}
+	if len(variants) > 0 && variants[0] == "netgo" {
+		bs.Netgo = true
+		variants = variants[1:]
+	}
+	if len(variants) > 0 && variants[0] == "vendor" {
+		bs.Vendor = true
+		variants = variants[1:]
+	}
+	if len(variants) > 0 && strings.HasPrefix(variants[0], "tags.") {
+		tags := strings.TrimPrefix(variants[0], "tags.")
+		if ctags, err := canonicalTags(tags); err != nil || ctags != tags {
+			return bs, fmt.Errorf("bad build tags %q, must be comma-separated, sorted and unique", tags)
+		} else if err := checkNetgoTags(tags); err != nil {
+			return bs, err
+		}
+		bs.Tags = tags
+		variants = variants[1:]
+	}
+	if len(variants) > 0 && strings.HasPrefix(variants[0], "fips140.") {
+		fips := strings.TrimPrefix(variants[0], "fips140.")
+		if !validFIPS140(fips) {
+			return bs, fmt.Errorf("bad fips140 value %q", fips)
+		}
+		bs.FIPS140 = fips
+		variants = variants[1:]
+	}
+	if len(variants) > 0 {
+		exp, ok := strings.CutPrefix(variants[0], "goexperiment.")
+		if !ok || !validExperiment(exp) {
+			return bs, fmt.Errorf("unrecognized variant %s", variants[0])
+		} else if cexp, err := canonicalExperiment(exp); err != nil || cexp != exp {
+			return bs, fmt.Errorf("bad goexperiment %q, must be comma-separated, sorted and unique", exp)
+		}
+		bs.Experiment = exp
+		variants = variants[1:]
+	}
+	if len(variants) > 0 {
+		return bs, fmt.Errorf("unrecognized variant %s", variants[0])
 	}
 
 	t = strings.SplitN(s, "@", 2)
@@ -200,32 +475,85 @@ func parseRecord(data []byte) (*buildResult, error) {
 	}
 	msg = msg[:len(msg)-1]
 	t := strings.Split(msg, " ")
-	if len(t) != 8 && len(t) != 9 {
-		return nil, fmt.Errorf("bad record, got %d records, expected 8 or 9", len(t))
+	if len(t) < 8 || len(t) > 11 {
+		return nil, fmt.Errorf("bad record, got %d records, expected 8, 9, 10 or 11", len(t))
 	}
 	size, err := strconv.ParseInt(t[6], 10, 64)
 	if err != nil {
 		return nil, fmt.Errorf("bad filesize %s: %v", t[6], err)
 	}
-	var stripped bool
-	if len(t) == 9 {
-		switch t[8] {
-		case "":
-		case "stripped":
-			stripped = true
-		default:
-			return nil, fmt.Errorf("bad variant %s", t[8])
+	var stripped, race, netgo, vendor bool
+	var tags []string
+	var fips string
+	if len(t) >= 9 && t[8] != "" {
+		for _, v := range strings.Split(t[8], ",") {
+			switch v {
+			case "stripped":
+				stripped = true
+			case "race":
+				race = true
+			case "netgo":
+				netgo = true
+			case "vendor":
+				vendor = true
+			default:
+				if tag, ok := strings.CutPrefix(v, "tag."); ok && validTag(tag) {
+					tags = append(tags, tag)
+				} else if f, ok := strings.CutPrefix(v, "fips140."); ok && validFIPS140(f) && fips == "" {
+					fips = f
+				} else {
+					return nil, fmt.Errorf("bad variant %s", t[8])
+				}
+			}
+		}
+	}
+	var experiment string
+	if len(t) >= 10 && (t[9] != "" || len(t) == 10) {
+		if cexp, err := canonicalExperiment(t[9]); err != nil || cexp != t[9] {
+			return nil, fmt.Errorf("bad goexperiment %s", t[9])
+		}
+		experiment = t[9]
+	}
+	var sha string
+	if len(t) == 11 {
+		if !validSHA256(t[10]) {
+			return nil, fmt.Errorf("bad sha256 %s", t[10])
 		}
+		sha = t[10]
+	}
+	br := &buildResult{buildSpec{t[0], t[1], t[2], t[3], t[4], t[5], stripped, race, netgo, vendor, strings.Join(tags, ","), fips, experiment}, size, t[7], sha}
+	// Only one representation for each variant, records must be canonical.
+	if ctags, _ := canonicalTags(br.Tags); len(t) >= 9 && (br.recordVariant() != t[8] || ctags != br.Tags || checkNetgoTags(br.Tags) != nil) {
+		return nil, fmt.Errorf("bad variant %s", t[8])
 	}
-	br := &buildResult{buildSpec{t[0], t[1], t[2], t[3], t[4], t[5], stripped}, size, t[7]}
 	return br, nil
 }
 
-func (br buildResult) packRecord() ([]byte, error) {
-	var variant string
-	if br.Stripped {
-		variant = "stripped"
+// validSHA256 returns whether s is a full sha256 in lower case hex.
+func validSHA256(s string) bool {
+	if len(s) != 2*sha256.Size {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
 	}
+	return true
+}
+
+// packRecord returns the record for the transparency log. Records are lines with
+// space-separated fields. Format version 1 has 8 to 10 fields: module, version,
+// dir, goos, goarch, goversion, filesize, sum, an optional variant (empty, or a
+// comma-separated list of "stripped", "race", "netgo", "tag.<tag>" for each
+// build tag and "fips140.<value>", in that order, tags sorted) and an experiment
+// that is only present for experiment builds. Records with variant race, netgo,
+// tags or fips140 cannot be parsed by older gobuild versions.
+// Format version 2 has 11 fields: the variant and experiment are always present
+// (possibly empty), followed by the full sha256 of the binary in hex. Version 2
+// is only used when a full sha256 is set, older records stay as they are.
+func (br buildResult) packRecord() ([]byte, error) {
+	variant := br.recordVariant()
 	fields := []string{
 		br.Mod,
 		br.Version,
@@ -237,8 +565,18 @@ func (br buildResult) packRecord() ([]byte, error) {
 		br.Sum,
 		variant,
 	}
+	// Format version 1 only has the experiment field for experiments, keeping records
+	// of regular builds as before.
+	if br.SHA256 != "" {
+		if !validSHA256(br.SHA256) {
+			return nil, fmt.Errorf("bad sha256")
+		}
+		fields = append(fields, br.Experiment, br.SHA256)
+	} else if br.Experiment != "" {
+		fields = append(fields, br.Experiment)
+	}
 	for i, f := range fields {
-		if f == "" && i != 8 {
+		if f == "" && i != 8 && !(i == 9 && len(fields) == 11) {
 			return nil, fmt.Errorf("bad empty field %d", i)
 		}
 		for _, c := range f {
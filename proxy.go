@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+)
+
+// Proxies for outgoing http requests per destination, from config.GoProxyHTTPProxy
+// and config.GoReleasesHTTPProxy. Nil if not configured, in which case the
+// proxy from the environment (HTTPS_PROXY) is used.
+var goproxyHTTPProxy, goreleasesHTTPProxy *url.URL
+
+// Hosts for listing and fetching go toolchains. Downloads from go.dev are
+// redirected to dl.google.com.
+var goreleasesHosts = []string{"go.dev", "dl.google.com"}
+
+// parseHTTPProxy parses a proxy URL from the config.
+func parseHTTPProxy(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5" || u.Host == "" {
+		return nil, fmt.Errorf("proxy url must be of the form http://host:port, https://host:port or socks5://host:port")
+	}
+	return u, nil
+}
+
+// httpProxy returns the proxy for an outgoing http request, based on its
+// destination. Used for http.DefaultTransport, so it also applies to
+// goreleasesClient.
+func httpProxy(r *http.Request) (*url.URL, error) {
+	host := r.URL.Hostname()
+	if goproxyHTTPProxy != nil {
+		if u, err := url.Parse(config.GoProxy); err == nil && u.Hostname() == host {
+			return goproxyHTTPProxy, nil
+		}
+	}
+	if goreleasesHTTPProxy != nil && slices.Contains(goreleasesHosts, host) {
+		return goreleasesHTTPProxy, nil
+	}
+	return http.ProxyFromEnvironment(r)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRaceBuild(t *testing.T) {
+	host := runtime.GOOS + "-" + runtime.GOARCH
+	bs, err := parseBuildSpec("example.org/m@v1.0.0/cmd/x/" + host + "-go1.22.1-race/")
+	if err != nil {
+		t.Fatalf("parsing race build spec: %v", err)
+	}
+	if !bs.Race || bs.Stripped || bs.Dir != "/cmd/x" || bs.Goversion != "go1.22.1" {
+		t.Fatalf("unexpected build spec %#v", bs)
+	}
+	if s := bs.String(); s != "example.org/m@v1.0.0/cmd/x/"+host+"-go1.22.1-race/" {
+		t.Fatalf("build spec string %q", s)
+	}
+	if v := bs.recordVariant(); v != "race" {
+		t.Fatalf("record variant %q, expected race", v)
+	}
+	if !slices.Contains(bs.buildFlags(), "-race") {
+		t.Fatalf("build flags %v without -race", bs.buildFlags())
+	}
+	if !bs.cgoEnabled() {
+		t.Fatalf("race build without cgo")
+	}
+
+	if testing.Short() {
+		t.Skip("not building in short mode")
+	}
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("no go toolchain in PATH")
+	}
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("no C compiler in PATH")
+	}
+
+	// Build a small program with the flags and environment of the build spec.
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":  "module example.org/m\n\ngo 1.22\n",
+		"main.go": "package main\n\nfunc main() {}\n",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cgo := "CGO_ENABLED=0"
+	if bs.cgoEnabled() {
+		cgo = "CGO_ENABLED=1"
+	}
+	args := append(append([]string{"build"}, bs.buildFlags()...), "-o", filepath.Join(dir, "x"), ".")
+	cmd := exec.Command(gobin, args...)
+	cmd.Dir = dir
+	cmd.Env = append(append(os.Environ(), "GOFLAGS=", "GOTOOLCHAIN=local", cgo), bs.env()...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		if strings.Contains(string(output), "-race is not supported") {
+			t.Skipf("race detector not supported on host: %s", output)
+		}
+		t.Fatalf("building with race detector: %v\n%s", err, output)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Maximum number of decompressed binaries kept for range requests.
+const rangeCacheMax = 8
+
+// Decompressed binaries for range requests, typically for resuming downloads of
+// the same few recent binaries. The directory is cleared at first use, files
+// from before a restart are not reused.
+var rangeCache = struct {
+	sync.Mutex
+	once    sync.Once
+	keys    []string // Most recently used last.
+	flights flights[string]
+}{}
+
+func rangeCacheDir() string {
+	return filepath.Join(config.DataDir, "rangecache")
+}
+
+// openRangeFile returns the decompressed binary of the result with key, from the
+// cache if present, otherwise decompressing it first.
+func openRangeFile(key string) (*os.File, error) {
+	rangeCache.once.Do(func() {
+		err := os.RemoveAll(rangeCacheDir())
+		logCheck(err, "removing range cache directory")
+	})
+
+	p := filepath.Join(rangeCacheDir(), strings.ReplaceAll(key, "/", "-"))
+	rangeCache.Lock()
+	if i := slices.Index(rangeCache.keys, key); i >= 0 {
+		rangeCache.keys = append(slices.Delete(rangeCache.keys, i, i+1), key)
+		// Opened with the lock held, so the file isn't evicted in between.
+		f, err := os.Open(p)
+		rangeCache.Unlock()
+		return f, err
+	}
+	rangeCache.Unlock()
+
+	// Concurrent requests for the same binary share the decompression.
+	if _, err := rangeCache.flights.do(key, func() (string, error) { return "", decompressRangeFile(key, p) }); err != nil {
+		return nil, err
+	}
+
+	rangeCache.Lock()
+	defer rangeCache.Unlock()
+	if !slices.Contains(rangeCache.keys, key) {
+		rangeCache.keys = append(rangeCache.keys, key)
+	}
+	for len(rangeCache.keys) > rangeCacheMax {
+		op := filepath.Join(rangeCacheDir(), strings.ReplaceAll(rangeCache.keys[0], "/", "-"))
+		if err := os.Remove(op); err != nil {
+			slog.Error("removing file from range cache", "err", err, "path", op)
+		}
+		rangeCache.keys = rangeCache.keys[1:]
+	}
+	return os.Open(p)
+}
+
+// decompressRangeFile decompresses binary.gz of the result with key to path p.
+func decompressRangeFile(key, p string) error {
+	f, err := results.Open(key, "binary.gz")
+	if err != nil {
+		return fmt.Errorf("open binary: %w", err)
+	}
+	defer f.Close()
+	gzr, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("decompressing binary: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+		return err
+	}
+	tf, err := os.CreateTemp(filepath.Dir(p), "tmp")
+	if err != nil {
+		return fmt.Errorf("creating temp file: %v", err)
+	}
+	defer func() {
+		if tf != nil {
+			tf.Close()
+			os.Remove(tf.Name())
+		}
+	}()
+	if _, err := io.Copy(tf, gzr); err != nil {
+		return fmt.Errorf("decompressing binary: %w", err)
+	}
+	if err := tf.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tf.Name(), p); err != nil {
+		return err
+	}
+	tf = nil
+	return nil
+}
@@ -2,34 +2,60 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
 )
 
 // Called at startup to read recent builds.
 // It reads the 1000 most recent records, marks them in targets.use, then sorts the targets.
-// It keeps the last 10 builds in memory, for display on the front page.
+// It keeps the last 10 builds in memory, for display on the front page. The
+// recent builds are read from recent.json in the data directory if its most
+// recent build matches the last record. Otherwise they are taken from the
+// records, and written to recent.json.
 func readRecentBuilds() {
 	n, err := treeSize()
 	if err != nil {
 		log.Fatalf("getting sum tree size: %v", err)
 	}
 
+	if n == 0 {
+		return
+	}
+
+	// recent.json may be missing the latest build after a crash.
+	links, err := readRecentBuildsFile()
+	if err != nil {
+		slog.Error("reading recent builds file, using records", "err", err)
+	} else if len(links) > 0 {
+		last, err := serverOps{}.ReadRecords(context.Background(), n-1, 1)
+		if err != nil {
+			log.Fatalf("reading last record: %v", err)
+		}
+		br, err := parseRecord(last[0])
+		if err != nil {
+			log.Fatalf("bad record: %v", err)
+		}
+		if links[len(links)-1] != (request{br.buildSpec, br.Sum, pageIndex}).link() {
+			links = nil
+		}
+	}
+	stale := len(links) == 0
+
 	first := int64(0)
 	if n > 1000 {
 		first = n - 1000
 		n = 1000
 	}
 
-	if n == 0 {
-		return
-	}
-
 	records, err := serverOps{}.ReadRecords(context.Background(), first, n)
 	if err != nil {
 		log.Fatalf("reading records: %v", err)
 	}
 
-	links := []string{}
 	keepFrom := len(records) - 10
 	if keepFrom < 0 {
 		keepFrom = 0
@@ -40,9 +66,12 @@ func readRecentBuilds() {
 			log.Fatalf("bad record: %v", err)
 		}
 
-		targets.use[br.Goos+"/"+br.Goarch]++
+		// Targets not allowed by the config are not in use, and must not become valid.
+		if _, ok := targets.use[br.Goos+"/"+br.Goarch]; ok {
+			targets.use[br.Goos+"/"+br.Goarch]++
+		}
 
-		if i < keepFrom {
+		if !stale || i < keepFrom {
 			continue
 		}
 		link := request{br.buildSpec, br.Sum, pageIndex}.link()
@@ -51,4 +80,72 @@ func readRecentBuilds() {
 	targets.sort()
 
 	recentBuilds.links = links
+	if stale {
+		if err := writeRecentBuilds(links); err != nil {
+			slog.Error("writing recent builds file", "err", err)
+		}
+	}
+}
+
+// readRecentBuildsFile returns the links from recent.json, nil if it does not
+// exist.
+func readRecentBuildsFile() ([]string, error) {
+	buf, err := os.ReadFile(recentBuildsPath())
+	if err != nil && os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var l []string
+	if err := json.Unmarshal(buf, &l); err != nil {
+		return nil, fmt.Errorf("parsing recent builds file: %v", err)
+	}
+	return l, nil
+}
+
+func recentBuildsPath() string {
+	return filepath.Join(config.DataDir, "recent.json")
+}
+
+// addRecentBuild adds link to the recent builds shown on the home page, and
+// stores the updated list in recent.json.
+func addRecentBuild(link string) {
+	recentBuilds.Lock()
+	defer recentBuilds.Unlock()
+	recentBuilds.links = append(recentBuilds.links, link)
+	if len(recentBuilds.links) > 10 {
+		recentBuilds.links = recentBuilds.links[len(recentBuilds.links)-10:]
+	}
+	err := writeRecentBuilds(recentBuilds.links)
+	logCheck(err, "writing recent builds file")
+}
+
+// writeRecentBuilds atomically replaces recent.json with links.
+func writeRecentBuilds(links []string) error {
+	buf, err := json.Marshal(links)
+	if err != nil {
+		return fmt.Errorf("marshal recent builds: %v", err)
+	}
+	p := recentBuildsPath()
+	f, err := os.CreateTemp(filepath.Dir(p), "recent.json.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if f != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	if _, err := f.Write(append(buf, '\n')); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), p); err != nil {
+		return err
+	}
+	f = nil
+	return nil
 }
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"log/slog"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Protects the fields of config that can be changed at runtime through a reload
+// of the config file with a SIGHUP: BadClients, ModulePrefixes,
+// BlockedModulePrefixes and VerifierURLs.
+// These fields must be read through the accessor functions below. The log level
+// is changed through config.loglevel, which is safe for concurrent use. All other
+// fields are not changed after startup.
+var configLock sync.Mutex
+
+func configBadClients() []ClientPattern {
+	configLock.Lock()
+	defer configLock.Unlock()
+	return config.BadClients
+}
+
+func configModulePrefixes() []string {
+	configLock.Lock()
+	defer configLock.Unlock()
+	return config.ModulePrefixes
+}
+
+func configBlockedModulePrefixes() []BlockedModule {
+	configLock.Lock()
+	defer configLock.Unlock()
+	return config.BlockedModulePrefixes
+}
+
+func configVerifierURLs() []string {
+	configLock.Lock()
+	defer configLock.Unlock()
+	return config.VerifierURLs
+}
+
+// Cleans up URLs in a freshly parsed config.
+func normalizeConfig(c *Config) {
+	if !strings.HasSuffix(c.GoProxy, "/") {
+		c.GoProxy += "/"
+	}
+	for i, url := range c.VerifierURLs {
+		c.VerifierURLs[i] = strings.TrimSuffix(url, "/")
+	}
+}
+
+// reloadConfig parses the config file at path again and replaces the fields that
+// are safe to change at runtime. Changes to other fields are ignored with a
+// warning, they require a restart. On errors, the current config is kept.
+func reloadConfig(path string) {
+	if path == "" {
+		slog.Warn("reloading config: no config file was specified at startup, nothing to reload")
+		return
+	}
+
+	nc := emptyConfig
+	if err := parseConfig(path, &nc); err != nil {
+		slog.Error("reloading config: parsing config file, keeping current config", "err", err, "path", path)
+		return
+	}
+	normalizeConfig(&nc)
+
+	// Compare all fields we won't change, to warn about changes that need a restart.
+	configLock.Lock()
+	oc := config
+	configLock.Unlock()
+	xnc := nc
+	for _, c := range []*Config{&oc, &xnc} {
+		c.LogLevel = ""
+		c.loglevel = nil
+		c.BadClients = nil
+		c.ModulePrefixes = nil
+		c.BlockedModulePrefixes = nil
+		c.VerifierURLs = nil
+	}
+	if !reflect.DeepEqual(oc, xnc) {
+		slog.Warn("reloading config: changes to fields other than LogLevel, BadClients, ModulePrefixes, BlockedModulePrefixes and VerifierURLs are ignored, they require a restart")
+	}
+
+	configLock.Lock()
+	config.LogLevel = nc.LogLevel
+	config.BadClients = nc.BadClients
+	config.ModulePrefixes = nc.ModulePrefixes
+	config.BlockedModulePrefixes = nc.BlockedModulePrefixes
+	config.VerifierURLs = nc.VerifierURLs
+	configLock.Unlock()
+	config.loglevel.Set(nc.loglevel.Level())
+
+	slog.Info("config reloaded", "path", path, "loglevel", nc.loglevel.Level(), "badclients", len(nc.BadClients), "moduleprefixes", len(nc.ModulePrefixes), "blockedmoduleprefixes", len(nc.BlockedModulePrefixes), "verifierurls", len(nc.VerifierURLs))
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/mod/sumdb/tlog"
+)
+
+// Maximum number of records returned by a single /tlog-replicate request.
+const maxReplicateRecords = 1000
+
+// tlogReplication is the JSON response of the /tlog-replicate endpoint on the
+// admin listener, for replicating the transparency log to another instance.
+//
+// Records holds the data of records Start through Start+len(Records)-1 (without
+// the 2-byte length prefix and padding of the records file). Hashes holds the
+// stored hashes that were added to the hashes file for those records, in order,
+// starting at stored hash index tlog.StoredHashCount(Start). TreeSize and
+// TreeHash describe the tree after the records, i.e. with Start+len(Records)
+// records.
+//
+// A follower with Start records appends a response by calculating the stored
+// hashes of each record with tlog.StoredHashes, reading earlier hashes from its
+// local hashes file and the hashes already processed. The calculated hashes must
+// equal the next hashes in Hashes, and after all records, tlog.TreeHash must
+// equal TreeHash. Any difference means the log is not append-only, or the
+// follower has diverged, and nothing may be appended. The tree hash can also be
+// compared against a signed tree from /tlog/latest.
+type tlogReplication struct {
+	Start    int64
+	Records  [][]byte // Base64 in JSON.
+	Hashes   [][]byte // Base64 in JSON, each tlog.HashSize bytes.
+	TreeSize int64
+	TreeHash []byte // Base64 in JSON, tlog.HashSize bytes.
+}
+
+// serveTlogReplicate serves records and hashes of the transparency log, for the
+// admin listener. Query parameter "start" is the first record number, typically
+// the current tree size of the follower. Optional parameter "n" is the maximum
+// number of records to return, default and maximum 1000. The response has fewer
+// records when the log has fewer, possibly none. Requests must have the
+// ReplicationToken from the config as bearer token.
+func serveTlogReplicate(w http.ResponseWriter, r *http.Request) {
+	if config.ReplicationToken == "" {
+		http.NotFound(w, r)
+		return
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(config.ReplicationToken)) != 1 {
+		http.Error(w, "401 - Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "405 - Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	start, err := strconv.ParseInt(r.FormValue("start"), 10, 64)
+	if err != nil || start < 0 {
+		http.Error(w, "400 - Bad Request - bad or missing start", http.StatusBadRequest)
+		return
+	}
+	n := int64(maxReplicateRecords)
+	if s := r.FormValue("n"); s != "" {
+		n, err = strconv.ParseInt(s, 10, 64)
+		if err != nil || n <= 0 {
+			http.Error(w, "400 - Bad Request - bad n", http.StatusBadRequest)
+			return
+		}
+		n = min(n, maxReplicateRecords)
+	}
+
+	// Hashes are written before records, so hashes for all records below the tree
+	// size are present.
+	size, err := treeSize()
+	if err != nil {
+		failf(w, "%w: determining tree size: %v", errServer, err)
+		return
+	}
+	if start > size {
+		http.Error(w, "400 - Bad Request - start beyond tree size", http.StatusBadRequest)
+		return
+	}
+	n = min(n, size-start)
+
+	resp := tlogReplication{Start: start, Records: [][]byte{}, Hashes: [][]byte{}, TreeSize: start + n}
+	if n > 0 {
+		resp.Records, err = serverOps{}.ReadRecords(r.Context(), start, n)
+		if err != nil {
+			failf(w, "%w: reading records: %v", errServer, err)
+			return
+		}
+		first := tlog.StoredHashCount(start)
+		buf := make([]byte, (tlog.StoredHashCount(start+n)-first)*tlog.HashSize)
+		if _, err := hashesFile.ReadAt(buf, first*tlog.HashSize); err != nil {
+			failf(w, "%w: reading hashes: %v", errServer, err)
+			return
+		}
+		for len(buf) > 0 {
+			resp.Hashes = append(resp.Hashes, buf[:tlog.HashSize])
+			buf = buf[tlog.HashSize:]
+		}
+	}
+	if resp.TreeSize > 0 {
+		h, err := tlog.TreeHash(resp.TreeSize, hashReader{})
+		if err != nil {
+			failf(w, "%w: calculating tree hash: %v", errServer, err)
+			return
+		}
+		resp.TreeHash = h[:]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.Debug("writing tlog replication response", "err", err)
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"encoding/base64"
 	"fmt"
 	"path"
+	"slices"
 	"strings"
+	"text/template"
 )
 
 type page int
@@ -18,6 +20,10 @@ const (
 	pageRecord
 	pageEvents
 	pageRetry
+	pageVerifiers
+	pageSignature
+	pageLogFile
+	pageGoMod
 )
 
 func (p page) String() string {
@@ -38,6 +44,14 @@ func (p page) String() string {
 		return "events"
 	case pageRetry:
 		return "retry"
+	case pageVerifiers:
+		return "verifiers"
+	case pageSignature:
+		return "signature"
+	case pageLogFile:
+		return "logfile"
+	case pageGoMod:
+		return "gomod"
 	}
 	panic("missing case")
 }
@@ -50,11 +64,7 @@ type request struct {
 
 // Path in URL for this request, for linking to other pages.
 func (r request) link() string {
-	var variant string
-	if r.Stripped {
-		variant = "-stripped"
-	}
-	s := fmt.Sprintf("/%s@%s/%s%s-%s-%s%s/", r.Mod, r.Version, r.appendDir(), r.Goos, r.Goarch, r.Goversion, variant)
+	s := fmt.Sprintf("/%s@%s/%s%s-%s-%s%s/", r.Mod, r.Version, r.appendDir(), r.Goos, r.Goarch, r.Goversion, r.variantSuffix())
 	if r.Sum != "" {
 		s += r.Sum + "/"
 	}
@@ -80,13 +90,36 @@ func (r request) pagePart() string {
 		return "events"
 	case pageRetry:
 		return "retry"
+	case pageVerifiers:
+		return "verifiers.json"
+	case pageSignature:
+		return r.downloadFilename() + ".sig"
+	case pageLogFile:
+		return "log.txt"
+	case pageGoMod:
+		return "go.mod"
 	default:
 		panic("missing case")
 	}
 }
 
-// Name of file the browser will save the file as.
-func (r request) downloadFilename() string {
+// Set from config.DownloadFilenameTemplate at startup, if configured.
+var downloadFilenameTemplate *template.Template
+
+// Fields available in config.DownloadFilenameTemplate.
+type downloadFilenameArgs struct {
+	Name      string // Base name of package directory, or module if at module root.
+	Mod       string
+	Version   string
+	Dir       string
+	Goos      string
+	Goarch    string
+	Goversion string
+	Variant   string // Empty, or "-stripped", "-race", "-netgo", "-vendor", "-tags.<tags>", "-fips140.<value>" and/or "-goexperiment.<value>".
+	Ext       string // Empty, or ".exe" for windows.
+}
+
+func (r request) downloadFilenameArgs() downloadFilenameArgs {
 	var name string
 	if r.Dir != "/" {
 		name = path.Base(r.Dir)
@@ -99,9 +132,74 @@ func (r request) downloadFilename() string {
 	}
 	var variant string
 	if r.Stripped {
-		variant = "-stripped"
+		variant += "-stripped"
+	}
+	if r.Race {
+		variant += "-race"
+	}
+	if r.Netgo {
+		variant += "-netgo"
+	}
+	if r.Vendor {
+		variant += "-vendor"
+	}
+	if r.Tags != "" {
+		variant += "-tags." + r.Tags
 	}
-	return fmt.Sprintf("%s-%s-%s%s%s", name, r.Version, r.Goversion, variant, ext)
+	if r.FIPS140 != "" {
+		variant += "-fips140." + r.FIPS140
+	}
+	if r.Experiment != "" {
+		variant += "-goexperiment." + r.Experiment
+	}
+	return downloadFilenameArgs{name, r.Mod, r.Version, r.Dir, r.Goos, r.Goarch, r.Goversion, variant, ext}
+}
+
+// Name of file the browser will save the file as. Configurable with
+// config.DownloadFilenameTemplate.
+func (r request) downloadFilename() string {
+	if downloadFilenameTemplate != nil {
+		if name, err := executeDownloadFilenameTemplate(downloadFilenameTemplate, r.downloadFilenameArgs()); err == nil {
+			return name
+		}
+		// Validated at startup, only unexpected field values could cause errors. Fall
+		// back to the default name.
+	}
+	return r.defaultDownloadFilename()
+}
+
+// defaultDownloadFilename returns the download filename without template. Always
+// recognized in URLs, so "gobuild get" works regardless of configured template.
+func (r request) defaultDownloadFilename() string {
+	a := r.downloadFilenameArgs()
+	return fmt.Sprintf("%s-%s-%s%s%s", a.Name, a.Version, a.Goversion, a.Variant, a.Ext)
+}
+
+// executeDownloadFilenameTemplate executes t, returning an error if the result
+// is empty or is not a plain file name.
+func executeDownloadFilenameTemplate(t *template.Template, args downloadFilenameArgs) (string, error) {
+	var b strings.Builder
+	if err := t.Execute(&b, args); err != nil {
+		return "", err
+	}
+	name := b.String()
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\\x00") {
+		return "", fmt.Errorf("invalid download filename %q", name)
+	}
+	return name, nil
+}
+
+// parseDownloadFilenameTemplate parses and checks the template from the config.
+func parseDownloadFilenameTemplate(s string) (*template.Template, error) {
+	t, err := template.New("downloadfilename").Option("missingkey=error").Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	bs := buildSpec{"github.com/mjl-/gobuild", "v0.1.2", "/cmd/x", "windows", "amd64", "go1.22.1", true, false, false, false, "", "", ""}
+	if _, err := executeDownloadFilenameTemplate(t, request{bs, "", pageDownload}.downloadFilenameArgs()); err != nil {
+		return nil, err
+	}
+	return t, nil
 }
 
 func isSum(s string) bool {
@@ -115,11 +213,22 @@ func isSum(s string) bool {
 	return len(buf) == 20
 }
 
-// We'll get paths like /github.com/mjl-/sherpa@v0.6.0/cmd/sherpaclient/linux-amd64-go1.14.1/0m32pSahHbf-fptQdDyWD87GJNXI/{log,dl,<name>,<name>.gz,record,events,retry}
+// requestFailure is the reason parseRequest rejects a path.
+type requestFailure int
+
+const (
+	requestOK         requestFailure = iota
+	requestNotFound                  // Not a build or result page, e.g. missing trailing slash or unknown page. For 404.
+	requestBadRequest                // Malformed build path, e.g. bad module or non-canonical path. For 400.
+)
+
+// We'll get paths like /github.com/mjl-/sherpa@v0.6.0/cmd/sherpaclient/linux-amd64-go1.14.1/0m32pSahHbf-fptQdDyWD87GJNXI/{log,dl,<name>,<name>.gz,record,events,retry,verifiers.json,<name>.sig,log.txt,go.mod}
 // with optional sum.
-func parseRequest(s string) (r request, hint string, ok bool) {
+// On failure, hint can be shown to the user, and failure indicates the kind of
+// failure.
+func parseRequest(s string) (r request, hint string, failure requestFailure) {
 	if s == "" {
-		return
+		return r, "", requestNotFound
 	}
 
 	t := strings.Split(s[1:], "/")
@@ -137,14 +246,16 @@ func parseRequest(s string) (r request, hint string, ok bool) {
 		t = t[:len(t)-1]
 	}
 	if len(t) < 2 {
-		hint = "Missing goos-goarch-goversion at end of path"
-		return
+		return r, "Missing goos-goarch-goversion at end of path", requestNotFound
 	}
 
 	// Now we have a regular buildspec left.
 	if bs, err := parseBuildSpec(strings.Join(t, "/") + "/"); err != nil {
-		hint = "Bad module@version/path: " + err.Error() + ", or missing slash at end of URL"
-		return
+		// A build path without trailing slash is not a page, but not malformed either.
+		if _, xerr := parseBuildSpec(strings.Join(append(t, page), "/") + "/"); page != "" && r.Sum == "" && xerr == nil {
+			return r, "Missing slash at end of URL", requestNotFound
+		}
+		return r, "Bad module@version/path: " + err.Error(), requestBadRequest
 	} else {
 		r.buildSpec = bs
 	}
@@ -162,23 +273,71 @@ func parseRequest(s string) (r request, hint string, ok bool) {
 		r.Page = pageEvents
 	case "retry":
 		r.Page = pageRetry
+	case "verifiers.json":
+		r.Page = pageVerifiers
+	case "log.txt":
+		r.Page = pageLogFile
+	case "go.mod":
+		r.Page = pageGoMod
 	default:
 		dl := r.downloadFilename()
-		if page == dl {
+		ddl := r.defaultDownloadFilename()
+		if page == dl || page == ddl {
 			r.Page = pageDownload
-		} else if page == dl+".gz" {
+		} else if page == dl+".gz" || page == ddl+".gz" {
 			r.Page = pageDownloadGz
+		} else if page == dl+".sig" || page == ddl+".sig" {
+			r.Page = pageSignature
 		} else {
-			hint = "Missing slash at end of URL or unknown build/result page"
-			return
+			return r, "Missing slash at end of URL or unknown build/result page", requestNotFound
 		}
 	}
 
 	if r.Sum != "" && (r.Page == pageEvents || r.Page == pageRetry) {
-		hint = fmt.Sprintf("No %s endpoint for results", r.Page.String())
-		return
+		return r, fmt.Sprintf("No %s endpoint for results", r.Page.String()), requestNotFound
+	}
+	if r.Sum == "" && (r.Page == pageVerifiers || r.Page == pageSignature || r.Page == pageLogFile || r.Page == pageGoMod) {
+		return r, fmt.Sprintf("The %s endpoint is only available for results, with sum in URL", r.Page.String()), requestNotFound
+	}
+	return r, "", requestOK
+}
+
+// canonicalRequestPath returns the canonical form of build or result path s that
+// parseRequest rejects, but that only differs from a valid path in form: a missing
+// trailing slash, upper case goos/goarch, or unsorted build tags. Returns the
+// empty string if there is no such canonical form.
+func canonicalRequestPath(s string) string {
+	t := strings.Split(s, "/")
+	// The goos-goarch-goversion element is followed by at most a sum and a page.
+	for i := len(t) - 1; i >= 1 && i >= len(t)-3; i-- {
+		nt := slices.Clone(t)
+		nt[i] = canonicalTarget(nt[i])
+		for _, p := range []string{strings.Join(nt, "/"), strings.Join(nt, "/") + "/"} {
+			if p == s {
+				continue
+			}
+			if _, _, failure := parseRequest(p); failure == requestOK {
+				return p
+			}
+		}
 	}
+	return ""
+}
 
-	ok = true
-	return
+// canonicalTarget returns path element goos-goarch-goversion with variants, with
+// lower case goos and goarch, and sorted build tags.
+func canonicalTarget(elem string) string {
+	t := strings.Split(elem, "-")
+	if len(t) < 3 {
+		return elem
+	}
+	t[0], t[1] = strings.ToLower(t[0]), strings.ToLower(t[1])
+	for i, v := range t[3:] {
+		if tags, ok := strings.CutPrefix(v, "tags."); ok {
+			if ctags, err := canonicalTags(tags); err == nil && ctags != "" {
+				t[3+i] = "tags." + ctags
+			}
+		}
+	}
+	return strings.Join(t, "-")
 }
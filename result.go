@@ -1,13 +1,18 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
 	"net/http"
-	"os"
-	"path/filepath"
+	"strings"
+	"time"
 )
 
 func serveResult(w http.ResponseWriter, r *http.Request, req request) {
-	storeDir := req.storeDir()
+	key := req.storeKey()
 
 	_, br, binaryPresent, failed, err := serverOps{}.lookupResult(r.Context(), req.buildSpec)
 	if err != nil {
@@ -17,13 +22,28 @@ func serveResult(w http.ResponseWriter, r *http.Request, req request) {
 		http.NotFound(w, r)
 		return
 	} else if br == nil || !binaryPresent {
+		if config.ReadOnly {
+			if br == nil {
+				http.NotFound(w, r)
+			} else {
+				failReadOnly(w)
+			}
+			return
+		}
+
 		if handleBadClient(w, r) {
 			return
 		}
 
-		// Attempt to build.
-		if err := prepareBuild(req.buildSpec); err != nil {
-			failf(w, "preparing build: %w", err)
+		// Attempt to build. Rebuilds of removed binaries are done regardless of age.
+		if br == nil {
+			if err := checkVersionAge(r, req.buildSpec); err != nil {
+				failPrepare(w, req.buildSpec, err)
+				return
+			}
+		}
+		if err := prepareBuild(r.Context(), req.buildSpec, kindOf(br)); err != nil {
+			failPrepare(w, req.buildSpec, err)
 			return
 		}
 
@@ -32,7 +52,7 @@ func serveResult(w http.ResponseWriter, r *http.Request, req request) {
 			expSum = br.Sum
 		}
 		eventc := make(chan buildUpdate, 100)
-		registerBuild(req.buildSpec, expSum, eventc)
+		registerBuild(req.buildSpec, expSum, req.Page == pageRecord, eventc)
 		ctx := r.Context()
 
 	loop:
@@ -62,24 +82,65 @@ func serveResult(w http.ResponseWriter, r *http.Request, req request) {
 		return
 	}
 
+	// Results are immutable, the URL includes the sum. Let clients and proxies cache
+	// them, but not errors.
+	if etag := resultETag(r, req.Page, br.Sum); etag != "" {
+		if etagMatch(r.Header.Get("If-None-Match"), etag) {
+			if req.Page == pageDownload || req.Page == pageLog || req.Page == pageLogFile {
+				w.Header().Add("Vary", "Accept-Encoding")
+			}
+			w.Header().Set("ETag", etag)
+			w.Header().Set("Cache-Control", immutableCacheControl)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		// The ETag is also used by http.ServeContent, for If-Range.
+		w.Header().Set("ETag", etag)
+		w = &immutableWriter{w, false}
+	}
+
 	switch req.Page {
 	case pageLog:
-		serveLog(w, r, filepath.Join(storeDir, "log.gz"))
+		serveLog(w, r, key)
+	case pageLogFile:
+		// Like pageLog, but for saving alongside the binary.
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": req.downloadFilename() + ".log.txt"}))
+		serveLog(w, r, key)
 	case pageDownloadRedirect:
 		link := request{req.buildSpec, br.Sum, pageDownload}.link()
 		http.Redirect(w, r, link, http.StatusTemporaryRedirect)
 	case pageDownload:
-		p := filepath.Join(storeDir, "binary.gz")
-		f, err := os.Open(p)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", downloadDisposition(req.downloadFilename()))
+		if r.Header.Get("Range") != "" {
+			// For resuming downloads, we need the uncompressed binary.
+			serveRange(w, r, key)
+			return
+		}
+		f, err := results.Open(key, "binary.gz")
 		if err != nil {
 			failf(w, "%w: open binary: %v", errServer, err)
 			return
 		}
 		defer f.Close()
-		serveGzipFile(w, r, p, f)
+		if negotiateEncoding(r) == "" {
+			w.Header().Set("Accept-Ranges", "bytes")
+		}
+		serveGzipFile(w, r, key+"/binary.gz", f)
 	case pageDownloadGz:
-		p := filepath.Join(storeDir, "binary.gz")
-		http.ServeFile(w, r, p)
+		f, err := results.Open(key, "binary.gz")
+		if err != nil {
+			failf(w, "%w: open binary: %v", errServer, err)
+			return
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			failf(w, "%w: stat binary: %v", errServer, err)
+			return
+		}
+		w.Header().Set("Content-Disposition", downloadDisposition(req.downloadFilename()+".gz"))
+		http.ServeContent(w, r, "binary.gz", fi.ModTime(), f)
 	case pageRecord:
 		if msg, err := br.packRecord(); err != nil {
 			failf(w, "%w: packing record: %v", errServer, err)
@@ -87,9 +148,198 @@ func serveResult(w http.ResponseWriter, r *http.Request, req request) {
 			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 			w.Write(msg) // nothing to do for errors
 		}
+	case pageSignature:
+		// Only present for builds done while a binary signer key was configured.
+		buf, err := readResultFile(key, "binary.sig")
+		if err != nil && errors.Is(err, fs.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
+			failf(w, "%w: reading signature: %v", errServer, err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(buf) // nothing to do for errors
+	case pageVerifiers:
+		verifiers, err := readVerifiers(key)
+		if err != nil {
+			failf(w, "%w: reading verifiers: %v", errServer, err)
+			return
+		}
+		duration, err := readBuildDuration(key)
+		if err != nil {
+			failf(w, "%w: reading build duration: %v", errServer, err)
+			return
+		}
+		resp := struct {
+			Verifiers            []string
+			BuildDurationSeconds float64 `json:",omitempty"` // Absent for older builds, and builds from another instance.
+		}{verifiers, duration.Seconds()}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp) // nothing to do for errors
+	case pageGoMod:
+		// From the goproxy instead of the module cache, which may have been cleared.
+		// The go.mod of a module version is immutable, verified through the checksum
+		// database by the go command during builds.
+		buf, err := fetchGoMod(r.Context(), req.Mod, req.Version)
+		if err != nil {
+			failf(w, "fetching go.mod: %w", err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(buf) // nothing to do for errors
 	case pageIndex:
 		serveIndex(w, r, req.buildSpec, br)
 	default:
 		failf(w, "%w: unknown page %v", errServer, req.Page)
 	}
 }
+
+// readVerifiers returns the base URLs of the verifiers that confirmed the build
+// result with key. Builds without verifiers, or from before verifiers were
+// stored, return an empty list.
+func readVerifiers(key string) ([]string, error) {
+	buf, err := readResultFile(key, "verifiers")
+	if err != nil && errors.Is(err, fs.ErrNotExist) {
+		return []string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(buf)), nil
+}
+
+// readBuildDuration returns the duration of the compile of the result with key.
+// Zero if unknown, for builds from before the duration was stored, and results
+// followed from another instance.
+func readBuildDuration(key string) (time.Duration, error) {
+	buf, err := readResultFile(key, "duration")
+	if err != nil && errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return time.ParseDuration(strings.TrimSpace(string(buf)))
+}
+
+// readSourceDateEpoch returns the SOURCE_DATE_EPOCH environment variable the
+// result with key was built with, empty if it was built without.
+func readSourceDateEpoch(key string) (string, error) {
+	buf, err := readResultFile(key, "sourcedateepoch")
+	if err != nil && errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(buf)), nil
+}
+
+// readBinarySHA256 returns the full sha256 of the binary of the result with key
+// in hex, as recorded in its signature. Empty if the build was not signed.
+func readBinarySHA256(key string) (string, error) {
+	buf, err := readResultFile(key, "binary.sig")
+	if err != nil && errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(buf), "\n") {
+		if s, ok := strings.CutPrefix(line, "sha256 "); ok {
+			return s, nil
+		}
+	}
+	return "", nil
+}
+
+// resultETag returns the ETag for a page of a build result, or empty if the page
+// does not get caching headers. The download and log pages are served with
+// different content-encodings, each with their own ETag.
+func resultETag(r *http.Request, p page, sum string) string {
+	switch p {
+	case pageDownload, pageLog, pageLogFile:
+		enc := negotiateEncoding(r)
+		if p == pageLog || p == pageLogFile {
+			enc = logEncoding(r)
+		} else if r.Header.Get("Range") != "" {
+			// Ranges are served from the uncompressed binary.
+			enc = ""
+		}
+		if enc != "" {
+			return fmt.Sprintf(`"%s-%s-%s"`, sum, p, enc)
+		}
+		return fmt.Sprintf(`"%s-%s"`, sum, p)
+	case pageDownloadGz, pageRecord, pageVerifiers, pageSignature, pageGoMod:
+		return fmt.Sprintf(`"%s-%s"`, sum, p)
+	}
+	return ""
+}
+
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// immutableWriter marks successful responses for immutable results as cacheable.
+// For other responses, e.g. errors, the ETag is removed.
+type immutableWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *immutableWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if statusCode == http.StatusOK || statusCode == http.StatusPartialContent {
+			w.Header().Set("Cache-Control", immutableCacheControl)
+		} else {
+			w.Header().Del("ETag")
+		}
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *immutableWriter) Write(buf []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(buf)
+}
+
+// etagMatch returns whether the If-None-Match header value matches etag, using
+// weak comparison.
+func etagMatch(ifNoneMatch, etag string) bool {
+	for _, s := range strings.Split(ifNoneMatch, ",") {
+		s = strings.TrimSpace(s)
+		if s == "*" || strings.TrimPrefix(s, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// serveRange serves the decompressed contents of the binary.gz of the result with
+// key with support for range requests. A decompressed copy is kept in the range
+// cache, so http.ServeContent can seek in it, and resumed downloads don't
+// decompress again.
+func serveRange(w http.ResponseWriter, r *http.Request, key string) {
+	info, err := results.Stat(key, "binary.gz")
+	if err != nil {
+		failf(w, "%w: stat binary: %v", errServer, err)
+		return
+	}
+	f, err := openRangeFile(key)
+	if err != nil {
+		failf(w, "%w: decompressed binary: %v", errServer, err)
+		return
+	}
+	defer f.Close()
+	// ServeContent seeks to determine the size.
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
+
+// downloadDisposition returns the Content-Disposition header for downloading a
+// binary as filename. Attachment, so browsers consistently save the file, unless
+// the config asks for inline.
+func downloadDisposition(filename string) string {
+	disposition := "attachment"
+	if config.DownloadInline {
+		disposition = "inline"
+	}
+	return mime.FormatMediaType(disposition, map[string]string{"filename": filename})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+)
+
+// selfVerify periodically rebuilds a random build from the transparency log, to
+// check that builds are still reproducible with the current setup. It never
+// returns.
+func selfVerify(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		selfVerifyOnce()
+	}
+}
+
+func selfVerifyOnce() {
+	n, err := treeSize()
+	if err != nil {
+		slog.Error("selfverify: reading tree size", "err", err)
+		return
+	} else if n == 0 {
+		return
+	}
+	num := rand.Int64N(n)
+	records, err := serverOps{}.ReadRecords(context.Background(), num, 1)
+	if err != nil {
+		slog.Error("selfverify: reading record", "err", err, "record", num)
+		return
+	}
+	br, err := parseRecord(records[0])
+	if err != nil {
+		slog.Error("selfverify: parsing record", "err", err, "record", num)
+		return
+	}
+
+	// Race builds are not guaranteed to be reproducible.
+	if br.Race {
+		slog.Info("selfverify: race build, skipping", "record", num, "buildspec", br.buildSpec)
+		return
+	}
+
+	// The toolchain may have been removed, or may not be allowed anymore.
+	if _, err := ensureSDK(br.Goversion); err != nil {
+		slog.Info("selfverify: toolchain not available, skipping", "err", err, "record", num, "buildspec", br.buildSpec)
+		return
+	}
+
+	slog.Info("selfverify: rebuilding", "record", num, "buildspec", br.buildSpec, "sum", br.Sum)
+	eventc := make(chan buildUpdate, 100)
+	registerRebuild(br.buildSpec, br.Sum, eventc)
+	var update buildUpdate
+	for {
+		update = <-eventc
+		if update.done {
+			break
+		}
+	}
+	unregisterBuild(br.buildSpec, eventc)
+
+	if update.err == nil {
+		metricSelfVerify.WithLabelValues("match").Inc()
+		slog.Info("selfverify: rebuild matches", "record", num, "buildspec", br.buildSpec, "sum", br.Sum)
+	} else if errors.Is(update.err, errRebuildMismatch) {
+		metricSelfVerify.WithLabelValues("mismatch").Inc()
+		slog.Error("selfverify: REBUILD DOES NOT MATCH RECORDED SUM, BUILDS MAY NO LONGER BE REPRODUCIBLE", "err", update.err, "record", num, "buildspec", br.buildSpec, "sum", br.Sum)
+	} else {
+		metricSelfVerify.WithLabelValues("error").Inc()
+		slog.Error("selfverify: rebuild failed", "err", update.err, "record", num, "buildspec", br.buildSpec)
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"runtime/debug"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -30,6 +31,7 @@ import (
 
 	"github.com/mjl-/gobuild/internal/sumdb"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/mjl-/sconf"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"golang.org/x/crypto/acme/autocert"
@@ -59,6 +61,8 @@ var (
 		"sdk",
 		"home",
 		0,
+		0,
+		1 << 30, // 1 GiB
 		nil,
 		nil,
 		false,
@@ -72,7 +76,50 @@ var (
 		"",
 		nil,
 		12 * 7 * 24 * time.Hour, // 12 weeks
+		5 * time.Minute,
+		"",
+		false,
+		0,
+		"",
+		0,
+		time.Minute,
+		"",
+		false,
+		nil,
+		false,
+		nil,
+		nil,
+		nil,
+		false,
+		false,
+		"",
+		"",
+		"",
+		"",
+		"",
+		"",
+		false,
+		false,
+		0,
+		"",
+		"",
+		"",
+		"",
+		nil,
+		nil,
+		0,
+		false,
+		nil,
+		"",
+		nil,
+		"",
+		false,
+		0,
+		0,
+		false,
+		nil,
 		&slog.LevelVar{},
+		nil,
 	}
 	emptyConfig = config
 
@@ -81,6 +128,12 @@ var (
 	// (same partition) as final results.
 	resultDir string
 
+	// Path to config file, if any. Used for reloading the config on SIGHUP.
+	configPath string
+
+	// If set, used to sign binaries of new builds.
+	binarySigner note.Signer
+
 	// Opened at startup, used whenever we read/write to the hashes or records files.
 	hashesFile, recordsFile *os.File
 
@@ -89,33 +142,92 @@ var (
 )
 
 type Config struct {
-	LogLevel     string   `sconf:"optional" sconf-doc:"Log level: debug, info, warn, error. Default info."`
-	GoProxy      string   `sconf-doc:"URL to Go module proxy. Used to resolve \"latest\" module versions."`
-	DataDir      string   `sconf-doc:"Directory where the sumdb and builds files (binary, log) are stored."`
-	SDKDir       string   `sconf-doc:"Directory where SDKs (go toolchains) are installed."`
-	HomeDir      string   `sconf-doc:"Directory set as home directory during builds. Go will store its caches, downloaded and extracted modules here."`
-	MaxBuilds    int      `sconf-doc:"Maximum concurrent builds. Default (0) uses NumCPU+1."`
-	Environment  []string `sconf:"optional" sconf-doc:"Additional environment variables in form KEY=VALUE to use for go command invocations. Useful to configure GOSUMDB and HTTPS_PROXY."`
-	Run          []string `sconf:"optional" sconf-doc:"Command and parameters to prefix invocations of go with. For example /usr/bin/nice."`
-	BuildGobin   bool     `sconf-doc:"If enabled, sets environment variable GOBUILD_GOBIN during a build to a directory where the build command should write the binary. Configure a wrapper to the build command through the Run config option."`
-	VerifierURLs []string `sconf:"optional" sconf-doc:"URLs of other gobuild instances that are asked to perform the same build. Gobuild requires all of them to create the same binary (same hash) for a build to be successful. Ideally, these instances differ in hardware, goos, goarch, user id/name, home and work directories."`
-	HTTPS        *struct {
+	LogLevel       string   `sconf:"optional" sconf-doc:"Log level: debug, info, warn, error. Default info."`
+	GoProxy        string   `sconf-doc:"URL to Go module proxy. Used to resolve \"latest\" module versions."`
+	DataDir        string   `sconf-doc:"Directory where the sumdb and builds files (binary, log) are stored."`
+	SDKDir         string   `sconf-doc:"Directory where SDKs (go toolchains) are installed."`
+	HomeDir        string   `sconf-doc:"Directory set as home directory during builds. Go will store its caches, downloaded and extracted modules here."`
+	MaxBuilds      int      `sconf-doc:"Maximum concurrent builds. Default (0) uses NumCPU+1."`
+	MaxModuleBytes int64    `sconf:"optional" sconf-doc:"Maximum size in bytes of the files of a module, checked after fetching. Builds of larger modules are refused. Dependencies are not counted. Default 1GiB. Set to 0 to disable."`
+	MaxCommands    int      `sconf:"optional" sconf-doc:"Maximum concurrent go commands other than builds, such as fetching modules, resolving versions and listing packages. Builds are not counted, they are limited by MaxBuilds. Default (0) uses NumCPU."`
+	Environment    []string `sconf:"optional" sconf-doc:"Additional environment variables in form KEY=VALUE to use for go command invocations. Useful to configure GOSUMDB and HTTPS_PROXY."`
+	Run            []string `sconf:"optional" sconf-doc:"Command and parameters to prefix invocations of go with. For example /usr/bin/nice."`
+	BuildGobin     bool     `sconf-doc:"If enabled, sets environment variable GOBUILD_GOBIN during a build to a directory where the build command should write the binary. Configure a wrapper to the build command through the Run config option. If the wrapper writes the binary under another name, it must either leave a single file in GOBUILD_GOBIN, or write the path of the binary relative to GOBUILD_GOBIN to a file gobuild-binary-path in GOBUILD_GOBIN."`
+	VerifierURLs   []string `sconf:"optional" sconf-doc:"URLs of other gobuild instances that are asked to perform the same build. Gobuild requires all of them to create the same binary (same hash) for a build to be successful. Ideally, these instances differ in hardware, goos, goarch, user id/name, home and work directories."`
+	HTTPS          *struct {
 		ACME struct {
 			Domains []string `sconf-doc:"List of domains to serve HTTPS for and request certificates for with ACME."`
 			Email   string   `sconf-doc:"Contact email address to use when requesting certificates through ACME. CAs will contact this address in case of problems or expiry of certificates."`
 			CertDir string   `sconf-doc:"Directory to stored certificates in."`
 		} `sconf-doc:"ACME configuration."`
 	} `sconf:"optional" sconf-doc:"HTTPS configuration, if any."`
-	SignerKeyFile                string          `sconf:"optional" sconf-doc:"File containing signer key as generated by subcommand genkey, for signing the transparent log."`
-	VerifierKey                  string          `sconf:"optional" sconf-doc:"Verifier key as generated by subcommand genkey, for verifying a signed transparent log. This key is displayed on the home page."`
-	LogDir                       string          `sconf-doc:"Directory to store log files. HTTP access logs are written, one file per day. Additions to the transparency logs, and HTTP protocol errors. Leave empty to disable logging."`
-	ModulePrefixes               []string        `sconf:"optional" sconf-doc:"If non-empty, allow list of module prefixes for which binaries will be built. Requests for other module prefixes result in an error. Prefixes should typically end with a slash."`
-	SDKVersionStop               string          `sconf:"optional" sconf-doc:"If set, the (hypothetical) version (and beyond) of the Go toolchain that is not allowed for builds. Gobuild automatically downloads new SDKs. However, new Go toolchain versions may change behaviour which may cause binaries to no longer become reproducible with the flags gobuild uses to build. By refusing new versions, you have time to separately verify binaries with newer Go toolchains are still reproducible. Example: a version of go1.20 allows go1.18, go1.19, go1.19.1, but not go1.20, go1.21 or go2.0. Versions like go1.20rc1 are interpreted as go1.20, without rc1."`
-	InstanceNotesFile            string          `sconf:"optional" sconf-doc:"If set, a path to a plain text file with notes about this gobuild instance that is included on the main page."`
-	BadClients                   []ClientPattern `sconf:"optional" sconf-doc:"Clients for which we won't start a new build. To prevent bad bots that ignore robots.txt from causing lots of builds."`
-	CleanupBinariesAccessTimeAge time.Duration   `sconf:"optional" sconf-doc:"Remove build result binaries with an access time longer this duration ago, if > 0. Binaries will be rebuilt, and verified to match the expected sum, when requested again."`
-
-	loglevel *slog.LevelVar
+	SignerKeyFile                string            `sconf:"optional" sconf-doc:"File containing signer key as generated by subcommand genkey, for signing the transparent log."`
+	VerifierKey                  string            `sconf:"optional" sconf-doc:"Verifier key as generated by subcommand genkey, for verifying a signed transparent log. This key is displayed on the home page."`
+	LogDir                       string            `sconf-doc:"Directory to store log files. HTTP access logs are written, one file per day. Additions to the transparency logs, and HTTP protocol errors. Leave empty to disable logging."`
+	ModulePrefixes               []string          `sconf:"optional" sconf-doc:"If non-empty, allow list of module prefixes for which binaries will be built. Requests for other module prefixes result in an error. Prefixes should typically end with a slash."`
+	SDKVersionStop               string            `sconf:"optional" sconf-doc:"If set, the (hypothetical) version (and beyond) of the Go toolchain that is not allowed for builds. Gobuild automatically downloads new SDKs. However, new Go toolchain versions may change behaviour which may cause binaries to no longer become reproducible with the flags gobuild uses to build. By refusing new versions, you have time to separately verify binaries with newer Go toolchains are still reproducible. Example: a version of go1.20 allows go1.18, go1.19, go1.19.1, but not go1.20, go1.21 or go2.0. Versions like go1.20rc1 are interpreted as go1.20, without rc1."`
+	InstanceNotesFile            string            `sconf:"optional" sconf-doc:"If set, a path to a plain text file with notes about this gobuild instance that is included on the main page."`
+	BadClients                   []ClientPattern   `sconf:"optional" sconf-doc:"Clients for which we won't start a new build. To prevent bad bots that ignore robots.txt from causing lots of builds."`
+	CleanupBinariesAccessTimeAge time.Duration     `sconf:"optional" sconf-doc:"Remove build result binaries with an access time longer this duration ago, if > 0. Binaries will be rebuilt, and verified to match the expected sum, when requested again."`
+	GoProxyListCacheTTL          time.Duration     `sconf:"optional" sconf-doc:"How long to cache the list of versions of a module and its latest version retrieved from the Go module proxy, shown on build pages. Concurrent requests for the same module always share a single request to the Go module proxy. Default 5m. Set to 0 to disable caching."`
+	DefaultTarget                string            `sconf:"optional" sconf-doc:"Target (goos/goarch, e.g. linux/amd64) to use when it cannot be detected from the user-agent of a request. If empty, the most popular target is used."`
+	AccessLogBuildDetails        bool              `sconf:"optional" sconf-doc:"If set, access log lines for build and result pages are extended with the module, version, target, go version and sum (if known) of the requested build, as key=value pairs after the user agent."`
+	VerifierQuorum               int               `sconf:"optional" sconf-doc:"Minimum number of verifiers from VerifierURLs that must return the same sum for a build to succeed. Verifiers that fail, e.g. because they are unreachable, are tolerated as long as this many verifiers confirm the sum. A different sum from any verifier always fails the build. Default (0) requires all verifiers to confirm."`
+	BinarySignerKeyFile          string            `sconf:"optional" sconf-doc:"File containing signer key as generated by subcommand genkey, for signing binaries of new builds. The signature is a signed note, as used for the transparency log, with the sha256 hash of the uncompressed binary, its filename and build specification. It is stored with the build result and available at the download URL with .sig appended. Can be the same key as SignerKeyFile. Verify with golang.org/x/mod/sumdb/note and the verifier key."`
+	SelfVerifyInterval           time.Duration     `sconf:"optional" sconf-doc:"If > 0, periodically rebuild a random successful build from the transparency log, and check it results in the same sum. Mismatches are logged as error, and counted in metric gobuild_selfverify_total. Rebuilds go through the build queue like regular builds."`
+	VerifierProbeInterval        time.Duration     `sconf:"optional" sconf-doc:"Interval for requesting the signed transparency log tree head (/tlog/latest) of each of the VerifierURLs, to detect unreachable verifiers before builds fail. Results are exported as metrics gobuild_verifier_up and gobuild_verifier_probe_duration_seconds, and shown at /verifiers on the admin listener. Default 1m. Set to 0 to disable."`
+	DownloadFilenameTemplate     string            `sconf:"optional" sconf-doc:"Go text/template for the file name of downloaded binaries, e.g. {{.Name}}-{{.Version}}-{{.Goos}}-{{.Goarch}}{{.Variant}}{{.Ext}}. Fields: Name (base name of the package directory, or of the module at its root), Mod, Version, Dir, Goos, Goarch, Goversion, Variant (empty, or the variant suffixes of the build URL, e.g. -stripped or -goexperiment.arenas) and Ext (empty or .exe). The result cannot contain path separators. If empty, the default is {{.Name}}-{{.Version}}-{{.Goversion}}{{.Variant}}{{.Ext}}. URLs with the default file name keep working, as used by gobuild get."`
+	ReadOnly                     bool              `sconf:"optional" sconf-doc:"If set, never start builds. Only existing build results, logs and records are served, requests for other builds are refused. For mirrors of a trusted upstream instance. Cannot be combined with CleanupBinariesAccessTimeAge or SelfVerifyInterval, binaries cannot be rebuilt."`
+	GoExperiments                []string          `sconf:"optional" sconf-doc:"GOEXPERIMENT values that builds may be requested with, e.g. arenas. Multiple experiments are comma-separated, sorted and unique. The experiment is part of the build, in URLs as -goexperiment.<value> after goos-goarch-goversion[-stripped], and in transparency log records. Verifiers must allow the same experiments. Records with experiments cannot be parsed by older gobuild versions."`
+	SumDBDisabled                bool              `sconf:"optional" sconf-doc:"If set, the go command does not verify modules against the Go checksum database (GOSUMDB=off). For instances without internet access that only build modules from an internal goproxy. Warning: modules, including public modules, from the goproxy are then trusted without verification, reducing security."`
+	NoSumDBPatterns              []string          `sconf:"optional" sconf-doc:"Module path patterns (as for GONOSUMDB, e.g. example.com/private or *.corp.example) for which the go command does not verify modules against the Go checksum database. For private modules unknown to the checksum database. Warning: only list module paths you control, modules matching these patterns are trusted without verification."`
+	TrustedProxies               []string          `sconf:"optional" sconf-doc:"IP networks (e.g. 127.0.0.1/32 or 2001:db8::/64) of reverse proxies. For requests from these networks, the client IP address is taken from the Forwarded or X-Forwarded-For header, for matching BadClients and for the access log. Addresses in these headers from other clients are ignored."`
+	BlockedModulePrefixes        []BlockedModule   `sconf:"optional" sconf-doc:"Modules for which no binaries will be built, even if allowed by ModulePrefixes. Requests result in an error, with the reason if set."`
+	RecordSHA256                 bool              `sconf:"optional" sconf-doc:"If set, new records in the transparency log also contain the full sha256 of the binary, in a second version of the record format. Existing records are not changed. Records in the new format cannot be parsed by older gobuild versions, including by the get and verify subcommands, and by verifiers."`
+	CancelAbandonedBuilds        bool              `sconf:"optional" sconf-doc:"If set, a running build is canceled when the last client waiting for it goes away, e.g. a browser navigating away from the build page. Saves resources on busy instances, but may waste nearly finished builds that are requested again later. Canceled builds are not stored as failed."`
+	GoProxyHTTPProxy             string            `sconf:"optional" sconf-doc:"URL of HTTP proxy for requests to the GoProxy, e.g. http://proxy.example:3128. Used for requests by gobuild itself, and set as HTTPS_PROXY and HTTP_PROXY for go commands that access the goproxy, so it also applies to the checksum database. If empty, the HTTPS_PROXY environment variable is used, if set."`
+	GoReleasesHTTPProxy          string            `sconf:"optional" sconf-doc:"URL of HTTP proxy for listing and downloading Go toolchains at go.dev and dl.google.com. If empty, the HTTPS_PROXY environment variable is used, if set."`
+	SDKSigningKeysFile           string            `sconf:"optional" sconf-doc:"Path to file with armored PGP public keys to trust for signatures on Go toolchain downloads, in addition to the embedded Google release signing key. For continuing to fetch new toolchains after the signing key is rotated, before gobuild is updated."`
+	ReplicationToken             string            `sconf:"optional" sconf-doc:"If set, the admin endpoint /tlog-replicate serves records and hashes of the transparency log to clients sending this token as bearer token in the Authorization header, for replicating the log to another instance. If empty, the endpoint is disabled."`
+	FollowerUpstream             string            `sconf:"optional" sconf-doc:"Base URL of an upstream gobuild instance, e.g. https://beta.gobuilds.org, to follow. New records in its transparency log are fetched, verified and added to the local log, so results are served locally. Requires ReadOnly and FollowerVerifierKey. The local transparency log must be a copy of the upstream log, typically empty when starting to follow."`
+	FollowerVerifierKey          string            `sconf:"optional" sconf-doc:"Verifier key of the transparency log of FollowerUpstream, for verifying signed tree heads."`
+	FollowerFetchBinaries        bool              `sconf:"optional" sconf-doc:"If set, binaries of followed records are fetched from FollowerUpstream and stored locally, after verifying their sums. Otherwise only records are stored, and read-only instances cannot serve the binaries."`
+	RunVet                       bool              `sconf:"optional" sconf-doc:"If set, run go vet on the package before building, and refuse the build if it reports problems. Only for new builds, not for restoring binaries of logged builds or builds for verifying instances. Does not influence the binary or its sum. Slows down builds, and rejects programs that build fine, so disabled by default."`
+	MaxModuleVersionAge          time.Duration     `sconf:"optional" sconf-doc:"If > 0, refuse to start builds of module versions published longer than this duration ago, e.g. to keep crawlers from triggering builds of old versions. Users can still build old versions by adding query parameter allowold=1 to the URL. Existing builds are always served."`
+	SiteName                     string            `sconf:"optional" sconf-doc:"Name of this instance, shown in page titles and the page footer. If empty, pages are branded as gobuild only."`
+	FaviconFile                  string            `sconf:"optional" sconf-doc:"Path to a PNG file to serve as favicon instead of the built-in gopher icon. Read at startup."`
+	FaviconBuildingFile          string            `sconf:"optional" sconf-doc:"Path to a PNG file to serve as favicon for pages of builds in progress instead of the built-in icon. Read at startup."`
+	FaviconErrorFile             string            `sconf:"optional" sconf-doc:"Path to a PNG file to serve as favicon for pages of failed builds and errors instead of the built-in icon. Read at startup."`
+	PinnedGoversions             []PinnedGoversion `sconf:"optional" sconf-doc:"Go versions that \"latest\" resolves to for builds for specific targets, instead of the newest allowed Go version. For keeping links to the latest builds for a target at a known Go version. The Go version must be supported or installed, and allowed by SDKVersionMin and SDKVersionStop."`
+	Targets                      []string          `sconf:"optional" sconf-doc:"If non-empty, allow list of targets (goos/goarch, e.g. linux/amd64) that are offered and built. Builds for other targets are refused, existing builds for them are still served, and their binaries can be restored. If empty, all known targets are offered."`
+	MaxSDKFetches                int               `sconf:"optional" sconf-doc:"Maximum number of Go toolchains downloaded and extracted concurrently, to limit network and disk load when builds for many new Go versions are requested at once. Requests for the same Go version always share a single fetch. Default (0) is 1."`
+	AllowRace                    bool              `sconf:"optional" sconf-doc:"If set, builds with the race detector can be requested for the host target, for debugging, in URLs as -race after goos-goarch-goversion[-stripped]. Such builds are not verified by VerifierURLs, are not guaranteed to be reproducible, and are marked as race in the transparency log. Records with race cannot be parsed by older gobuild versions. The race detector requires cgo, so race builds are done with CGO_ENABLED=1, and need a C compiler on the host, found through PATH (from the gobuild environment, or Environment in this config)."`
+	BuildTags                    []string          `sconf:"optional" sconf-doc:"Build tags that builds may be requested with, e.g. purego. Tags are part of the build, in URLs as -tags.<tags> (comma-separated, sorted) after goos-goarch-goversion[-stripped][-race], and in transparency log records. Verifiers must allow the same tags. Records with tags cannot be parsed by older gobuild versions. Tags netgo and osusergo are not allowed, they are set by the netgo variant. If empty, no tags are allowed. Opt in with e.g. purego and timetzdata, for pure Go alternatives of code that would otherwise use cgo or assembly, and embedded time zone data."`
+	SDKVersionMin                string            `sconf:"optional" sconf-doc:"If set, the oldest version of the Go toolchain allowed for builds, e.g. go1.21, for not having to maintain toolchains for ancient versions. Older versions are not offered on build pages, and builds with them are refused. Existing builds are still served. Like SDKVersionStop, versions like go1.21rc1 are interpreted as go1.21."`
+	GOFIPS140                    []string          `sconf:"optional" sconf-doc:"GOFIPS140 values that builds may be requested with, e.g. latest or v1.0.0, for binaries using the Go Cryptographic Module in FIPS 140-3 mode. Only for go1.24 and newer. The value is part of the build, in URLs as -fips140.<value> after goos-goarch-goversion[-stripped][-race][-netgo][-tags.<tags>], and in transparency log records. Verifiers must allow the same values. Records with fips140 cannot be parsed by older gobuild versions."`
+	ErrorNotesFile               string            `sconf:"optional" sconf-doc:"If set, a path to a plain text file with notes included on error pages and with failed builds, e.g. where users can report issues. Read for each error, so changes take effect immediately."`
+	DownloadInline               bool              `sconf:"optional" sconf-doc:"If set, binaries are served with Content-Disposition inline instead of attachment, letting browsers decide whether to show or save the file. The filename is still included."`
+	MaxEventStreams              int               `sconf:"optional" sconf-doc:"If > 0, maximum number of concurrent SSE connections for build updates, as opened by pages of builds in progress and batch builds. Further connections get a 503 response. Protects against clients exhausting server resources with many connections. Default (0) is unlimited."`
+	MaxEventStreamsPerIP         int               `sconf:"optional" sconf-doc:"If > 0, maximum number of concurrent SSE connections for build updates per client IP address, see MaxEventStreams. Default (0) is unlimited."`
+	SourceDateEpoch              bool              `sconf:"optional" sconf-doc:"If set, builds set environment variable SOURCE_DATE_EPOCH to the time of the module version according to the goproxy, typically the commit time, for programs that embed a build time. Builds remain reproducible, but binaries may differ from builds without this option, so verifiers must have the same setting. Changing this option for an existing instance can cause sum mismatches for new builds of programs that use the variable."`
+	CORSAllowOrigins             []string          `sconf:"optional" sconf-doc:"Origins (e.g. https://example.org) of web pages that may request the JSON endpoints (modules.json, targets.json, badge.json, tlog-status and verifiers.json of results) from a browser, through CORS headers. Use * to allow all origins. If empty, no CORS headers are sent."`
+
+	loglevel       *slog.LevelVar
+	trustedProxies []net.IPNet
+}
+
+// PinnedGoversion is the Go version used for builds with "latest" Go version for a
+// target.
+type PinnedGoversion struct {
+	Target    string `sconf-doc:"Target as goos/goarch, e.g. darwin/arm64."`
+	Goversion string `sconf-doc:"Go version, e.g. go1.22.5."`
+}
+
+// BlockedModule is a module, or module path prefix, that is not built.
+type BlockedModule struct {
+	Prefix  string `sconf-doc:"Module path prefix, e.g. github.com/example/ or github.com/example/tool. Without trailing slash, modules with the prefix as path prefix match too."`
+	Version string `sconf:"optional" sconf-doc:"If set, only this version of the module with exactly path Prefix is blocked."`
+	Reason  string `sconf:"optional" sconf-doc:"Explanation shown to users."`
 }
 
 // ClientPattern has fields for matching client requests.
@@ -137,9 +249,9 @@ func (cp ClientPattern) Match(r *http.Request) (hostname string, match bool) {
 	if len(cp.ipnets) == 0 && cp.HostnameSuffix == "" {
 		return "", false
 	}
-	ipstr, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		log.Printf("getting ip from remote address %s: %v", r.RemoteAddr, err)
+	ipstr := clientIP(r)
+	if ipstr == "" {
+		log.Printf("getting ip from remote address %s", r.RemoteAddr)
 		return "", false
 	}
 	if len(cp.ipnets) > 0 {
@@ -208,13 +320,38 @@ var (
 
 	//go:embed template/error.html
 	errorHTML string
+
+	//go:embed template/cgo.html
+	cgoHTML string
+
+	//go:embed template/builds.html
+	buildsHTML string
 )
 
+// Functions available in all templates.
+var templateFuncs = template.FuncMap{
+	// Name of the instance from the config, empty if not set.
+	"siteName": func() string { return config.SiteName },
+	// For words in shell commands, e.g. file names from DownloadFilenameTemplate.
+	"shellQuote": shellQuote,
+}
+
+// shellQuote returns s as a single word for a POSIX shell, quoted if it contains
+// characters other than a safe set.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789@%+=:,./_-") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
 var (
-	buildTemplate  = template.Must(template.New("build").Parse(buildHTML + baseHTML))
-	moduleTemplate = template.Must(template.New("module").Parse(moduleHTML + baseHTML))
-	homeTemplate   = template.Must(template.New("home").Parse(homeHTML + baseHTML))
-	errorTemplate  = template.Must(template.New("error").Parse(errorHTML))
+	buildTemplate  = template.Must(template.New("build").Funcs(templateFuncs).Parse(buildHTML + baseHTML))
+	moduleTemplate = template.Must(template.New("module").Funcs(templateFuncs).Parse(moduleHTML + baseHTML))
+	homeTemplate   = template.Must(template.New("home").Funcs(templateFuncs).Parse(homeHTML + baseHTML))
+	errorTemplate  = template.Must(template.New("error").Funcs(templateFuncs).Parse(errorHTML))
+	cgoTemplate    = template.Must(template.New("cgo").Funcs(templateFuncs).Parse(cgoHTML + baseHTML))
+	buildsTemplate = template.Must(template.New("builds").Funcs(templateFuncs).Parse(buildsHTML + baseHTML))
 )
 
 var errRemote = errors.New("remote")
@@ -237,19 +374,14 @@ func serve(args []string) {
 		os.Exit(2)
 	}
 	if len(args) > 0 {
-		if err := parseConfig(args[0], &config); err != nil {
+		configPath = args[0]
+		if err := parseConfig(configPath, &config); err != nil {
 			log.Fatalf("parsing config file: %v", err)
 		}
 	}
-	if !strings.HasSuffix(config.GoProxy, "/") {
-		config.GoProxy += "/"
-	}
-	for i, url := range config.VerifierURLs {
-		if strings.HasSuffix(url, "/") {
-			config.VerifierURLs[i] = config.VerifierURLs[i][:len(config.VerifierURLs[i])-1]
-		}
-	}
+	normalizeConfig(&config)
 	resultDir = filepath.Join(config.DataDir, "result")
+	results = localResultStore{resultDir}
 	if config.SDKVersionStop != "" {
 		v, err := parseGoVersion(config.SDKVersionStop)
 		if err != nil {
@@ -257,6 +389,101 @@ func serve(args []string) {
 		}
 		sdkVersionStop = &v
 	}
+	if config.SDKVersionMin != "" {
+		v, err := parseGoVersion(config.SDKVersionMin)
+		if err != nil {
+			log.Fatalf("parsing SDKVersionMin %q from config: %s", config.SDKVersionMin, err)
+		}
+		if sdkVersionStop != nil && v.num() >= sdkVersionStop.num() {
+			log.Fatalf("SDKVersionMin %s in config must be older than SDKVersionStop %s", v, sdkVersionStop)
+		}
+		sdkVersionMin = &v
+	}
+	if len(config.Targets) > 0 {
+		if err := targets.restrict(config.Targets); err != nil {
+			log.Fatalf("Targets in config: %v", err)
+		}
+	}
+	if config.DefaultTarget != "" && !targets.valid(config.DefaultTarget) {
+		log.Fatalf("unknown DefaultTarget %q in config, must be goos/goarch of a supported target", config.DefaultTarget)
+	}
+	for _, p := range config.PinnedGoversions {
+		if !targets.valid(p.Target) {
+			log.Fatalf("unknown target %q in PinnedGoversions in config, must be goos/goarch of a supported target", p.Target)
+		}
+		if _, err := parseGoVersion(p.Goversion); err != nil {
+			log.Fatalf("parsing goversion %q for target %s in PinnedGoversions in config: %v", p.Goversion, p.Target, err)
+		} else if !goversionAllowed(p.Goversion) {
+			log.Fatalf("goversion %s for target %s in PinnedGoversions in config is not allowed by SDKVersionMin and SDKVersionStop", p.Goversion, p.Target)
+		}
+	}
+	for _, v := range config.GOFIPS140 {
+		if !validFIPS140(v) {
+			log.Fatalf("invalid value %q in GOFIPS140 in config", v)
+		}
+	}
+	for _, v := range config.GoExperiments {
+		if cv, err := canonicalExperiment(v); err != nil {
+			log.Fatalf("invalid value in GoExperiments in config: %v", err)
+		} else if cv != v {
+			log.Fatalf("value %q in GoExperiments in config must be sorted and unique, e.g. %q", v, cv)
+		}
+	}
+	for _, tag := range config.BuildTags {
+		if !validTag(tag) {
+			log.Fatalf("invalid build tag %q in BuildTags in config", tag)
+		} else if err := checkNetgoTags(tag); err != nil {
+			log.Fatalf("build tag in BuildTags in config: %v", err)
+		}
+	}
+	if config.DownloadFilenameTemplate != "" {
+		t, err := parseDownloadFilenameTemplate(config.DownloadFilenameTemplate)
+		if err != nil {
+			log.Fatalf("parsing DownloadFilenameTemplate from config: %v", err)
+		}
+		downloadFilenameTemplate = t
+	}
+	if config.GoProxyHTTPProxy != "" {
+		u, err := parseHTTPProxy(config.GoProxyHTTPProxy)
+		if err != nil {
+			log.Fatalf("parsing GoProxyHTTPProxy from config: %v", err)
+		}
+		goproxyHTTPProxy = u
+	}
+	if config.GoReleasesHTTPProxy != "" {
+		u, err := parseHTTPProxy(config.GoReleasesHTTPProxy)
+		if err != nil {
+			log.Fatalf("parsing GoReleasesHTTPProxy from config: %v", err)
+		}
+		goreleasesHTTPProxy = u
+	}
+	http.DefaultTransport.(*http.Transport).Proxy = httpProxy
+	if config.SDKSigningKeysFile != "" {
+		kr, err := readReleaseSigningKeys(config.SDKSigningKeysFile)
+		if err != nil {
+			log.Fatalf("SDKSigningKeysFile from config: %v", err)
+		}
+		slog.Info("added trusted keys for go toolchain signatures", "fingerprints", addReleaseSigningKeys(kr))
+	}
+	if config.ReadOnly && (config.CleanupBinariesAccessTimeAge > 0 || config.SelfVerifyInterval > 0) {
+		log.Fatalf("ReadOnly cannot be combined with CleanupBinariesAccessTimeAge or SelfVerifyInterval in config, they need builds")
+	}
+	readFavicon := func(path, name string, buf *[]byte) {
+		if path == "" {
+			return
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("reading %s from config: %v", name, err)
+		}
+		*buf = data
+	}
+	readFavicon(config.FaviconFile, "FaviconFile", &fileFaviconPng)
+	readFavicon(config.FaviconBuildingFile, "FaviconBuildingFile", &fileFaviconBuildingPng)
+	readFavicon(config.FaviconErrorFile, "FaviconErrorFile", &fileFaviconErrorPng)
+	if config.FollowerUpstream != "" && (!config.ReadOnly || config.FollowerVerifierKey == "") {
+		log.Fatalf("FollowerUpstream in config requires ReadOnly and FollowerVerifierKey, local builds would make the transparency logs diverge")
+	}
 
 	if buildInfo, ok := debug.ReadBuildInfo(); ok {
 		gobuildVersion = buildInfo.Main.Version
@@ -315,6 +542,12 @@ func serve(args []string) {
 		log.Fatalf("creating records file: %v", err)
 	}
 
+	// Complete or undo an append to the records & hashes files interrupted by a crash.
+	sumJournalPath = filepath.Join(config.DataDir, "sum", "journal")
+	if _, err := recoverSumJournal(); err != nil {
+		log.Fatalf("recovering from sum journal: %v", err)
+	}
+
 	// Verify the most recent additions to the records & hashes files are consistent.
 	if recordCount, err := verifySumState(); err != nil {
 		log.Fatal(err)
@@ -333,7 +566,22 @@ func serve(args []string) {
 	}).DialContext
 
 	initSDK()
+	if len(config.PinnedGoversions) > 0 {
+		_, supported, installed := listSDK()
+		for _, p := range config.PinnedGoversions {
+			if slices.Contains(supported, p.Goversion) || slices.Contains(installed, p.Goversion) {
+				continue
+			}
+			if len(supported) == 0 {
+				slog.Warn("could not check pinned goversion is supported, no list of supported go versions", "target", p.Target, "goversion", p.Goversion)
+				continue
+			}
+			log.Fatalf("goversion %s for target %s in PinnedGoversions in config is not supported and not installed", p.Goversion, p.Target)
+		}
+	}
+	initCommandTokens()
 	readRecentBuilds()
+	go initModuleIndex()
 
 	go coordinateBuilds()
 
@@ -346,6 +594,32 @@ func serve(args []string) {
 		log.Fatal("shutdown after sigint or sigterm")
 	}()
 
+	// Reload the parts of the config that can be changed at runtime.
+	hupc := make(chan os.Signal, 1)
+	signal.Notify(hupc, syscall.SIGHUP)
+	go func() {
+		for range hupc {
+			reloadConfig(configPath)
+		}
+	}()
+
+	if config.SelfVerifyInterval > 0 {
+		go selfVerify(config.SelfVerifyInterval)
+	}
+
+	if config.FollowerUpstream != "" {
+		baseURL := strings.TrimRight(config.FollowerUpstream, "/") + "/tlog"
+		client, _, err := newClient(config.FollowerVerifierKey, baseURL, filepath.Join(config.DataDir, "follow"))
+		if err != nil {
+			log.Fatalf("tlog client for FollowerUpstream: %v", err)
+		}
+		go follow(client)
+	}
+
+	if config.VerifierProbeInterval > 0 {
+		go probeVerifiers(config.VerifierProbeInterval)
+	}
+
 	if config.CleanupBinariesAccessTimeAge > 0 {
 		go func() {
 			time.Sleep(time.Minute)
@@ -357,6 +631,13 @@ func serve(args []string) {
 	}
 
 	http.Handle("/metrics", promhttp.Handler())
+	// Health checks for load balancers, on the admin listener only.
+	http.HandleFunc("/healthz", serveHealthz)
+	http.HandleFunc("/readyz", serveReadyz)
+	http.HandleFunc("/verifiers", serveVerifierStatus)
+	http.HandleFunc("/clearmodulecache", serveClearModuleCache)
+	http.HandleFunc("/tlog-replicate", serveTlogReplicate)
+	http.HandleFunc("/logdiff", serveLogDiff)
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
@@ -383,11 +664,30 @@ func serve(args []string) {
 		sconf.Describe(w, &emptyConfig) // nothing to do for errors
 	})
 
+	mux.HandleFunc("/builds", serveBuilds)
+	mux.HandleFunc("/archive/", serveArchive)
+	mux.HandleFunc("/batch/", serveBatch)
+	mux.HandleFunc("/modules.json", corsHandler(serveModuleSearch))
+	mux.HandleFunc("/recent.atom", serveRecentAtom)
+	mux.HandleFunc("/targets.json", corsHandler(serveTargets))
+	mux.HandleFunc("/badge.json", corsHandler(serveBadge))
+
 	mux.HandleFunc("/buildfailures.txt", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/plain")
 		http.ServeFile(w, r, filepath.Join(config.DataDir, "buildfailures.txt"))
 	})
 
+	if config.BinarySignerKeyFile != "" {
+		skey, err := os.ReadFile(config.BinarySignerKeyFile)
+		if err != nil {
+			log.Fatalf("reading binary signer key: %v", err)
+		}
+		binarySigner, err = note.NewSigner(strings.TrimSpace(string(skey)))
+		if err != nil {
+			log.Fatalf("new binary signer: %v", err)
+		}
+	}
+
 	if config.SignerKeyFile != "" {
 		skey, err := os.ReadFile(config.SignerKeyFile)
 		if err != nil {
@@ -402,6 +702,9 @@ func serve(args []string) {
 		for _, path := range sumdb.ServerPaths {
 			mux.Handle("/tlog"+path, h)
 		}
+		mux.HandleFunc("/tlog-status", corsHandler(serverOps{signer}.serveTlogStatus))
+	} else {
+		mux.HandleFunc("/tlog-status", corsHandler(serverOps{}.serveTlogStatus))
 	}
 
 	mux.HandleFunc("/img/gopher-dance-long.gif", func(w http.ResponseWriter, r *http.Request) {
@@ -410,15 +713,16 @@ func serve(args []string) {
 		w.Write(fileGopherDanceLongGif) // nothing to do for errors
 	})
 
-	// These prefixes are old. We still serve on these paths for compatibility.
+	// These prefixes are old. We still redirect from these paths for compatibility,
+	// permanently, so links get updated.
 	mux.HandleFunc("/m/", func(w http.ResponseWriter, r *http.Request) {
-		http.Redirect(w, r, r.URL.Path[2:], http.StatusTemporaryRedirect)
+		http.Redirect(w, r, r.URL.Path[2:], http.StatusPermanentRedirect)
 	})
 	mux.HandleFunc("/b/", func(w http.ResponseWriter, r *http.Request) {
-		http.Redirect(w, r, r.URL.Path[2:], http.StatusTemporaryRedirect)
+		http.Redirect(w, r, r.URL.Path[2:], http.StatusPermanentRedirect)
 	})
 	mux.HandleFunc("/r/", func(w http.ResponseWriter, r *http.Request) {
-		http.Redirect(w, r, r.URL.Path[2:], http.StatusTemporaryRedirect)
+		http.Redirect(w, r, r.URL.Path[2:], http.StatusPermanentRedirect)
 	})
 
 	mux.HandleFunc("/", serveHome)
@@ -524,6 +828,20 @@ func failf(w http.ResponseWriter, format string, args ...interface{}) {
 	statusfailf(status, w, errmsg)
 }
 
+// readErrorNotes returns the contents of config.ErrorNotesFile, for inclusion on
+// error pages. Empty if not configured or on error.
+func readErrorNotes() string {
+	if config.ErrorNotesFile == "" {
+		return ""
+	}
+	buf, err := os.ReadFile(config.ErrorNotesFile)
+	if err != nil {
+		slog.Error("reading error notes failed, skipping", "err", err)
+		return ""
+	}
+	return string(buf)
+}
+
 func statusfailf(status int, w http.ResponseWriter, errmsg string) {
 	msg := fmt.Sprintf("%d - %s - %s", status, http.StatusText(status), errmsg)
 	if status/100 == 5 {
@@ -535,48 +853,136 @@ func statusfailf(status int, w http.ResponseWriter, errmsg string) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.Header().Set("X-Content-Type-Options", "nosniff")
 	w.WriteHeader(status)
-	err := errorTemplate.Execute(w, map[string]string{"Message": msg})
+	err := errorTemplate.Execute(w, map[string]string{"Message": msg, "Notes": readErrorNotes()})
 	if err != nil {
 		slog.Error("executing template for error", "err", err)
 	}
 }
 
-func serveLog(w http.ResponseWriter, r *http.Request, p string) {
-	f, err := os.Open(p)
+// baseURL returns the scheme and host the request was made to, for absolute
+// URLs in responses.
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// serveLog serves the log.gz of the result with key as plain text. Clients
+// accepting gzip get the stored file, with support for conditional and range
+// requests. For other clients, the file is decompressed (or transcoded) on the
+// fly.
+func serveLog(w http.ResponseWriter, r *http.Request, key string) {
+	f, err := results.Open(key, "log.gz")
 	if err != nil {
 		failf(w, "%w: open log.gz: %v", errServer, err)
 		return
 	}
 	defer f.Close()
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	serveGzipFile(w, r, p, f)
+	if logEncoding(r) != "gzip" {
+		serveGzipFile(w, r, key+"/log.gz", f)
+		return
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		failf(w, "%w: stat log.gz: %v", errServer, err)
+		return
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	w.Header().Set("Content-Encoding", "gzip")
+	http.ServeContent(w, r, "", fi.ModTime(), f)
 }
 
+// logEncoding returns the content-encoding serveLog uses for r. Gzip is
+// preferred because logs are stored gzipped.
+func logEncoding(r *http.Request) string {
+	if acceptsEncoding(r, "gzip") {
+		return "gzip"
+	}
+	return negotiateEncoding(r)
+}
+
+// serveGzipFile serves the gzip file src, with content-encoding negotiated with
+// the client. Gzip is sent as stored, zstd is transcoded on the fly, and for
+// clients accepting neither, the file is decompressed.
 func serveGzipFile(w http.ResponseWriter, r *http.Request, path string, src io.Reader) {
-	if acceptsGzip(r) {
+	w.Header().Add("Vary", "Accept-Encoding")
+	enc := negotiateEncoding(r)
+	if enc == "gzip" {
 		w.Header().Set("Content-Encoding", "gzip")
 		io.Copy(w, src) // nothing to do for errors
-	} else if gzr, err := gzip.NewReader(src); err != nil {
+		return
+	}
+	gzr, err := gzip.NewReader(src)
+	if err != nil {
 		failf(w, "%w: decompressing %q: %s", errServer, path, err)
-	} else {
-		io.Copy(w, gzr) // nothing to do for errors
+		return
+	}
+	if enc == "zstd" {
+		zw, err := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			failf(w, "%w: zstd writer: %s", errServer, err)
+			return
+		}
+		w.Header().Set("Content-Encoding", "zstd")
+		if _, err := io.Copy(zw, gzr); err != nil {
+			zw.Close()
+			return // nothing to do for errors
+		}
+		zw.Close() // nothing to do for errors
+		return
 	}
+	io.Copy(w, gzr) // nothing to do for errors
 }
 
-func acceptsGzip(r *http.Request) bool {
-	s := r.Header.Get("Accept-Encoding")
-	t := strings.Split(s, ",")
-	for _, e := range t {
-		e = strings.TrimSpace(e)
-		tt := strings.Split(e, ";")
-		if len(tt) > 1 && t[1] == "q=0" {
-			continue
+// negotiateEncoding returns the content-encoding to use for a response to r:
+// "zstd", "gzip", or empty for no encoding. The encoding with the highest q-value
+// in the Accept-Encoding header is used. On ties, gzip is preferred: we store
+// gzip, and zstd needs transcoding.
+func negotiateEncoding(r *http.Request) string {
+	gz := encodingQuality(r, "gzip")
+	zs := encodingQuality(r, "zstd")
+	if gz > 0 && gz >= zs {
+		return "gzip"
+	} else if zs > 0 {
+		return "zstd"
+	}
+	return ""
+}
+
+// acceptsEncoding returns whether the Accept-Encoding header of r lists encoding
+// enc, without a q-value of 0.
+func acceptsEncoding(r *http.Request, enc string) bool {
+	return encodingQuality(r, enc) > 0
+}
+
+// encodingQuality returns the q-value for encoding enc in the Accept-Encoding
+// header of r, 0 if it isn't listed. An explicit entry for enc takes precedence
+// over "*". Entries without q-value have quality 1.
+func encodingQuality(r *http.Request, enc string) float64 {
+	wildcard := 0.0
+	for _, e := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		t := strings.Split(e, ";")
+		name := strings.TrimSpace(t[0])
+		v := 1.0
+		for _, param := range t[1:] {
+			if s, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if f, err := strconv.ParseFloat(s, 64); err == nil && f >= 0 && f <= 1 {
+					v = f
+				} else {
+					v = 0
+				}
+			}
 		}
-		if tt[0] == "gzip" {
-			return true
+		if strings.EqualFold(name, enc) {
+			return v
+		} else if name == "*" {
+			wildcard = v
 		}
 	}
-	return false
+	return wildcard
 }
 
 func verifySumState() (int64, error) {
@@ -601,52 +1007,64 @@ func verifySumState() (int64, error) {
 	if err != nil {
 		return -1, fmt.Errorf("reading last record: %v", err)
 	}
-	hashes, err := tlog.StoredHashes(lastRecordNum, records[0], hashReader{})
+	if err := verifyRecord(lastRecordNum, records[0]); err != nil {
+		return -1, fmt.Errorf("last record: %v", err)
+	}
+	return numRecords, nil
+}
+
+// verifyRecord checks that the stored hashes for record number num with data are
+// present in the hashes file, that the recordnumber file in the result store
+// points to the record, and that the binary, if present, matches the sum in the record.
+// Hashes for earlier records are read from the hashes file, they must have been
+// verified for a full verification.
+func verifyRecord(num int64, data []byte) error {
+	hashes, err := tlog.StoredHashes(num, data, hashReader{})
 	if err != nil {
-		return -1, fmt.Errorf("calculating hashes for most recent record: %v", err)
+		return fmt.Errorf("calculating hashes for record: %v", err)
 	}
 	buf := make([]byte, len(hashes)*tlog.HashSize)
-	if _, err := hashesFile.ReadAt(buf, tlog.StoredHashIndex(0, lastRecordNum)*tlog.HashSize); err != nil {
-		return -1, fmt.Errorf("reading hashes for verification: %v", err)
+	if _, err := hashesFile.ReadAt(buf, tlog.StoredHashIndex(0, num)*tlog.HashSize); err != nil {
+		return fmt.Errorf("reading hashes for verification: %v", err)
 	}
 	for i := range hashes {
 		o := i * tlog.HashSize
 		h := buf[o : o+tlog.HashSize]
 		if !bytes.Equal(hashes[i][:], h) {
-			return -1, fmt.Errorf("hash %d mismatch for last record %d, got %x, expect %x", i, lastRecordNum, h, hashes[i][:])
+			return fmt.Errorf("hash %d mismatch for record %d, got %x, expect %x", i, num, h, hashes[i][:])
 		}
 	}
 
 	// Also check if the recordnumber file is available, i.e. if a lookup will succeed.
-	record, err := parseRecord(records[0])
+	record, err := parseRecord(data)
 	if err != nil {
-		return -1, fmt.Errorf("parsing last record: %v", err)
+		return fmt.Errorf("parsing record: %v", err)
 	}
-	if buf, err := os.ReadFile(filepath.Join(record.storeDir(), "recordnumber")); err != nil {
-		return -1, fmt.Errorf("open recordnumber: %v", err)
-	} else if num, err := strconv.ParseInt(string(buf), 10, 64); err != nil {
-		return -1, fmt.Errorf("parse recordnumber from file: %v", err)
-	} else if num != lastRecordNum {
-		return -1, fmt.Errorf("inconsistent last recordnumber %d, expected %d", num, lastRecordNum)
+	if buf, err := readResultFile(record.storeKey(), "recordnumber"); err != nil {
+		return fmt.Errorf("open recordnumber: %v", err)
+	} else if rnum, err := strconv.ParseInt(string(buf), 10, 64); err != nil {
+		return fmt.Errorf("parse recordnumber from file: %v", err)
+	} else if rnum != num {
+		return fmt.Errorf("inconsistent recordnumber %d, expected %d", rnum, num)
 	}
 
 	// And check if the hash of the binary matches the sum.
 	h := sha256.New()
-	f, err := os.Open(filepath.Join(record.storeDir(), "binary.gz"))
+	f, err := results.Open(record.storeKey(), "binary.gz")
 	if err != nil && errors.Is(err, fs.ErrNotExist) {
-		return numRecords, nil
+		return nil
 	} else if err != nil {
-		return -1, fmt.Errorf("open binary.gz for verification: %v", err)
+		return fmt.Errorf("open binary.gz for verification: %v", err)
 	}
 	defer f.Close()
 	if gzr, err := gzip.NewReader(f); err != nil {
-		return -1, fmt.Errorf("gzip reader for binary.gz: %v", err)
+		return fmt.Errorf("gzip reader for binary.gz: %v", err)
 	} else if _, err := io.Copy(h, gzr); err != nil {
-		return -1, fmt.Errorf("reading binary.gz for verification: %v", err)
+		return fmt.Errorf("reading binary.gz for verification: %v", err)
 	} else if sum := "0" + base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:20]); sum != record.Sum {
-		return -1, fmt.Errorf("latest binary.gz sum mismatch, got %s, expect %s", sum, record.Sum)
+		return fmt.Errorf("binary.gz sum mismatch, got %s, expect %s", sum, record.Sum)
 	} else if err := f.Close(); err != nil {
-		return -1, fmt.Errorf("close binary.gz: %v", err)
+		return fmt.Errorf("close binary.gz: %v", err)
 	}
-	return numRecords, nil
+	return nil
 }
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// resultStore holds the files of build results, both successful and failed:
+// binary.gz, log.gz, recordnumber, etc. Files are stored per build, under a key
+// from buildSpec.storeKey. The records and hashes files of the transparency log
+// are not in the result store, they are always local.
+//
+// Files of new results are first written to a local temporary directory in
+// resultDir, then stored with Put.
+type resultStore interface {
+	// Open opens a file of a result. Errors for absent files match fs.ErrNotExist.
+	Open(key, name string) (resultFile, error)
+
+	// Stat returns information about a file of a result, for its size and
+	// modification time. Errors for absent files match fs.ErrNotExist.
+	Stat(key, name string) (fs.FileInfo, error)
+
+	// Exists returns whether a result is present for key.
+	Exists(key string) (bool, error)
+
+	// Put stores the files of local directory dir as the result for key, and
+	// removes dir. Key must not yet exist. The recordnumber file must only become
+	// visible after the other files, a lookup succeeds once it is present.
+	Put(key, dir string) error
+
+	// PutFile stores local file path as file name of the existing result key,
+	// replacing the file if present, and removes path.
+	PutFile(key, name, path string) error
+
+	// Remove removes a single file of a result.
+	Remove(key, name string) error
+
+	// RemoveAll removes all files of the result for key.
+	RemoveAll(key string) error
+}
+
+// resultFile is an opened file from a resultStore.
+type resultFile interface {
+	io.ReadSeekCloser
+	Stat() (fs.FileInfo, error)
+}
+
+// Result store, set at startup. Currently always the local file system, in
+// resultDir.
+var results resultStore
+
+// readResultFile returns the contents of a file of a result.
+func readResultFile(key, name string) ([]byte, error) {
+	f, err := results.Open(key, name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// resultFileExists returns whether a file of a result is present, e.g.
+// "recordnumber" for successful builds. Errors are treated as absent.
+func resultFileExists(key, name string) bool {
+	_, err := results.Stat(key, name)
+	return err == nil
+}
+
+// localResultStore stores results in directories in the local file system.
+type localResultStore struct {
+	dir string // Typically resultDir.
+}
+
+func (s localResultStore) path(key, name string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(key), name)
+}
+
+func (s localResultStore) Open(key, name string) (resultFile, error) {
+	f, err := os.Open(s.path(key, name))
+	if err != nil {
+		// Prevent a typed nil in the interface.
+		return nil, err
+	}
+	return f, nil
+}
+
+func (s localResultStore) Stat(key, name string) (fs.FileInfo, error) {
+	return os.Stat(s.path(key, name))
+}
+
+func (s localResultStore) Exists(key string) (bool, error) {
+	if _, err := os.Stat(s.path(key, "")); err == nil {
+		return true, nil
+	} else if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else {
+		return false, err
+	}
+}
+
+// Put renames dir, so dir must be in the same file system, which is why temporary
+// directories are created in resultDir. The rename makes all files visible at
+// once.
+func (s localResultStore) Put(key, dir string) error {
+	return os.Rename(dir, s.path(key, ""))
+}
+
+func (s localResultStore) PutFile(key, name, path string) error {
+	return os.Rename(path, s.path(key, name))
+}
+
+func (s localResultStore) Remove(key, name string) error {
+	return os.Remove(s.path(key, name))
+}
+
+// RemoveAll first moves the directory away, so the result is absent at once.
+func (s localResultStore) RemoveAll(key string) error {
+	dir := s.path(key, "")
+	tmpdir := dir + ".remove"
+	if err := os.Rename(dir, tmpdir); err != nil {
+		return fmt.Errorf("moving away directory: %v", err)
+	}
+	if err := os.RemoveAll(tmpdir); err != nil {
+		return fmt.Errorf("removing directory: %v", err)
+	}
+	return nil
+}
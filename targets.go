@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// targetsResponse is returned by the /targets.json endpoint, and used by "gobuild
+// get -list-targets".
+type targetsResponse struct {
+	Module     string
+	Version    string // Resolved, e.g. from "latest".
+	Dir        string // Package directory, "/" for the module root.
+	Goversion  string // Resolved, e.g. from "latest".
+	Stripped   bool
+	Netgo      bool     `json:",omitempty"` // Built with build tags netgo and osusergo.
+	Vendor     bool     `json:",omitempty"` // Built with -mod=vendor.
+	Tags       string   `json:",omitempty"` // Build tags, comma-separated, if any.
+	FIPS140    string   `json:",omitempty"` // GOFIPS140, if any.
+	Experiment string   `json:",omitempty"` // GOEXPERIMENT, if any.
+	Targets    []string // In form goos/goarch, for which a successful build exists.
+}
+
+// serveTargets returns the targets with successful builds for a module, version,
+// package and goversion from query parameters "module", "version" (default
+// latest), "dir" (default "/"), "goversion" (default latest), "stripped", "netgo",
+// "vendor", "tags", "fips140" and "goexperiment".
+// Builds are not started.
+func serveTargets(w http.ResponseWriter, r *http.Request) {
+	defer observePage("targets", time.Now())
+
+	if r.Method != "GET" {
+		http.Error(w, "405 - Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	mod := r.FormValue("module")
+	version := r.FormValue("version")
+	if version == "" {
+		version = "latest"
+	}
+	dir := r.FormValue("dir")
+	if dir == "" {
+		dir = "/"
+	}
+	goversion := r.FormValue("goversion")
+	stripped := r.FormValue("stripped") == "true"
+	netgo := r.FormValue("netgo") == "true"
+	vendor := r.FormValue("vendor") == "true"
+	tags, err := canonicalTags(r.FormValue("tags"))
+	if err != nil {
+		http.Error(w, "400 - bad request - "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	fips140 := r.FormValue("fips140")
+	if fips140 != "" && !validFIPS140(fips140) {
+		http.Error(w, "400 - bad request - invalid fips140", http.StatusBadRequest)
+		return
+	}
+	experiment := r.FormValue("goexperiment")
+	if experiment != "" {
+		experiment, err = canonicalExperiment(experiment)
+		if err != nil {
+			http.Error(w, "400 - bad request - invalid goexperiment", http.StatusBadRequest)
+			return
+		}
+	}
+	if mod == "" || path.Clean(mod) != mod || !strings.HasPrefix(dir, "/") || path.Clean(dir) != dir {
+		http.Error(w, "400 - bad request - missing or invalid module or dir", http.StatusBadRequest)
+		return
+	}
+	if !checkAllowedRespond(w, mod, version) {
+		return
+	}
+
+	if goversion == "" || goversion == "latest" {
+		goversion, _, _ = listSDK()
+	}
+	if _, err := parseGoVersion(goversion); err != nil {
+		http.Error(w, "400 - bad request - bad goversion: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	info, err := resolveModuleVersion(r.Context(), mod, version)
+	if err != nil {
+		http.Error(w, "400 - bad request - resolving module version: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	bs := buildSpec{mod, info.Version, dir, "", "", goversion, stripped, false, netgo, vendor, tags, fips140, experiment}
+	resp := targetsResponse{mod, info.Version, dir, goversion, stripped, netgo, vendor, tags, fips140, experiment, []string{}}
+	for _, t := range targets.get() {
+		tbs := bs
+		tbs.Goos = t.Goos
+		tbs.Goarch = t.Goarch
+		if resultFileExists(tbs.storeKey(), "recordnumber") {
+			resp.Targets = append(resp.Targets, t.osarch())
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.Debug("writing targets response", "err", err)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/sumdb/note"
+)
+
+// testconfig parses a config file, and with -thorough also checks the files,
+// directories, keys and verifiers it references.
+func testconfig(args []string) {
+	flags := flag.NewFlagSet("testconfig", flag.ExitOnError)
+	thorough := flags.Bool("thorough", false, "Also check that key files are readable and contain valid keys, that the verifier key matches the signer key, that directories are writable, and that verifiers are reachable.")
+	flags.Usage = func() {
+		log.Println("usage: gobuild testconfig [flags] gobuild.conf")
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	flags.Parse(args)
+	args = flags.Args()
+	if len(args) != 1 {
+		flags.Usage()
+	}
+	if err := parseConfig(args[0], &config); err != nil {
+		log.Fatalf("parsing config file: %v", err)
+	}
+	if *thorough {
+		if errs := checkConfig(); len(errs) > 0 {
+			for _, err := range errs {
+				log.Printf("error: %v", err)
+			}
+			log.Fatalf("config has %d error(s)", len(errs))
+		}
+	}
+	log.Printf("config OK")
+}
+
+// checkConfig checks the files, directories, keys and verifiers referenced in
+// the config, returning all problems found.
+func checkConfig() (errs []error) {
+	addf := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	checkSigner := func(field, path string) note.Signer {
+		if path == "" {
+			return nil
+		}
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			addf("%s: reading signer key: %v", field, err)
+			return nil
+		}
+		signer, err := note.NewSigner(strings.TrimSpace(string(buf)))
+		if err != nil {
+			addf("%s: parsing signer key in %s: %v", field, path, err)
+			return nil
+		}
+		return signer
+	}
+	signer := checkSigner("SignerKeyFile", config.SignerKeyFile)
+	checkSigner("BinarySignerKeyFile", config.BinarySignerKeyFile)
+
+	if config.VerifierKey != "" {
+		verifier, err := note.NewVerifier(config.VerifierKey)
+		if err != nil {
+			addf("VerifierKey: parsing verifier key: %v", err)
+		} else if signer != nil && (signer.Name() != verifier.Name() || signer.KeyHash() != verifier.KeyHash()) {
+			addf("VerifierKey: does not match the signer key in SignerKeyFile, generate both with subcommand genkey")
+		}
+	} else if signer != nil {
+		addf("VerifierKey: missing, required for the signer key in SignerKeyFile")
+	}
+	if config.FollowerVerifierKey != "" {
+		if _, err := note.NewVerifier(config.FollowerVerifierKey); err != nil {
+			addf("FollowerVerifierKey: parsing verifier key: %v", err)
+		}
+	}
+
+	if config.SDKSigningKeysFile != "" {
+		if _, err := readReleaseSigningKeys(config.SDKSigningKeysFile); err != nil {
+			addf("SDKSigningKeysFile: %v", err)
+		}
+	}
+
+	for _, f := range []struct{ field, path string }{
+		{"InstanceNotesFile", config.InstanceNotesFile},
+		{"FaviconFile", config.FaviconFile},
+		{"FaviconBuildingFile", config.FaviconBuildingFile},
+		{"FaviconErrorFile", config.FaviconErrorFile},
+	} {
+		if f.path == "" {
+			continue
+		}
+		if fd, err := os.Open(f.path); err != nil {
+			addf("%s: %v", f.field, err)
+		} else {
+			fd.Close()
+		}
+	}
+
+	dirs := []struct{ field, path string }{
+		{"DataDir", config.DataDir},
+		{"SDKDir", config.SDKDir},
+		{"HomeDir", config.HomeDir},
+		{"LogDir", config.LogDir},
+	}
+	if config.HTTPS != nil {
+		dirs = append(dirs, struct{ field, path string }{"HTTPS.ACME.CertDir", config.HTTPS.ACME.CertDir})
+	}
+	for _, d := range dirs {
+		if d.path == "" {
+			continue
+		}
+		if err := checkWritableDir(d.path); err != nil {
+			addf("%s: %v", d.field, err)
+		}
+	}
+
+	for _, url := range config.VerifierURLs {
+		if err := probeVerifier(url); err != nil {
+			addf("VerifierURLs: verifier %s not reachable: %v", url, err)
+		}
+	}
+	return errs
+}
+
+// checkWritableDir checks that files can be created in dir. If dir does not yet
+// exist, gobuild creates it, so its closest existing parent must be writable.
+func checkWritableDir(dir string) error {
+	for {
+		fi, err := os.Stat(dir)
+		if err != nil && errors.Is(err, fs.ErrNotExist) {
+			parent := filepath.Dir(dir)
+			if parent == dir {
+				return err
+			}
+			dir = parent
+			continue
+		} else if err != nil {
+			return err
+		} else if !fi.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+		break
+	}
+	f, err := os.CreateTemp(dir, "gobuild-testconfig")
+	if err != nil {
+		return fmt.Errorf("directory not writable: %v", err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
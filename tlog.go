@@ -1,10 +1,14 @@
 package main
 
 import (
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"log/slog"
+	"math"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 
 	"golang.org/x/mod/sumdb/tlog"
@@ -13,6 +17,27 @@ import (
 // The on-disk record is 512 bytes: 2-byte big endian size, followed by n bytes content, followed by zero bytes.
 const diskRecordSize = 512
 
+var errRecordTooLarge = errors.New("record too large")
+
+// checkRecordSize returns an error if a record for a successful build of bs
+// would not fit in the records file. Used before building, so we don't find out
+// only after a successful compile.
+func checkRecordSize(bs buildSpec) error {
+	// Largest possible filesize and a sum of valid length.
+	br := buildResult{bs, math.MaxInt64, "0" + strings.Repeat("x", 27), ""}
+	if config.RecordSHA256 {
+		br.SHA256 = strings.Repeat("0", 2*sha256.Size)
+	}
+	msg, err := br.packRecord()
+	if err != nil {
+		return fmt.Errorf("%w: %v", errBadModule, err)
+	}
+	if len(msg) > diskRecordSize-2 {
+		return fmt.Errorf("%w: record for this build in the transparency log would be up to %d bytes, the maximum is %d bytes; the combination of module path, package directory and versions is too long", errRecordTooLarge, len(msg), diskRecordSize-2)
+	}
+	return nil
+}
+
 func treeSize() (int64, error) {
 	if info, err := recordsFile.Stat(); err != nil {
 		return 0, err
@@ -28,14 +53,28 @@ var addSumMutex sync.Mutex
 
 // Add (successful) build result to transparency log. Returns record number.
 // Tmpdir is the directory where the build files reside, where addSum writes the
-// "recordnumber" file. This directory is renamed to its final directory in
-// resultDir as last step in addSum.
+// "recordnumber" file. This directory is put in the result store as last step in
+// addSum.
 //
 // With the current approach, we need to store to multiple locations: records,
-// hashes, and the result directory. We cannot make the changes atomically. If an
-// error happens halfway through, we are in an inconsistent state. We do log an
-// error in that case, and will detect it and fail on startup.
+// hashes, and the result store. We cannot make the changes atomically. So we
+// first write the intended change to a journal. If an error happens halfway
+// through, the change is completed or undone based on the journal, immediately,
+// or at startup after a crash.
 func addSum(tmpdir string, br buildResult) (rnum int64, rerr error) {
+	// Pack record and check validity, but don't write yet.
+	msg, err := br.packRecord()
+	if err != nil {
+		metricTlogAddErrors.Inc()
+		return -1, err
+	}
+	return addRecord(tmpdir, br, msg)
+}
+
+// addRecord adds record msg for build result br to the transparency log, see
+// addSum. Used directly when following another instance, for adding records
+// as received.
+func addRecord(tmpdir string, br buildResult, msg []byte) (rnum int64, rerr error) {
 	defer func() {
 		if rerr != nil {
 			metricTlogAddErrors.Inc()
@@ -50,11 +89,11 @@ func addSum(tmpdir string, br buildResult) (rnum int64, rerr error) {
 	addSumMutex.Lock()
 	defer addSumMutex.Unlock()
 
-	storeDir := br.storeDir()
-	if _, err := os.Stat(storeDir); err == nil {
-		return -1, fmt.Errorf("store dir for build result already exists")
-	} else if !os.IsNotExist(err) {
-		return -1, fmt.Errorf("stat on store dir for build result: %v", err)
+	storeKey := br.storeKey()
+	if exists, err := results.Exists(storeKey); err != nil {
+		return -1, fmt.Errorf("checking for build result in result store: %v", err)
+	} else if exists {
+		return -1, fmt.Errorf("build result already exists in result store")
 	}
 
 	// Find the next/new record number we'll be adding.
@@ -84,13 +123,8 @@ func addSum(tmpdir string, br buildResult) (rnum int64, rerr error) {
 		return -1, fmt.Errorf("writing index file %s: %v", pl, err)
 	}
 
-	// Pack record and check validity, but don't write yet.
-	msg, err := br.packRecord()
-	if err != nil {
-		return -1, err
-	}
 	if len(msg) > diskRecordSize-2 {
-		return -1, fmt.Errorf("record too large")
+		return -1, fmt.Errorf("%w: %d bytes", errRecordTooLarge, len(msg))
 	}
 
 	// Calculate the hashes we need to write for the new record.
@@ -104,6 +138,28 @@ func addSum(tmpdir string, br buildResult) (rnum int64, rerr error) {
 		return -1, fmt.Errorf("writing sum log: %v", err)
 	}
 
+	// Write the intent to the journal before making any permanent change.
+	journal := sumJournal{recordNumber, recordsSize, hashesSize, tmpdir, storeKey}
+	if err := writeSumJournal(journal); err != nil {
+		return -1, fmt.Errorf("writing journal: %v", err)
+	}
+	// From now on, complete or undo the change when an operation below fails. If that
+	// fails too, startup will try again.
+	defer func() {
+		if rerr == nil {
+			return
+		}
+		if completed, err := recoverSumJournal(); err != nil {
+			metricTlogConsistencyErrors.Inc()
+			slog.Error("CRITICAL: Failure while adding record, and recovering from journal failed. This means the records and hashes files and result dir are likely in inconsistent state!", "recordnumber", recordNumber, "key", br.String(), "storekey", storeKey, "err", rerr, "recovererr", err)
+		} else if completed {
+			slog.Warn("completed adding record from journal after error", "recordnumber", recordNumber, "key", br.String(), "err", rerr)
+			rnum, rerr = recordNumber, nil
+		} else {
+			slog.Warn("undid adding record from journal after error", "recordnumber", recordNumber, "key", br.String(), "err", rerr)
+		}
+	}()
+
 	// Combine the hashes into a single buffer so we can do one big write. This is our
 	// first permanent write. It's more likely to complete succeed or fail with a
 	// single write, and a single write is faster.
@@ -114,14 +170,6 @@ func addSum(tmpdir string, br buildResult) (rnum int64, rerr error) {
 	if _, err := hashesFile.Write(hashBuf); err != nil {
 		return -1, fmt.Errorf("write hashes: %v", err)
 	}
-	// Now that we wrote something permanently, warn loudly when operations below fail.
-	// todo: Use better storage mechanism, with transactions.
-	defer func() {
-		if rerr != nil {
-			metricTlogConsistencyErrors.Inc()
-			slog.Error("CRITICAL: Failure while adding record. This means the records and hashes files and result dir are likely in inconsistent state!", "recordnumber", recordNumber, "key", br.String(), "storedir", br.storeDir(), "err", rerr)
-		}
-	}()
 	if err := hashesFile.Sync(); err != nil {
 		return -1, fmt.Errorf("sync hashes file: %v", err)
 	}
@@ -137,9 +185,12 @@ func addSum(tmpdir string, br buildResult) (rnum int64, rerr error) {
 		return -1, fmt.Errorf("sync records file: %v", err)
 	}
 
-	// Put the tmp directory in place. From now on, lookups will succeed.
-	if err := os.Rename(tmpdir, storeDir); err != nil {
-		return -1, fmt.Errorf("renaming to final directory in resultDir: %w", err)
+	// Put the tmp directory in the result store. From now on, lookups will succeed.
+	if err := results.Put(storeKey, tmpdir); err != nil {
+		return -1, fmt.Errorf("storing build result: %w", err)
+	}
+	if err := clearSumJournal(); err != nil {
+		return -1, err
 	}
 
 	metricTlogRecords.Inc()
@@ -8,6 +8,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/mjl-/gobuild/internal/sumdb"
 
@@ -17,11 +18,18 @@ import (
 type clientOps struct {
 	localDir string
 	baseURL  string
+
+	// For atomic compare and replace in WriteConfig, the client can be used
+	// concurrently.
+	configMutex sync.Mutex
 }
 
 var _ sumdb.ClientOps = (*clientOps)(nil)
 
-func newClient(vkey string, baseURL string) (*sumdb.Client, *clientOps, error) {
+// newClient returns a client for the transparency log of the gobuild instance
+// with verifier key vkey. If baseURL is empty, it is derived from the name in
+// the key. State is kept in localDir, or in the user cache directory if empty.
+func newClient(vkey, baseURL, localDir string) (*sumdb.Client, *clientOps, error) {
 	verifier, err := note.NewVerifier(vkey)
 	if err != nil {
 		return nil, nil, fmt.Errorf("parsing verifier key: %v", err)
@@ -37,13 +45,16 @@ func newClient(vkey string, baseURL string) (*sumdb.Client, *clientOps, error) {
 	}
 	baseURL = strings.TrimRight(baseURL, "/")
 
-	dir, err := os.UserCacheDir()
-	if err != nil {
-		return nil, nil, err
+	if localDir == "" {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, nil, err
+		}
+		localDir = filepath.Join(dir, "gobuild", "sumclient", verifier.Name())
 	}
 	ops := &clientOps{
-		filepath.Join(dir, "gobuild", "sumclient", verifier.Name()),
-		baseURL,
+		localDir: localDir,
+		baseURL:  baseURL,
 	}
 
 	if ovkey, err := ops.ReadConfig("key"); err != nil {
@@ -105,6 +116,9 @@ func (c *clientOps) ReadConfig(file string) ([]byte, error) {
 func (c *clientOps) WriteConfig(file string, old, new []byte) error {
 	// log.Printf("client: WriteConfig %s", file)
 
+	c.configMutex.Lock()
+	defer c.configMutex.Unlock()
+
 	p := filepath.Join(c.localDir, "config", file)
 	if old != nil {
 		cur, err := c.ReadConfig(file)
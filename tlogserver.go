@@ -2,11 +2,13 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/fs"
 	"log/slog"
+	"net/http"
 	"os"
-	"path/filepath"
 	"strconv"
 	"time"
 
@@ -59,6 +61,62 @@ func (s serverOps) Signed(ctx context.Context) (result []byte, rerr error) {
 	}
 }
 
+// tlogStatus is returned by the /tlog-status endpoint, for monitoring the
+// transparency log.
+type tlogStatus struct {
+	TreeSize   int64  `json:"treeSize"`
+	SignedNote string `json:"signedNote,omitempty"` // Latest signed tree head. Absent without signer key.
+}
+
+// serveTlogStatus returns the current size of the transparency log and, if a
+// signer is configured, the signed tree head, as served on /tlog/latest.
+func (s serverOps) serveTlogStatus(w http.ResponseWriter, r *http.Request) {
+	defer observePage("tlogstatus", time.Now())
+
+	if r.Method != "GET" {
+		http.Error(w, "405 - Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var status tlogStatus
+	if s.signer == nil {
+		n, err := treeSize()
+		if err != nil {
+			failf(w, "%w: reading tree size: %v", errServer, err)
+			return
+		}
+		status.TreeSize = n
+	} else {
+		// Parse the tree size from the signed note, so they are consistent.
+		signed, err := s.Signed(r.Context())
+		if err != nil {
+			failf(w, "%w: signing tree head: %v", errServer, err)
+			return
+		}
+		n, err := note.Open(signed, note.VerifierList())
+		var uerr *note.UnverifiedNoteError
+		if errors.As(err, &uerr) {
+			n = uerr.Note
+		} else if err != nil {
+			failf(w, "%w: parsing signed tree head: %v", errServer, err)
+			return
+		}
+		tree, err := tlog.ParseTree([]byte(n.Text))
+		if err != nil {
+			failf(w, "%w: parsing tree: %v", errServer, err)
+			return
+		}
+		status.TreeSize = tree.N
+		status.SignedNote = string(signed)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		slog.Debug("writing tlog status response", "err", err)
+	}
+}
+
 // ReadRecords returns the content for the n records id through id+n-1.
 func (s serverOps) ReadRecords(ctx context.Context, id, n int64) (results [][]byte, rerr error) {
 	// log.Printf("server: ReadRecords %d %d", id, n)
@@ -102,7 +160,7 @@ func (s serverOps) Lookup(ctx context.Context, key string) (results int64, rerr
 	}
 
 	if bs.Goversion == "latest" {
-		bs.Goversion, _, _ = listSDK()
+		bs.Goversion = latestGoversion(bs.Goos, bs.Goarch)
 	}
 
 	if !targets.valid(bs.Goos + "/" + bs.Goarch) {
@@ -116,9 +174,8 @@ func (s serverOps) Lookup(ctx context.Context, key string) (results int64, rerr
 	}
 	bs.Version = info.Version
 
-	p := filepath.Join(bs.storeDir(), "recordnumber")
-	if buf, err := os.ReadFile(p); err != nil {
-		if os.IsNotExist(err) {
+	if buf, err := readResultFile(bs.storeKey(), "recordnumber"); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
 			return lookupBuild(ctx, bs)
 		}
 		return -1, err
@@ -133,15 +190,14 @@ func (s serverOps) Lookup(ctx context.Context, key string) (results int64, rerr
 // For an absent build, err wil be nil.
 // For other errors, err will be set.
 func (s serverOps) lookupResult(ctx context.Context, bs buildSpec) (recordNumber int64, br *buildResult, binaryPresent, failed bool, err error) {
-	p := filepath.Join(bs.storeDir(), "recordnumber")
-	if buf, err := os.ReadFile(p); err != nil {
-		if !os.IsNotExist(err) {
+	key := bs.storeKey()
+	if buf, err := readResultFile(key, "recordnumber"); err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
 			return -1, nil, false, false, err
 		}
-		lp := filepath.Join(bs.storeDir(), "log.gz")
-		if _, err := os.Stat(lp); err == nil {
+		if _, err := results.Stat(key, "log.gz"); err == nil {
 			return -1, nil, false, true, nil
-		} else if os.IsNotExist(err) {
+		} else if errors.Is(err, fs.ErrNotExist) {
 			return -1, nil, false, false, nil
 		} else {
 			return 0, nil, false, false, err
@@ -153,7 +209,7 @@ func (s serverOps) lookupResult(ctx context.Context, bs buildSpec) (recordNumber
 	} else if record, err := parseRecord(records[0]); err != nil {
 		return -1, nil, false, false, err
 	} else {
-		if _, err := os.Stat(filepath.Join(bs.storeDir(), "binary.gz")); err == nil {
+		if _, err := results.Stat(key, "binary.gz"); err == nil {
 			binaryPresent = true
 		}
 		return num, record, binaryPresent, false, nil
@@ -164,7 +220,12 @@ func (s serverOps) lookupResult(ctx context.Context, bs buildSpec) (recordNumber
 // cause a http 404 response for the lookup.
 func lookupBuild(ctx context.Context, bs buildSpec) (int64, error) {
 	// Before attempting to build, check we don't have a failed build already.
-	if _, err := os.Stat(filepath.Join(bs.storeDir(), "log.gz")); err == nil {
+	if resultFileExists(bs.storeKey(), "log.gz") {
+		return -1, os.ErrNotExist
+	}
+
+	// Read-only instances only serve records of existing builds.
+	if config.ReadOnly {
 		return -1, os.ErrNotExist
 	}
 
@@ -174,15 +235,15 @@ func lookupBuild(ctx context.Context, bs buildSpec) (int64, error) {
 	// may also host bad crawlers.
 
 	// Attempt to build.
-	if err := prepareBuild(bs); err != nil {
-		if errors.Is(err, errBadGoversion) || errors.Is(err, os.ErrNotExist) || errors.Is(err, errNotExist) || errors.Is(err, errBadModule) || errors.Is(err, errBadVersion) {
+	if err := prepareBuild(ctx, bs, buildVerify); err != nil {
+		if errors.Is(err, errBadGoversion) || errors.Is(err, os.ErrNotExist) || errors.Is(err, errNotExist) || errors.Is(err, errBadModule) || errors.Is(err, errBadVersion) || errors.Is(err, errRecordTooLarge) || errors.Is(err, errBadExperiment) || errors.Is(err, errBadBuildTags) || errors.Is(err, errBadFIPS140) {
 			return -1, os.ErrNotExist
 		}
 		return -1, fmt.Errorf("preparing build: %w", err)
 	}
 
 	eventc := make(chan buildUpdate, 100)
-	registerBuild(bs, "", eventc)
+	registerBuild(bs, "", false, eventc)
 
 	for {
 		select {
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Last probe result for each verifier, by base URL.
+var verifierProbes = struct {
+	sync.Mutex
+	m map[string]verifierProbe
+}{m: map[string]verifierProbe{}}
+
+type verifierProbe struct {
+	Time     time.Time
+	Duration time.Duration
+	Err      error
+}
+
+// probeVerifiers periodically requests the signed tree head of the transparency
+// log of each verifier, so unreachable verifiers are noticed before a build fails
+// on them.
+func probeVerifiers(interval time.Duration) {
+	for {
+		probeVerifiersOnce()
+		time.Sleep(interval)
+	}
+}
+
+func probeVerifiersOnce() {
+	urls := configVerifierURLs()
+
+	var wg sync.WaitGroup
+	for _, url := range urls {
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+
+			t0 := time.Now()
+			err := probeVerifier(url)
+			d := time.Since(t0)
+			if err != nil {
+				slog.Warn("verifier probe failed", "err", err, "baseurl", url)
+				metricVerifierUp.WithLabelValues(url).Set(0)
+			} else {
+				metricVerifierUp.WithLabelValues(url).Set(1)
+				metricVerifierProbeDuration.WithLabelValues(url).Observe(d.Seconds())
+			}
+
+			verifierProbes.Lock()
+			verifierProbes.m[url] = verifierProbe{t0, d, err}
+			verifierProbes.Unlock()
+		}(url)
+	}
+	wg.Wait()
+
+	// Forget verifiers that were removed from the config with a reload.
+	verifierProbes.Lock()
+	defer verifierProbes.Unlock()
+	for url := range verifierProbes.m {
+		if !slices.Contains(urls, url) {
+			delete(verifierProbes.m, url)
+			metricVerifierUp.DeleteLabelValues(url)
+			metricVerifierProbeDuration.DeleteLabelValues(url)
+		}
+	}
+}
+
+// probeVerifier requests /tlog/latest from the verifier at baseURL. Unlike
+// /tlog-status, it is served by all gobuild versions.
+func probeVerifier(baseURL string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", baseURL+"/tlog/latest", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 16*1024))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
+
+// serveVerifierStatus shows the last probe result for each verifier, as plain text.
+func serveVerifierStatus(w http.ResponseWriter, r *http.Request) {
+	verifierProbes.Lock()
+	urls := make([]string, 0, len(verifierProbes.m))
+	probes := map[string]verifierProbe{}
+	for url, p := range verifierProbes.m {
+		urls = append(urls, url)
+		probes[url] = p
+	}
+	verifierProbes.Unlock()
+	sort.Strings(urls)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if config.VerifierProbeInterval <= 0 {
+		fmt.Fprintln(w, "verifier probing disabled, see VerifierProbeInterval in config")
+		return
+	}
+	if len(urls) == 0 {
+		fmt.Fprintln(w, "no verifiers probed yet")
+		return
+	}
+	for _, url := range urls {
+		p := probes[url]
+		status := "ok"
+		if p.Err != nil {
+			status = "error: " + p.Err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%s ago\t%dms\t%s\n", url, time.Since(p.Time).Round(time.Second), p.Duration.Milliseconds(), status)
+	}
+}
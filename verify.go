@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+)
+
+// verify checks that the sum of a local file is in the transparency log for a
+// build.
+func verify(args []string) {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+
+	var (
+		verifierKey = flags.String("verifierkey", gobuildsOrgVerifierKey, "Verifier key for transparency log.")
+		baseURL     = flags.String("url", "", "URL for lookups of hashes at the transparency log. If empty, this is set based on the name of the verifier key, using HTTPS if name contains a dot and plain HTTP otherwise.")
+		verbose     = flags.Bool("verbose", false, "Print actions.")
+		sum         = flags.String("sum", "", "Sum the file must have, if set.")
+		target      = flags.String("target", "", "Target of the binary. Default is current GOOS/GOARCH.")
+		goversion   = flags.String("goversion", "latest", `Go toolchain/SDK version the binary was built with. Default "latest" resolves through go.dev/dl/.`)
+		stripped    = flags.Bool("stripped", false, "Binary is without symbol table and debug information.")
+		netgo       = flags.Bool("netgo", false, "Binary is built with build tags netgo and osusergo.")
+		vendor      = flags.Bool("vendor", false, "Binary is built with -mod=vendor.")
+		tags        = flags.String("tags", "", "Comma-separated build tags the binary was built with, if any.")
+		fips140     = flags.String("fips140", "", "GOFIPS140 the binary was built with, if any.")
+		experiment  = flags.String("goexperiment", "", "GOEXPERIMENT the binary was built with, if any.")
+	)
+
+	flags.Usage = func() {
+		log.Println("usage: gobuild verify [flags] module@version/package file")
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	flags.Parse(args)
+	args = flags.Args()
+	if len(args) != 2 {
+		flags.Usage()
+	}
+
+	if *verbose {
+		getLog = func(format string, args ...interface{}) {
+			log.Printf(format, args...)
+		}
+	}
+
+	bs := getBuildSpec(args[0], *target, *goversion, *stripped, *netgo, *vendor, *tags, *fips140, *experiment)
+
+	f, err := os.Open(args[1])
+	if err != nil {
+		log.Fatalf("open file: %v", err)
+	}
+	fileSum, err := readerSum(f)
+	if err != nil {
+		log.Fatalf("reading file: %v", err)
+	}
+	f.Close()
+	getLog("file %s has sum %s", args[1], fileSum)
+
+	if *sum != "" && *sum != fileSum {
+		log.Fatalf("file has sum %s, expected %s", fileSum, *sum)
+	}
+
+	client, _, err := newClient(*verifierKey, *baseURL, "")
+	if err != nil {
+		log.Fatalf("new client: %v", err)
+	}
+	key := bs.String()
+	getLog("looking up key %s", key)
+	_, data, err := client.Lookup(key)
+	if err != nil {
+		log.Fatalf("lookup: %v", err)
+	}
+
+	br, err := parseRecord(data)
+	if err != nil {
+		log.Fatalf("parsing record from remote: %v", err)
+	}
+
+	// Only "latest" for the module version and Go version may resolve to another value.
+	rbs := br.buildSpec
+	if bs.Version == "latest" {
+		bs.Version = rbs.Version
+	}
+	if bs.Goversion == "latest" {
+		bs.Goversion = rbs.Goversion
+	}
+	if rbs != bs {
+		log.Fatalf("lookup resolved to %s, expected %s", rbs.String(), bs.String())
+	}
+
+	if br.Sum != fileSum {
+		log.Fatalf("transparency log has sum %s for %s, file has sum %s", br.Sum, rbs.String(), fileSum)
+	}
+	log.Printf("verified: file has sum %s, matching transparency log for %s", fileSum, rbs.String())
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/mod/sumdb/tlog"
+)
+
+// verifylog checks the consistency of the transparency log in a data directory:
+// the stored hashes of all records, the recordnumber files and the binaries
+// that are still present. The server only verifies the last record at startup.
+// Meant to be run while the server is stopped, new records would be missed.
+func verifylog(args []string) {
+	flags := flag.NewFlagSet("verifylog", flag.ExitOnError)
+	flags.Usage = func() {
+		log.Println("usage: gobuild verifylog [data-dir]")
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	flags.Parse(args)
+	args = flags.Args()
+	if len(args) > 1 {
+		flags.Usage()
+	}
+	dataDir := "data"
+	if len(args) == 1 {
+		dataDir = args[0]
+	}
+
+	var err error
+	hashesFile, err = os.Open(filepath.Join(dataDir, "sum", "hashes"))
+	if err != nil {
+		log.Fatalf("open hashes file: %v", err)
+	}
+	recordsFile, err = os.Open(filepath.Join(dataDir, "sum", "records"))
+	if err != nil {
+		log.Fatalf("open records file: %v", err)
+	}
+	resultDir = filepath.Join(dataDir, "result")
+	results = localResultStore{resultDir}
+
+	numRecords, err := treeSize()
+	if err != nil {
+		log.Fatalf("finding number of records in tlog: %v", err)
+	}
+	if info, err := hashesFile.Stat(); err != nil {
+		log.Fatalf("stat on hashes file: %v", err)
+	} else if hashCount := tlog.StoredHashCount(numRecords); hashCount*tlog.HashSize != info.Size() {
+		log.Fatalf("inconsistent size of hashes file of %d bytes for %d records, should be %d", info.Size(), numRecords, hashCount*tlog.HashSize)
+	}
+
+	// Records are verified in order: the stored hashes for a record are calculated
+	// from stored hashes of earlier records, which have been verified by then.
+	const batch = 1000
+	for num := int64(0); num < numRecords; num += batch {
+		n := min(batch, numRecords-num)
+		records, err := serverOps{}.ReadRecords(context.Background(), num, n)
+		if err != nil {
+			log.Fatalf("reading records %d-%d: %v", num, num+n-1, err)
+		}
+		for i, data := range records {
+			if err := verifyRecord(num+int64(i), data); err != nil {
+				log.Fatalf("record %d: %v", num+int64(i), err)
+			}
+		}
+	}
+	fmt.Printf("%d records verified\n", numRecords)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/mod/module"
+)
+
+// Maximum number of modules in versionListCache.
+const versionListCacheMax = 1000
+
+// Cache of module version lists from the goproxy, keyed by module path. Only
+// successful fetches are cached.
+var versionListCache = struct {
+	sync.Mutex
+	entries map[string]versionList
+}{
+	entries: map[string]versionList{},
+}
+
+// Fetches of version lists in progress, shared by concurrent requests.
+var versionListFlights flights[versionList]
+
+// Maximum duration of fetching a version list and latest version, including
+// retries. Shared by all callers waiting for it.
+const versionListTimeout = time.Minute
+
+type versionList struct {
+	fetched  time.Time
+	versions []string
+	latest   string // Empty if unknown.
+}
+
+// listModuleVersions returns the versions of mod known to the goproxy, in the
+// order returned by the goproxy, and the version the goproxy resolves "latest"
+// to, empty if unknown. Results are cached for config.GoProxyListCacheTTL.
+// Errors are not cached.
+func listModuleVersions(ctx context.Context, mod string) ([]string, string, error) {
+	versionListCache.Lock()
+	vl, ok := versionListCache.entries[mod]
+	versionListCache.Unlock()
+	if ok && time.Since(vl.fetched) < config.GoProxyListCacheTTL {
+		metricGoproxyListCacheHit.Inc()
+		return vl.versions, vl.latest, nil
+	}
+	metricGoproxyListCacheMiss.Inc()
+
+	vl, err := versionListFlights.doContext(ctx, mod, versionListTimeout, func(ctx context.Context) (versionList, error) {
+		versions, err := fetchModuleVersions(ctx, mod)
+		if err != nil {
+			return versionList{}, err
+		}
+		latest, err := fetchLatestVersion(ctx, mod)
+		if err != nil {
+			slog.Debug("fetching latest module version", "err", err, "mod", mod)
+		}
+		vl := versionList{time.Now(), versions, latest}
+
+		versionListCache.Lock()
+		defer versionListCache.Unlock()
+		if len(versionListCache.entries) >= versionListCacheMax {
+			for m, e := range versionListCache.entries {
+				if time.Since(e.fetched) >= config.GoProxyListCacheTTL {
+					delete(versionListCache.entries, m)
+				}
+			}
+		}
+		if len(versionListCache.entries) >= versionListCacheMax {
+			versionListCache.entries = map[string]versionList{}
+		}
+		versionListCache.entries[mod] = vl
+		return vl, nil
+	})
+	return vl.versions, vl.latest, err
+}
+
+// Delays before retrying requests for version lists after temporary goproxy
+// errors.
+var versionListRetryDelays = []time.Duration{250 * time.Millisecond, time.Second}
+
+// fetchModuleVersions requests the version list for mod from the goproxy. Ctx is
+// from versionListFlights, not tied to a single http request: the result is
+// shared. Requests that fail with a connection error or 5xx response are retried
+// with backoff.
+func fetchModuleVersions(ctx context.Context, mod string) ([]string, error) {
+	t0 := time.Now()
+	defer func() {
+		metricGoproxyListDuration.Observe(time.Since(t0).Seconds())
+	}()
+
+	modPath, err := module.EscapePath(mod)
+	if err != nil {
+		return nil, fmt.Errorf("bad module path: %v", err)
+	}
+
+	u := fmt.Sprintf("%s%s/@v/list", config.GoProxy, modPath)
+	for attempt := 0; ; attempt++ {
+		l, temporary, err := fetchModuleVersionsAttempt(ctx, u)
+		if err == nil || !temporary || attempt >= len(versionListRetryDelays) {
+			return l, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(versionListRetryDelays[attempt]):
+		}
+	}
+}
+
+// fetchLatestVersion requests the version "latest" resolves to for mod from the
+// goproxy. The semver maximum of the version list is not the latest version for
+// modules with only pseudo-versions, or with retracted versions.
+func fetchLatestVersion(ctx context.Context, mod string) (string, error) {
+	modPath, err := module.EscapePath(mod)
+	if err != nil {
+		return "", fmt.Errorf("bad module path: %v", err)
+	}
+
+	u := fmt.Sprintf("%s%s/@latest", config.GoProxy, modPath)
+	mreq, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return "", fmt.Errorf("%w: preparing new http request: %v", errServer, err)
+	}
+	mreq.Header.Set("User-Agent", userAgent)
+	resp, err := http.DefaultClient.Do(mreq)
+	if err != nil {
+		return "", fmt.Errorf("%w: http request: %v", errServer, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("%w: http response from goproxy: %v", errRemote, resp.Status)
+	}
+	var info struct {
+		Version string
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&info); err != nil {
+		return "", fmt.Errorf("%w: parsing latest version from goproxy: %v", errRemote, err)
+	}
+	return info.Version, nil
+}
+
+// fetchModuleVersionsAttempt makes a single request for a version list. For
+// errors, temporary indicates whether a retry may succeed.
+func fetchModuleVersionsAttempt(ctx context.Context, u string) (l []string, temporary bool, err error) {
+	mreq, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: preparing new http request: %v", errServer, err)
+	}
+	mreq.Header.Set("User-Agent", userAgent)
+	resp, err := http.DefaultClient.Do(mreq)
+	if err != nil {
+		metricGoproxyListErrors.WithLabelValues("error").Inc()
+		return nil, true, fmt.Errorf("%w: http request: %v", errServer, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		metricGoproxyListErrors.WithLabelValues(fmt.Sprintf("%d", resp.StatusCode)).Inc()
+		return nil, resp.StatusCode/100 == 5, fmt.Errorf("%w: http response from goproxy: %v", errRemote, resp.Status)
+	}
+	buf, err := io.ReadAll(resp.Body)
+	if err != nil {
+		metricGoproxyListErrors.WithLabelValues("error").Inc()
+		return nil, true, fmt.Errorf("%w: reading versions from goproxy: %v", errRemote, err)
+	}
+	for _, s := range strings.Split(string(buf), "\n") {
+		if s != "" {
+			l = append(l, s)
+		}
+	}
+	return l, false, nil
+}